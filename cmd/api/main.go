@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/api"
+	"github.com/pararti/pinnacle-parser/internal/options"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func main() {
+	log := logger.NewLogger()
+	log.Info("Starting Pinnacle read API")
+
+	opts, args, err := options.NewOptions(os.Args[1:])
+	if err != nil {
+		log.Fatal("Failed to load options:", err)
+		return
+	}
+
+	if len(args) > 0 {
+		if err := runCommand(opts, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := opts.Validate(options.API); err != nil {
+		log.Fatal("Invalid settings:\n" + err.Error())
+	}
+	if err := log.Configure(opts.LoggerConfig()); err != nil {
+		log.Fatal("Invalid logging options:", err)
+	}
+	if names := opts.PlaintextSecrets(); len(names) > 0 {
+		log.Warn("Secrets are set in plain text, replace them with file:, env: or vault: references:", names)
+	}
+
+	db, err := consdb.NewPostgresDBClient(opts.DbConnection, log)
+	if err != nil {
+		log.Fatal("Failed to connect to PostgreSQL", err)
+		return
+	}
+	defer db.Close()
+
+	srv := &http.Server{
+		Addr:              opts.ApiAddress,
+		Handler:           api.NewServer(log, db).Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		log.Info("API listening on", opts.ApiAddress)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("API server failed:", err)
+		}
+	}()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigchan
+	log.Info("Caught signal terminating", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error("API shutdown failed:", err)
+	}
+}
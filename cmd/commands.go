@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/pararti/pinnacle-parser/internal/options"
+)
+
+const usage = `usage:
+  parser [flags]                  run the parser
+  parser [flags] config print     show effective settings with secrets redacted
+  parser [flags] config validate  check settings and list every problem
+
+flags: --config FILE (repeatable) and --<setting> VALUE for every setting in config/settings.yaml,
+environment variables PINNACLE_<SETTING> override files, flags override both`
+
+func runCommand(opts *options.Options, args []string) error {
+	switch strings.Join(args[:min(len(args), 2)], " ") {
+	case "config print":
+		return opts.Print(os.Stdout)
+	case "config validate":
+		return opts.ReportValidation(os.Stdout, options.Parser)
+	default:
+		return errors.New(usage)
+	}
+}
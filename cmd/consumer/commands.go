@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/consumer"
+	"github.com/pararti/pinnacle-parser/internal/options"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+const usage = `usage:
+  consumer [flags]              run the kafka consumer
+  consumer config print         show effective settings with secrets redacted
+  consumer config validate      check settings and list every problem
+  consumer dlq replay [--force] summarize dead letters; with --force move them back to their topics
+  consumer migrate up           apply pending database migrations
+  consumer migrate down [N]     revert the last N migrations (default 1)
+  consumer migrate status       list migrations and when they were applied
+  consumer latency report [D]   price change latency percentiles for the last D (default 1h)
+
+flags go before the command: --config FILE (repeatable) and --<setting> VALUE for every setting,
+environment variables PINNACLE_<SETTING> override files, flags override both`
+
+func runCommand(log *logger.Logger, opts *options.Options, args []string) error {
+	switch strings.Join(args[:min(len(args), 2)], " ") {
+	case "config print":
+		return opts.Print(os.Stdout)
+	case "config validate":
+		return opts.ReportValidation(os.Stdout, options.Consumer)
+	case "dlq replay":
+		return runReplay(log, opts, args[2:])
+	case "migrate up", "migrate down", "migrate status":
+		return runMigrate(log, opts, args[1], args[2:])
+	case "latency report":
+		return runLatencyReport(log, opts, args[2:])
+	default:
+		return errors.New(usage)
+	}
+}
+
+func runReplay(log *logger.Logger, opts *options.Options, args []string) error {
+	force := false
+	for _, arg := range args {
+		if arg != "--force" {
+			return errors.New("dlq replay accepts only --force")
+		}
+		force = true
+	}
+	return consumer.ReplayDeadLetters(log, opts, force)
+}
+
+func runMigrate(log *logger.Logger, opts *options.Options, action string, args []string) error {
+	db, err := consdb.NewPostgresDBClient(opts.DbConnection, log)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := consdb.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info("Applied migrations:", applied)
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return errors.New("migrate down expects a positive number of steps")
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Info("Reverted migrations:", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-24s %s\n", s.Version, s.Name, applied)
+		}
+	}
+
+	return nil
+}
+
+func runLatencyReport(log *logger.Logger, opts *options.Options, args []string) error {
+	window := time.Hour
+	if len(args) > 0 {
+		var err error
+		if window, err = time.ParseDuration(args[0]); err != nil || window <= 0 {
+			return errors.New("latency report expects a positive duration, e.g. 30m")
+		}
+	}
+
+	db, err := consdb.NewPostgresDBClient(opts.DbConnection, log)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stages, err := db.LatencyReport(context.Background(), time.Now().Add(-window))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-16s %8s %10s %10s %10s %10s\n", "stage", "count", "p50", "p90", "p99", "max")
+	for _, s := range stages {
+		fmt.Printf("%-16s %8d %10s %10s %10s %10s\n", s.Name, s.Count,
+			s.P50.Round(time.Millisecond), s.P90.Round(time.Millisecond),
+			s.P99.Round(time.Millisecond), s.Max.Round(time.Millisecond))
+	}
+	return nil
+}
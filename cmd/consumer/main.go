@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/pararti/pinnacle-parser/internal/alert"
 	"github.com/pararti/pinnacle-parser/internal/consumer"
+	"github.com/pararti/pinnacle-parser/internal/health"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
 	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
 )
 
@@ -15,12 +23,30 @@ func main() {
 	log.Info("Starting Pinnacle Kafka Consumer")
 
 	// Load options
-	opts, err := options.NewOptions()
+	opts, args, err := options.NewOptions(os.Args[1:])
 	if err != nil {
 		log.Fatal("Failed to load options:", err)
 		return
 	}
 
+	// Subcommands such as "dlq replay" or "migrate up" run instead of the consumer
+	if len(args) > 0 {
+		if err := runCommand(log, opts, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := opts.Validate(options.Consumer); err != nil {
+		log.Fatal("Invalid settings:\n" + err.Error())
+	}
+	if err := log.Configure(opts.LoggerConfig()); err != nil {
+		log.Fatal("Invalid logging options:", err)
+	}
+	if names := opts.PlaintextSecrets(); len(names) > 0 {
+		log.Warn("Secrets are set in plain text, replace them with file:, env: or vault: references:", names)
+	}
+
 	// Initialize Sentry
 	err = sentry.Init(sentry.ClientOptions{
 		Dsn:         opts.ConsumerSentry,
@@ -46,7 +72,57 @@ func main() {
 		sentry.CaptureMessage("Consumer started in test mode")
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background(), "pinnacle-consumer", opts.OtelEndpoint, opts.OtelSampleRatio)
+	if err != nil {
+		log.Error("Tracing initialization failed:", err)
+	} else {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_ = shutdownTracing(ctx)
+		}()
+	}
+
+	metrics.RegisterConsumer()
+	alerter := alert.Setup(log, "consumer", opts)
+
+	// Log levels, sampling, alert channels and thresholds are applied without a restart
+	reloader := options.NewReloader(log, opts, os.Args[1:], options.Consumer)
+	reloader.OnReload(func(o *options.Options) {
+		if err := log.Configure(o.LoggerConfig()); err != nil {
+			log.Error("Failed to apply logging options:", err)
+		}
+		tracing.SetSampleRatio(o.OtelSampleRatio)
+		alerter.Reconfigure(o)
+	})
+	go reloader.Watch()
+
 	// Create and start the consumer
 	c := consumer.NewConsumerKafka(log, opts)
+	alerter.AddRule(alert.ErrorRate("ошибки обработки сообщений", c.Counts,
+		func() float64 { return reloader.Current().AlertErrorRate }, 10))
+
+	if opts.MetricsAddress != "" {
+		checker := health.NewChecker()
+		checker.Live("poll", c.CheckPoll)
+		checker.Ready("kafka", c.CheckKafka)
+		checker.Ready("postgres", c.CheckPostgres)
+		go serveOps(log, opts.MetricsAddress, checker)
+	}
+
 	c.Start(opts.KafkaTopic)
 }
+
+// serveOps отдает метрики для Prometheus и пробы /healthz и /readyz. Сервер запускается после
+// подключения к kafka и миграций, до этого пробы недоступны
+func serveOps(log *logger.Logger, addr string, checker *health.Checker) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	checker.Register(mux)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	log.Info("Metrics and health checks listening on", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Metrics server failed:", err)
+	}
+}
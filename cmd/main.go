@@ -1,22 +1,44 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/getsentry/sentry-go"
 	app "github.com/pararti/pinnacle-parser/internal/core"
+	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
 )
 
 func main() {
-	appInit := app.InitApp()
+	l := logger.NewLogger()
 
-	// Set up logger path if specified
-	if appInit.Opts.LogPath != "" {
-		appInit.Logger.SetPath(appInit.Opts.LogPath)
+	o, args, err := options.NewOptions(os.Args[1:])
+	if err != nil {
+		l.Fatal("Не удалось загрузить настройки:", err)
+	}
+
+	// Подкоманды, например "config print", выполняются вместо парсера
+	if len(args) > 0 {
+		if err := runCommand(o, args); err != nil {
+			l.Fatal(err)
+		}
+		return
+	}
+
+	if err := o.Validate(options.Parser); err != nil {
+		l.Fatal("Некорректные настройки:\n" + err.Error())
 	}
 
+	appInit := app.InitApp(l, options.NewReloader(l, o, os.Args[1:], options.Parser))
+
 	// Initialize Sentry
-	err := sentry.Init(sentry.ClientOptions{
+	err = sentry.Init(sentry.ClientOptions{
 		Dsn:         appInit.Opts.ProducerSentry,
 		Environment: "production",
 		Debug:       appInit.Opts.TestMode,
@@ -41,6 +63,93 @@ func main() {
 		sentry.CaptureMessage("Producer started in test mode")
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background(), "pinnacle-parser", appInit.Opts.OtelEndpoint, appInit.Opts.OtelSampleRatio)
+	if err != nil {
+		appInit.Logger.Error("Tracing initialization failed:", err)
+	} else {
+		defer shutdownTracing(context.Background())
+	}
+
+	if appInit.Opts.HttpAddress != "" {
+		go appInit.State.Start(appInit.Opts.HttpAddress)
+	}
+	if appInit.Opts.GrpcAddress != "" {
+		go appInit.Grpc.Start(appInit.Opts.GrpcAddress)
+	}
+
+	if ms, ok := appInit.Storage.(*storage.MapStorage); ok && appInit.Opts.StateFile != "" {
+		go ms.RunCheckpoints(appInit.Opts.StateFile, appInit.Opts.CheckpointInterval, func(err error) {
+			appInit.Logger.Error("Не удалось сохранить состояние:", err)
+		})
+		go func() {
+			time.Sleep(appInit.Opts.RestoreGrace)
+			if n := ms.ExpireRestored(); n > 0 {
+				appInit.Logger.Info("Удалено восстановленных матчей, не пришедших после рестарта:", n)
+			}
+		}()
+		go saveOnShutdown(appInit, ms, shutdownTracing)
+	}
+
+	if rs, ok := appInit.Storage.(*storage.RedisStorage); ok {
+		go rs.RunOutbox(func(err error) {
+			appInit.Logger.Error("Не удалось повторить отправку из outbox redis:", err)
+		})
+	}
+
+	if sweeper, ok := appInit.Storage.(missingSweeper); ok && appInit.Opts.DeleteGrace > 0 {
+		go sweepMissing(appInit.Logger, sweeper, appInit.Opts.DeleteGrace)
+	}
+
+	go appInit.Reloader.Watch()
 	go appInit.Engine.Start(appInit.Opts)
 	appInit.Sender.Start(appInit.Opts.KafkaTopic)
 }
+
+// missingSweeper хранилище, которое удаляет пропавшие матчи по deleteGrace, даже если данные
+// их группы больше не приходят
+type missingSweeper interface {
+	ExpireMissing(now time.Time) (int, error)
+}
+
+// sweepMissing проверяет пропавшие матчи каждые полгрейса, но не чаще раза в секунду
+func sweepMissing(l *logger.Logger, s missingSweeper, grace time.Duration) {
+	ticker := time.NewTicker(max(grace/2, time.Second))
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		n, err := s.ExpireMissing(now)
+		if err != nil {
+			l.Error("Не удалось удалить пропавшие матчи:", err)
+			continue
+		}
+		if n > 0 {
+			l.Info("Удалено пропавших матчей, группа которых больше не приходит:", n)
+		}
+	}
+}
+
+// drainTimeout сколько при остановке ждать доставки уже отправленных в kafka событий
+const drainTimeout = 10 * time.Second
+
+// saveOnShutdown при остановке дожидается отправки забранных отличий и сохраняет состояние
+// вместе с незабранными, чтобы после рестарта не потерять изменения с последнего сохранения
+func saveOnShutdown(a *app.App, ms *storage.MapStorage, shutdownTracing func(context.Context) error) {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigchan
+
+	a.Logger.Info("Caught signal terminating", sig)
+	if n := a.Sender.Drain(drainTimeout); n > 0 {
+		a.Logger.Error("Не доставлено в kafka сообщений при остановке:", n)
+	}
+	if err := ms.SaveCheckpoint(a.Opts.StateFile); err != nil {
+		a.Logger.Error("Не удалось сохранить состояние:", err)
+	}
+	if shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_ = shutdownTracing(ctx)
+		cancel()
+	}
+	sentry.Flush(2 * time.Second)
+	os.Exit(0)
+}
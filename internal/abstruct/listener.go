@@ -0,0 +1,7 @@
+package abstruct
+
+// EventListener получает те же события, что отправляются в kafka.
+// data это срез []*parsed.Match, []*parsed.Straight или []int в зависимости от eventType
+type EventListener interface {
+	OnEvent(eventType int, data any)
+}
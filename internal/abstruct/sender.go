@@ -1,6 +1,11 @@
 package abstruct
 
+import "time"
+
 type Sender interface {
 	Send([]byte, *string)
 	Start(string)
+	// Drain останавливает отправку и ждет доставки уже отправленного не дольше timeout,
+	// возвращает число недоставленных сообщений
+	Drain(timeout time.Duration) int
 }
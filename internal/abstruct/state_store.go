@@ -0,0 +1,92 @@
+package abstruct
+
+import (
+	"context"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+)
+
+// StateStore состояние парсера: принимает данные источника, считает отличия от сохраненных
+// и уведомляет о них. Get* отдает отличие один раз, но после рестарта или падения экземпляра
+// хранилище может отдать его повторно целиком, поэтому доставка — хотя бы один раз
+type StateStore interface {
+	// SetMatches и SetBets не блокируются на отправителе: отличия копятся до вызова Get*.
+	// ctx несет span захвата, из которого пришли данные
+	SetMatches(ctx context.Context, matches []*parsed.Match)
+	SetBets(ctx context.Context, bets map[int][]*parsed.Straight)
+
+	// GetNewMatches и остальные Get* забирают все накопленные отличия. Несколько изменений
+	// одной записи между вызовами сливаются в одно. Origin указывает на захваты, в которых
+	// отличия были замечены, чтобы отправка продолжила их трассу
+	GetNewMatches() ([]*parsed.Match, tracing.Origin)
+	GetUpdatedMatches() ([]*parsed.Match, tracing.Origin)
+	GetDeletedMatches() ([]int, tracing.Origin)
+	// GetRestoredMatches матчи, вернувшиеся до истечения грейса удаления, целиком
+	GetRestoredMatches() ([]*parsed.Match, tracing.Origin)
+	GetNewBets() ([]*parsed.Straight, tracing.Origin)
+	GetUpdatedBets() ([]*parsed.Straight, tracing.Origin)
+
+	// Notifications каналы, по которым хранилище будит отправителя
+	Notifications() Notifications
+
+	// MatchList, Match, MatchBets и Bet возвращают копии, которые можно читать без блокировок
+	MatchList() []*parsed.Match
+	Match(id int) (*parsed.Match, bool)
+	MatchBets(matchID int) []*parsed.Straight
+	Bet(matchID int, key string) (*parsed.Straight, bool)
+	Stats() StateStats
+}
+
+// Outbox хранилище, которое держит найденные отличия до подтверждения их доставки в kafka.
+// Отправитель после каждого Get* забирает записи outbox через Taken и подтверждает их через Ack
+// после отчета о доставке. Неподтвержденные записи хранилище со временем отправляет повторно
+type Outbox interface {
+	// Taken возвращает записи outbox, отличия из которых забрали Get* для eventType
+	Taken(eventType int) []string
+	Ack(ids []string)
+}
+
+// Requeuer хранилище без outbox, которому отправитель возвращает отличия события, не принятого
+// kafka. data того же типа, что в EventListener. Отличия уходят повторно целиком: матчи как
+// MATCH_NEW, ставки как BET_NEW, удаления как MATCH_DELETE
+type Requeuer interface {
+	Requeue(eventType int, data any)
+}
+
+// Notifications сигналы о появившихся отличиях соответствующего вида. Сигнал не несет данных
+// и может объединять несколько изменений, после него нужно забрать отличия через Get*
+type Notifications struct {
+	MatchNew      <-chan struct{}
+	MatchUpd      <-chan struct{}
+	MatchDel      <-chan struct{}
+	MatchRestored <-chan struct{}
+	BetNew        <-chan struct{}
+	BetUpd        <-chan struct{}
+}
+
+// StateStats сводка о состоянии хранилища
+type StateStats struct {
+	Matches int `json:"matches"`
+	Markets int `json:"markets"`
+	// Missing матчи, которые пропали из данных источника и ждут удаления
+	Missing       int       `json:"missing"`
+	LastMatchesAt time.Time `json:"lastMatchesAt"`
+	LastBetsAt    time.Time `json:"lastBetsAt"`
+	Backlog       Backlog   `json:"backlog"`
+}
+
+// Backlog отличия, которые хранилище накопило, а отправитель еще не забрал. Растущий backlog
+// и старый OldestAt означают, что отправка в kafka не успевает за источником
+type Backlog struct {
+	NewMatches      int `json:"newMatches"`
+	UpdatedMatches  int `json:"updatedMatches"`
+	DeletedMatches  int `json:"deletedMatches"`
+	RestoredMatches int `json:"restoredMatches"`
+	NewBets         int `json:"newBets"`
+	UpdatedBets     int `json:"updatedBets"`
+	// Coalesced сколько сигналов слилось с еще не обработанными с момента старта
+	Coalesced uint64    `json:"coalesced"`
+	OldestAt  time.Time `json:"oldestAt"`
+}
@@ -0,0 +1,189 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+const (
+	// queueSize сколько оповещений может ждать отправки, остальные отбрасываются
+	queueSize = 64
+	// sendTimeout сколько ждать один нотификатор
+	sendTimeout = 10 * time.Second
+)
+
+// Severity важность оповещения
+type Severity string
+
+const (
+	SeverityError    Severity = "error"
+	SeverityCritical Severity = "critical"
+	SeverityResolved Severity = "resolved"
+)
+
+// Alert одно оповещение. Оповещения с одинаковым Key в пределах окна дедупликации
+// отправляются один раз
+type Alert struct {
+	Key      string
+	Severity Severity
+	Title    string
+	Text     string
+	Service  string
+	At       time.Time
+}
+
+// String текст оповещения для мессенджеров и почты
+func (a Alert) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s", a.Service, strings.ToUpper(string(a.Severity)), a.Title)
+	if a.Text != "" {
+		b.WriteString("\n")
+		b.WriteString(a.Text)
+	}
+	b.WriteString("\n")
+	b.WriteString(a.At.Format(time.RFC3339))
+	return b.String()
+}
+
+// Notifier доставляет оповещение в один канал
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Alerter рассылает оповещения по всем нотификаторам. Одинаковые оповещения подавляются
+// на dedupWindow, а всего отправляется не больше ratePerMinute в минуту; о подавленных
+// по лимиту сообщается в следующем отправленном
+type Alerter struct {
+	logger  *logger.Logger
+	service string
+	queue   chan Alert
+
+	mu            sync.Mutex
+	notifiers     []Notifier
+	dedupWindow   time.Duration
+	ratePerMinute int
+	lastSent      map[string]time.Time
+	windowStart   time.Time
+	windowSent    int
+	suppressed    int
+
+	rulesMu sync.Mutex
+	rules   []*ruleState
+}
+
+func New(l *logger.Logger, service string, notifiers []Notifier, dedupWindow time.Duration, ratePerMinute int) *Alerter {
+	return &Alerter{
+		logger:        l.Named("alert"),
+		service:       service,
+		notifiers:     notifiers,
+		queue:         make(chan Alert, queueSize),
+		dedupWindow:   dedupWindow,
+		ratePerMinute: ratePerMinute,
+		lastSent:      make(map[string]time.Time),
+	}
+}
+
+// SetLimits меняет окно дедупликации и лимит отправки
+func (a *Alerter) SetLimits(dedupWindow time.Duration, ratePerMinute int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dedupWindow, a.ratePerMinute = dedupWindow, ratePerMinute
+}
+
+// SetNotifiers заменяет каналы доставки. Без каналов оповещения не ставятся в очередь
+func (a *Alerter) SetNotifiers(notifiers []Notifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notifiers = notifiers
+}
+
+// Run отправляет оповещения из очереди, блокирует вызывающего
+func (a *Alerter) Run() {
+	for al := range a.queue {
+		a.send(al)
+	}
+}
+
+// Fire ставит оповещение в очередь, не дожидаясь отправки
+func (a *Alerter) Fire(al Alert) {
+	al, ok := a.admit(al)
+	if !ok {
+		return
+	}
+	select {
+	case a.queue <- al:
+	default:
+		a.mu.Lock()
+		a.suppressed++
+		a.mu.Unlock()
+	}
+}
+
+// FireSync отправляет оповещение сразу, например перед завершением процесса
+func (a *Alerter) FireSync(al Alert) {
+	if al, ok := a.admit(al); ok {
+		a.send(al)
+	}
+}
+
+// admit применяет дедупликацию и лимит отправки
+func (a *Alerter) admit(al Alert) (Alert, bool) {
+	if al.At.IsZero() {
+		al.At = time.Now()
+	}
+	if al.Service == "" {
+		al.Service = a.service
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.notifiers) == 0 {
+		return al, false
+	}
+	if last, ok := a.lastSent[al.Key]; ok && al.At.Sub(last) < a.dedupWindow {
+		return al, false
+	}
+	if al.At.Sub(a.windowStart) >= time.Minute {
+		a.windowStart, a.windowSent = al.At, 0
+	}
+	if a.ratePerMinute > 0 && a.windowSent >= a.ratePerMinute {
+		a.suppressed++
+		return al, false
+	}
+	a.windowSent++
+	a.lastSent[al.Key] = al.At
+	for key, at := range a.lastSent {
+		if al.At.Sub(at) >= a.dedupWindow {
+			delete(a.lastSent, key)
+		}
+	}
+
+	if a.suppressed > 0 {
+		al.Text += fmt.Sprintf("\n(подавлено оповещений по лимиту: %d)", a.suppressed)
+		a.suppressed = 0
+	}
+	return al, true
+}
+
+// send отправляет оповещение во все каналы. Ошибки пишутся на уровне warn, чтобы
+// не вызывать новые оповещения через хук логгера
+func (a *Alerter) send(al Alert) {
+	a.mu.Lock()
+	notifiers := a.notifiers
+	a.mu.Unlock()
+
+	for _, n := range notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := n.Notify(ctx, al); err != nil {
+			a.logger.Warn("Не удалось отправить оповещение через", n.Name()+":", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,91 @@
+package alert
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func newTestAlerter(t *testing.T, url string, dedupWindow time.Duration, ratePerMinute int) *Alerter {
+	t.Helper()
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	return New(l, "parser", []Notifier{NewWebhook(url)}, dedupWindow, ratePerMinute)
+}
+
+func TestAlerterDedup(t *testing.T) {
+	rec, srv := newRecorder(t, http.StatusOK, "ok")
+	a := newTestAlerter(t, srv.URL, time.Minute, 0)
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	a.FireSync(Alert{Key: "kafka", Severity: SeverityError, Title: "kafka", At: at})
+	a.FireSync(Alert{Key: "kafka", Severity: SeverityError, Title: "kafka", At: at.Add(30 * time.Second)})
+	a.FireSync(Alert{Key: "chrome", Severity: SeverityError, Title: "chrome", At: at.Add(30 * time.Second)})
+	a.FireSync(Alert{Key: "kafka", Severity: SeverityError, Title: "kafka", At: at.Add(time.Minute)})
+
+	var keys []string
+	for _, body := range rec.requests() {
+		keys = append(keys, body["key"].(string))
+		if body["service"] != "parser" {
+			t.Errorf("service = %v, want parser", body["service"])
+		}
+	}
+	if strings.Join(keys, ",") != "kafka,chrome,kafka" {
+		t.Fatalf("sent keys = %v, want kafka,chrome,kafka", keys)
+	}
+}
+
+func TestAlerterRateLimit(t *testing.T) {
+	rec, srv := newRecorder(t, http.StatusOK, "ok")
+	a := newTestAlerter(t, srv.URL, time.Minute, 2)
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	for i, key := range []string{"a", "b", "c", "d"} {
+		a.FireSync(Alert{Key: key, Severity: SeverityError, Title: key, At: at.Add(time.Duration(i) * time.Second)})
+	}
+	if n := len(rec.requests()); n != 2 {
+		t.Fatalf("sent in the first minute = %d, want 2", n)
+	}
+
+	a.FireSync(Alert{Key: "e", Severity: SeverityError, Title: "e", At: at.Add(time.Minute)})
+	requests := rec.requests()
+	if len(requests) != 3 {
+		t.Fatalf("sent after the window = %d, want 3", len(requests))
+	}
+	if text := requests[2]["text"].(string); !strings.Contains(text, "(подавлено оповещений по лимиту: 2)") {
+		t.Fatalf("text = %q, want the suppressed count", text)
+	}
+}
+
+// TestReconfigure каналы включаются и выключаются перезагрузкой настроек без новой очереди и хука
+func TestReconfigure(t *testing.T) {
+	rec, srv := newRecorder(t, http.StatusOK, "ok")
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	a := New(l, "parser", nil, time.Minute, 0)
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	a.FireSync(Alert{Key: "a", Title: "a", At: at})
+	if n := len(rec.requests()); n != 0 {
+		t.Fatalf("sent without channels = %d", n)
+	}
+
+	o := &options.Options{AlertWebhookURL: srv.URL, AlertDedupWindow: time.Minute}
+	a.Reconfigure(o)
+	a.FireSync(Alert{Key: "a", Title: "a", At: at})
+	if n := len(rec.requests()); n != 1 {
+		t.Fatalf("sent after enabling webhook = %d, want 1", n)
+	}
+
+	o.AlertWebhookURL = ""
+	a.Reconfigure(o)
+	a.FireSync(Alert{Key: "b", Title: "b", At: at})
+	if n := len(rec.requests()); n != 1 {
+		t.Fatalf("sent after disabling webhook = %d, want still 1", n)
+	}
+}
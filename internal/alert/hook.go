@@ -0,0 +1,69 @@
+package alert
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// digits числа в тексте записи: id, оффсеты и длительности не должны мешать дедупликации
+var digits = regexp.MustCompile(`[0-9]+`)
+
+type hook struct {
+	a *Alerter
+}
+
+// Hook превращает записи логгера уровня error и fatal в оповещения. Fatal отправляется
+// синхронно, потому что сразу после записи процесс завершается
+func Hook(a *Alerter) logrus.Hook {
+	return hook{a: a}
+}
+
+func (h hook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h hook) Fire(e *logrus.Entry) error {
+	pkg, _ := e.Data["pkg"].(string)
+	if pkg == "alert" {
+		return nil
+	}
+	al := Alert{
+		Key:      "log:" + pkg + ":" + digits.ReplaceAllString(e.Message, "#"),
+		Severity: SeverityError,
+		Title:    "ошибка в логе",
+		Text:     e.Message + formatFields(e.Data),
+		At:       e.Time,
+	}
+	if pkg != "" {
+		al.Title += " " + pkg
+	}
+
+	if e.Level <= logrus.FatalLevel {
+		al.Severity = SeverityCritical
+		h.a.FireSync(al)
+		return nil
+	}
+	h.a.Fire(al)
+	return nil
+}
+
+func formatFields(data logrus.Fields) string {
+	if len(data) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s=%v", k, data[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+
+	"github.com/bytedance/sonic"
+)
+
+var httpClient = &http.Client{}
+
+// Telegram отправляет оповещения через Bot API. baseURL можно заменить локальной заглушкой
+type Telegram struct {
+	baseURL string
+	token   string
+	chatID  string
+}
+
+func NewTelegram(baseURL, token, chatID string) *Telegram {
+	return &Telegram{baseURL: strings.TrimSuffix(baseURL, "/"), token: token, chatID: chatID}
+}
+
+func (t *Telegram) Name() string { return "telegram" }
+
+func (t *Telegram) Notify(ctx context.Context, a Alert) error {
+	body, err := sonic.Marshal(map[string]any{
+		"chat_id":                  t.chatID,
+		"text":                     a.String(),
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// токен входит в адрес, поэтому postJSON отдает ошибки без адреса
+	respBody, err := postJSON(ctx, t.baseURL+"/bot"+t.token+"/sendMessage", body)
+	var reply struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if len(respBody) > 0 && sonic.Unmarshal(respBody, &reply) == nil && !reply.Ok {
+		return errors.New("telegram: " + reply.Description)
+	}
+	return err
+}
+
+// Webhook отправляет оповещения POST запросом с JSON, поле text совместимо с входящими
+// вебхуками Slack и Mattermost
+type Webhook struct {
+	url string
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url}
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	body, err := sonic.Marshal(map[string]any{
+		"text":     a.String(),
+		"key":      a.Key,
+		"severity": a.Severity,
+		"title":    a.Title,
+		"service":  a.Service,
+		"at":       a.At,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = postJSON(ctx, w.url, body)
+	return err
+}
+
+// Email отправляет оповещения письмом через SMTP, без user авторизация не используется
+type Email struct {
+	addr     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func NewEmail(addr, user, password, from string, to []string) *Email {
+	return &Email{addr: addr, user: user, password: password, from: from, to: to}
+}
+
+func (e *Email) Name() string { return "email" }
+
+// Notify не учитывает ctx: net/smtp не поддерживает отмену
+func (e *Email) Notify(_ context.Context, a Alert) error {
+	var auth smtp.Auth
+	if e.user != "" {
+		host, _, _ := strings.Cut(e.addr, ":")
+		auth = smtp.PlainAuth("", e.user, e.password, host)
+	}
+
+	subject := fmt.Sprintf("[%s] %s: %s", a.Service, a.Severity, a.Title)
+	msg := "From: " + e.from + "\r\n" +
+		"To: " + strings.Join(e.to, ", ") + "\r\n" +
+		"Subject: " + strings.ReplaceAll(subject, "\n", " ") + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		strings.ReplaceAll(a.String(), "\n", "\r\n")
+	return smtp.SendMail(e.addr, auth, e.from, e.to, []byte(msg))
+}
+
+// postJSON отправляет body и возвращает тело ответа. Ответ не 2xx считается ошибкой,
+// тело при этом все равно возвращается
+func postJSON(ctx context.Context, target string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, stripURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, stripURL(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return respBody, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return respBody, nil
+}
+
+// stripURL убирает адрес из ошибки запроса, в нем могут быть токены
+func stripURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
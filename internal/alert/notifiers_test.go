@@ -0,0 +1,194 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+var testAlert = Alert{
+	Key:      "kafka",
+	Severity: SeverityCritical,
+	Title:    "kafka недоступна",
+	Text:     "dial tcp: connection refused",
+	Service:  "parser",
+	At:       time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+}
+
+// recorder httptest сервер, который запоминает тела запросов
+type recorder struct {
+	mu     sync.Mutex
+	paths  []string
+	bodies []map[string]any
+}
+
+func newRecorder(t *testing.T, status int, reply string) (*recorder, *httptest.Server) {
+	t.Helper()
+	rec := &recorder{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := sonic.Unmarshal(raw, &body); err != nil {
+			t.Errorf("request body %q: %v", raw, err)
+		}
+		rec.mu.Lock()
+		rec.paths = append(rec.paths, r.URL.Path)
+		rec.bodies = append(rec.bodies, body)
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (r *recorder) requests() []map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]map[string]any(nil), r.bodies...)
+}
+
+func TestTelegram(t *testing.T) {
+	rec, srv := newRecorder(t, http.StatusOK, `{"ok":true}`)
+	if err := NewTelegram(srv.URL+"/", "123:secret", "-100").Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.paths) != 1 || rec.paths[0] != "/bot123:secret/sendMessage" {
+		t.Fatalf("paths = %v", rec.paths)
+	}
+	body := rec.requests()[0]
+	if body["chat_id"] != "-100" || body["text"] != testAlert.String() || body["disable_web_page_preview"] != true {
+		t.Fatalf("body = %v", body)
+	}
+}
+
+func TestTelegramErrorsHideToken(t *testing.T) {
+	_, srv := newRecorder(t, http.StatusUnauthorized, `{"ok":false,"description":"Unauthorized"}`)
+	err := NewTelegram(srv.URL, "123:secret", "-100").Notify(context.Background(), testAlert)
+	if err == nil || err.Error() != "telegram: Unauthorized" {
+		t.Fatalf("err = %v, want telegram: Unauthorized", err)
+	}
+
+	srv.Close()
+	err = NewTelegram(srv.URL, "123:secret", "-100").Notify(context.Background(), testAlert)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("err = %v, want an error without the token", err)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	rec, srv := newRecorder(t, http.StatusOK, "ok")
+	if err := NewWebhook(srv.URL).Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	body := rec.requests()[0]
+	want := map[string]any{
+		"text":     testAlert.String(),
+		"key":      "kafka",
+		"severity": "critical",
+		"title":    "kafka недоступна",
+		"service":  "parser",
+		"at":       "2026-10-18T12:00:00Z",
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s = %v, want %v", k, body[k], v)
+		}
+	}
+
+	_, failing := newRecorder(t, http.StatusInternalServerError, "")
+	if err := NewWebhook(failing.URL).Notify(context.Background(), testAlert); err == nil {
+		t.Fatal("5xx is not an error")
+	}
+}
+
+// fakeSMTP принимает одно письмо без расширений и авторизации и возвращает его текст
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	mail := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 end with .")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 queued")
+				mail <- data.String()
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), mail
+}
+
+func TestEmail(t *testing.T) {
+	addr, mail := fakeSMTP(t)
+	email := NewEmail(addr, "", "", "parser@example.com", []string{"ops@example.com", "dev@example.com"})
+	if err := email.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-mail
+	for _, want := range []string{
+		"From: parser@example.com\r\n",
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [parser] critical: kafka недоступна\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\n[parser] CRITICAL: kafka недоступна\r\ndial tcp: connection refused\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message has no %q:\n%s", want, msg)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package alert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Rule условие, при котором нужно оповещение. Evaluate вызывается периодически и
+// возвращает firing и описание состояния
+type Rule interface {
+	Name() string
+	Evaluate(now time.Time) (firing bool, text string)
+}
+
+type ruleState struct {
+	rule   Rule
+	firing bool
+}
+
+// AddRule добавляет правило, которое проверяет Watch
+func (a *Alerter) AddRule(r Rule) {
+	a.rulesMu.Lock()
+	defer a.rulesMu.Unlock()
+	a.rules = append(a.rules, &ruleState{rule: r})
+}
+
+// Watch проверяет правила каждые interval. Оповещение уходит, когда правило срабатывает
+// и когда перестает срабатывать
+func (a *Alerter) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		a.evaluate(now)
+	}
+}
+
+func (a *Alerter) evaluate(now time.Time) {
+	a.rulesMu.Lock()
+	defer a.rulesMu.Unlock()
+
+	for _, s := range a.rules {
+		firing, text := s.rule.Evaluate(now)
+		if firing == s.firing {
+			continue
+		}
+		s.firing = firing
+
+		al := Alert{Key: "rule:" + s.rule.Name(), Severity: SeverityCritical, Title: s.rule.Name(), Text: text, At: now}
+		if !firing {
+			// resolved не должно подавляться недавним срабатыванием того же правила
+			al.Key += ":resolved"
+			al.Severity = SeverityResolved
+		}
+		a.Fire(al)
+	}
+}
+
+// staleRule срабатывает, если событие не происходило дольше maxAge
+type staleRule struct {
+	name    string
+	what    string
+	last    func() time.Time
+	maxAge  func() time.Duration
+	started time.Time
+}
+
+// Stale правило "нет <what> дольше maxAge". Пока last нулевой, время отсчитывается от создания правила.
+// maxAge читается при каждой проверке, чтобы порог можно было менять без перезапуска; 0 выключает правило
+func Stale(name, what string, last func() time.Time, maxAge func() time.Duration) Rule {
+	return &staleRule{name: name, what: what, last: last, maxAge: maxAge, started: time.Now()}
+}
+
+func (r *staleRule) Name() string { return r.name }
+
+func (r *staleRule) Evaluate(now time.Time) (bool, string) {
+	last := r.last()
+	if last.IsZero() {
+		last = r.started
+	}
+	age, maxAge := now.Sub(last).Round(time.Second), r.maxAge()
+	return maxAge > 0 && age > maxAge, fmt.Sprintf("%s: последнее %s назад, порог %s", r.what, age, maxAge)
+}
+
+// errorRateRule срабатывает, если доля ошибок с прошлой проверки выше threshold
+type errorRateRule struct {
+	name      string
+	counts    func() (total, failed uint64)
+	threshold func() float64
+	minEvents uint64
+
+	mu         sync.Mutex
+	prevTotal  uint64
+	prevFailed uint64
+}
+
+// ErrorRate правило "доля ошибок выше threshold". counts возвращает растущие счетчики,
+// доля считается по приросту между проверками; при приросте меньше minEvents правило не срабатывает.
+// threshold читается при каждой проверке, 0 выключает правило
+func ErrorRate(name string, counts func() (total, failed uint64), threshold func() float64, minEvents uint64) Rule {
+	return &errorRateRule{name: name, counts: counts, threshold: threshold, minEvents: minEvents}
+}
+
+func (r *errorRateRule) Name() string { return r.name }
+
+func (r *errorRateRule) Evaluate(time.Time) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total, failed := r.counts()
+	dTotal, dFailed := total-r.prevTotal, failed-r.prevFailed
+	r.prevTotal, r.prevFailed = total, failed
+
+	if dTotal == 0 || dTotal < r.minEvents {
+		return false, fmt.Sprintf("обработано %d, ошибок %d", dTotal, dFailed)
+	}
+	rate, threshold := float64(dFailed)/float64(dTotal), r.threshold()
+	return threshold > 0 && rate > threshold, fmt.Sprintf("ошибок %d из %d (%.1f%%), порог %.1f%%", dFailed, dTotal, rate*100, threshold*100)
+}
@@ -0,0 +1,53 @@
+package alert
+
+import (
+	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// Notifiers создает каналы, для которых заполнены настройки
+func Notifiers(o *options.Options) []Notifier {
+	var notifiers []Notifier
+	if o.AlertTelegramToken != "" && o.AlertTelegramChatID != "" {
+		notifiers = append(notifiers, NewTelegram(o.AlertTelegramURL, o.AlertTelegramToken, o.AlertTelegramChatID))
+	}
+	if o.AlertWebhookURL != "" {
+		notifiers = append(notifiers, NewWebhook(o.AlertWebhookURL))
+	}
+	if o.AlertSMTPAddress != "" && len(o.AlertEmailTo) > 0 {
+		notifiers = append(notifiers, NewEmail(o.AlertSMTPAddress, o.AlertSMTPUser, o.AlertSMTPPassword, o.AlertEmailFrom, o.AlertEmailTo))
+	}
+	return notifiers
+}
+
+// Setup включает оповещения сервиса service: подключает хук к логгеру и запускает отправку
+// и проверку правил. Хук и правила работают и без каналов, тогда оповещения просто не отправляются,
+// а каналы можно добавить перезагрузкой настроек, см. Reconfigure
+func Setup(l *logger.Logger, service string, o *options.Options) *Alerter {
+	a := New(l, service, nil, o.AlertDedupWindow, o.AlertRatePerMinute)
+	a.Reconfigure(o)
+	l.Log.AddHook(Hook(a))
+	go a.Run()
+	if o.AlertCheckInterval > 0 {
+		go a.Watch(o.AlertCheckInterval)
+	}
+	return a
+}
+
+// Reconfigure пересоздает каналы и меняет лимиты по новым настройкам. Очередь, правила
+// и окно дедупликации сохраняются
+func (a *Alerter) Reconfigure(o *options.Options) {
+	notifiers := Notifiers(o)
+	a.SetNotifiers(notifiers)
+	a.SetLimits(o.AlertDedupWindow, o.AlertRatePerMinute)
+
+	if len(notifiers) == 0 {
+		a.logger.Info("Оповещения выключены: ни один канал не настроен")
+		return
+	}
+	names := make([]string, 0, len(notifiers))
+	for _, n := range notifiers {
+		names = append(names, n.Name())
+	}
+	a.logger.Info("Оповещения включены:", names)
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Reader данные, сохраненные консьюмером. Реализуется consdb.PostgresDBClient
+type Reader interface {
+	ListSports(ctx context.Context, page consdb.Page) ([]*parsed.Sport, error)
+	ListLeagues(ctx context.Context, sportID int, page consdb.Page) ([]*parsed.League, error)
+	ListMatches(ctx context.Context, filter consdb.MatchFilter, page consdb.Page) ([]*parsed.Match, error)
+	// GetMatch возвращает nil без ошибки, если матча нет
+	GetMatch(ctx context.Context, matchID int) (*parsed.Match, error)
+	ListMarkets(ctx context.Context, matchID int, page consdb.Page) ([]*parsed.Straight, error)
+	PriceHistory(ctx context.Context, matchID int, key string, page consdb.Page) ([]*consdb.PricePoint, error)
+}
+
+// Server отдает данные, сохраненные консьюмером, по REST
+type Server struct {
+	logger *logger.Logger
+	db     Reader
+}
+
+// listResponse постраничный ответ
+type listResponse struct {
+	Data   any `json:"data"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewServer(l *logger.Logger, db Reader) *Server {
+	return &Server{logger: l.Named("api"), db: db}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sports", s.listSports)
+	mux.HandleFunc("GET /leagues", s.listLeagues)
+	mux.HandleFunc("GET /matches", s.listMatches)
+	mux.HandleFunc("GET /matches/{id}", s.getMatch)
+	mux.HandleFunc("GET /matches/{id}/markets", s.listMarkets)
+	mux.HandleFunc("GET /matches/{id}/markets/{key}/prices", s.priceHistory)
+
+	return mux
+}
+
+func (s *Server) listSports(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sports, err := s.db.ListSports(r.Context(), page)
+	s.writeList(w, sports, page, err)
+}
+
+func (s *Server) listLeagues(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sportID, err := queryInt(r, "sport_id")
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	leagues, err := s.db.ListLeagues(r.Context(), sportID, page)
+	s.writeList(w, leagues, page, err)
+}
+
+// listMatches поддерживает фильтры league_id, live, from, to (RFC3339) и status
+func (s *Server) listMatches(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var filter consdb.MatchFilter
+	q := r.URL.Query()
+	if filter.LeagueID, err = queryInt(r, "league_id"); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if v := q.Get("live"); v != "" {
+		live, err := strconv.ParseBool(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, errors.New("live must be true or false"))
+			return
+		}
+		filter.IsLive = &live
+	}
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Status = q.Get("status")
+
+	matches, err := s.db.ListMatches(r.Context(), filter, page)
+	s.writeList(w, matches, page, err)
+}
+
+func (s *Server) getMatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("match id must be a number"))
+		return
+	}
+
+	match, err := s.db.GetMatch(r.Context(), id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if match == nil {
+		s.writeError(w, http.StatusNotFound, errors.New("match not found"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, match)
+}
+
+func (s *Server) listMarkets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("match id must be a number"))
+		return
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	markets, err := s.db.ListMarkets(r.Context(), id, page)
+	s.writeList(w, markets, page, err)
+}
+
+func (s *Server) priceHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("match id must be a number"))
+		return
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	prices, err := s.db.PriceHistory(r.Context(), id, r.PathValue("key"), page)
+	s.writeList(w, prices, page, err)
+}
+
+func (s *Server) writeList(w http.ResponseWriter, data any, page consdb.Page, err error) {
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, listResponse{Data: data, Limit: page.Limit, Offset: page.Offset})
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("API request failed:", err)
+		err = errors.New(http.StatusText(status))
+	}
+	s.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := sonic.Marshal(v)
+	if err != nil {
+		s.logger.Error("Failed to marshal API response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// parsePage читает limit и offset, limit ограничен maxLimit
+func parsePage(r *http.Request) (consdb.Page, error) {
+	page := consdb.Page{Limit: defaultLimit}
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if page.Limit, err = strconv.Atoi(v); err != nil || page.Limit < 1 {
+			return page, errors.New("limit must be a positive number")
+		}
+		page.Limit = min(page.Limit, maxLimit)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if page.Offset, err = strconv.Atoi(v); err != nil || page.Offset < 0 {
+			return page, errors.New("offset must be a non-negative number")
+		}
+	}
+
+	return page, nil
+}
+
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New(name + " must be a number")
+	}
+	return n, nil
+}
+
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC3339 timestamp")
+	}
+	return t, nil
+}
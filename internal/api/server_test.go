@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// fakeReader запоминает параметры последнего запроса и отдает заранее заданные данные
+type fakeReader struct {
+	err     error
+	matches map[int]*parsed.Match
+
+	page    consdb.Page
+	filter  consdb.MatchFilter
+	sportID int
+	key     string
+}
+
+func (f *fakeReader) ListSports(_ context.Context, page consdb.Page) ([]*parsed.Sport, error) {
+	f.page = page
+	return []*parsed.Sport{{ID: 29, Name: "Soccer"}}, f.err
+}
+
+func (f *fakeReader) ListLeagues(_ context.Context, sportID int, page consdb.Page) ([]*parsed.League, error) {
+	f.sportID, f.page = sportID, page
+	return []*parsed.League{}, f.err
+}
+
+func (f *fakeReader) ListMatches(_ context.Context, filter consdb.MatchFilter, page consdb.Page) ([]*parsed.Match, error) {
+	f.filter, f.page = filter, page
+	return []*parsed.Match{}, f.err
+}
+
+func (f *fakeReader) GetMatch(_ context.Context, matchID int) (*parsed.Match, error) {
+	return f.matches[matchID], f.err
+}
+
+func (f *fakeReader) ListMarkets(_ context.Context, matchID int, page consdb.Page) ([]*parsed.Straight, error) {
+	f.page = page
+	return []*parsed.Straight{{MatchupID: matchID, Key: "s;0;m"}}, f.err
+}
+
+func (f *fakeReader) PriceHistory(_ context.Context, matchID int, key string, page consdb.Page) ([]*consdb.PricePoint, error) {
+	f.key, f.page = key, page
+	return []*consdb.PricePoint{{Price: -110}}, f.err
+}
+
+func newTestServer(t *testing.T) (*fakeReader, http.Handler) {
+	t.Helper()
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	db := &fakeReader{matches: map[int]*parsed.Match{7: {ID: 7}}}
+	return db, NewServer(l, db).Handler()
+}
+
+// get выполняет запрос и раскладывает тело ответа в map
+func get(t *testing.T, h http.Handler, target string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var body map[string]any
+	if err := sonic.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: %v: %s", target, err, rec.Body)
+	}
+	return rec.Code, body
+}
+
+func TestPagination(t *testing.T) {
+	db, h := newTestServer(t)
+	for _, c := range []struct {
+		query string
+		want  consdb.Page
+	}{
+		{"", consdb.Page{Limit: defaultLimit}},
+		{"?limit=10&offset=20", consdb.Page{Limit: 10, Offset: 20}},
+		{"?limit=100000", consdb.Page{Limit: maxLimit}},
+	} {
+		code, body := get(t, h, "/sports"+c.query)
+		if code != http.StatusOK || db.page != c.want {
+			t.Fatalf("%q: status %d, page %+v, want %+v", c.query, code, db.page, c.want)
+		}
+		if body["limit"] != float64(c.want.Limit) || body["offset"] != float64(c.want.Offset) {
+			t.Fatalf("%q: body = %v", c.query, body)
+		}
+	}
+}
+
+func TestBadParams(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, target := range []string{
+		"/sports?limit=0",
+		"/sports?limit=x",
+		"/sports?offset=-1",
+		"/leagues?sport_id=x",
+		"/matches?league_id=x",
+		"/matches?live=maybe",
+		"/matches?from=yesterday",
+		"/matches?to=2026-10-18",
+		"/matches/x",
+		"/matches/x/markets",
+		"/matches/7/markets/s;0;m/prices?limit=-5",
+	} {
+		code, body := get(t, h, target)
+		if code != http.StatusBadRequest || body["error"] == "" {
+			t.Errorf("%s: status %d, body %v, want 400 with an error", target, code, body)
+		}
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	db, h := newTestServer(t)
+	code, _ := get(t, h, "/matches?league_id=3&live=false&from=2026-10-18T10:00:00Z&to=2026-10-18T12:00:00%2B02:00&status=suspended")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+
+	f := db.filter
+	if f.LeagueID != 3 || f.IsLive == nil || *f.IsLive || f.Status != "suspended" {
+		t.Fatalf("filter = %+v", f)
+	}
+	if !f.From.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)) || !f.To.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("from %v, to %v", f.From, f.To)
+	}
+
+	get(t, h, "/matches")
+	if db.filter.IsLive != nil || !db.filter.From.IsZero() {
+		t.Fatalf("empty query filter = %+v", db.filter)
+	}
+}
+
+func TestGetMatch(t *testing.T) {
+	db, h := newTestServer(t)
+	if code, body := get(t, h, "/matches/7"); code != http.StatusOK || body["id"] != float64(7) {
+		t.Fatalf("status %d, body %v", code, body)
+	}
+	if code, body := get(t, h, "/matches/8"); code != http.StatusNotFound || body["error"] != "match not found" {
+		t.Fatalf("unknown match: status %d, body %v", code, body)
+	}
+
+	code, body := get(t, h, "/matches/7/markets/s;0;m/prices")
+	if code != http.StatusOK || db.key != "s;0;m" {
+		t.Fatalf("prices: status %d, key %q, body %v", code, db.key, body)
+	}
+}
+
+// TestReaderError ошибка базы отдается как 500 без подробностей
+func TestReaderError(t *testing.T) {
+	db, h := newTestServer(t)
+	db.err = errors.New("connection refused to 10.0.0.5")
+	for _, target := range []string{"/sports", "/matches/7", "/matches/7/markets"} {
+		code, body := get(t, h, target)
+		if code != http.StatusInternalServerError || body["error"] != http.StatusText(http.StatusInternalServerError) {
+			t.Errorf("%s: status %d, body %v", target, code, body)
+		}
+	}
+}
@@ -1,24 +1,93 @@
 package consumer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/bytedance/sonic"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pararti/pinnacle-parser/internal/health"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
 	"github.com/pararti/pinnacle-parser/internal/options"
 	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/constants"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// retryBackoff пауза перед повторной обработкой сообщения после первой ошибки, дальше она
+	// удваивается до maxRetryBackoff
+	retryBackoff    = time.Second
+	maxRetryBackoff = 30 * time.Second
+	seekTimeoutMs   = 5000
+	// attemptTimeout сколько ждать запись счетчика попыток в базу
+	attemptTimeout = 5 * time.Second
+	// pollStallTimeout сколько цикл чтения может не возвращаться к kafka, прежде чем /healthz
+	// сочтет консьюмер зависшим
+	pollStallTimeout = 2 * time.Minute
+	// pruneInterval период очистки отметок об обработанных сообщениях
+	pruneInterval = time.Hour
+)
+
+// ErrStoreUnavailable база не ответила на служебный запрос, например проверку IsProcessed.
+// Такая ошибка не считается попыткой обработки сообщения
+var ErrStoreUnavailable = errors.New("store is unavailable")
+
+// Store база, в которую консьюмер пишет события. Реализуется consdb.PostgresDBClient
+type Store interface {
+	// WithinTx выполняет fn в одной транзакции, записи с ctx из fn откатываются вместе
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+	IsProcessed(ctx context.Context, key consdb.MessageKey) (bool, error)
+	MarkProcessed(ctx context.Context, key consdb.MessageKey) error
+	PruneProcessed(ctx context.Context, before time.Time) (int64, error)
+	RecordAttempt(ctx context.Context, key consdb.MessageKey, cause string) (int, error)
+	ClearAttempts(ctx context.Context, key consdb.MessageKey) error
+
+	StoreMatch(ctx context.Context, patch *parsed.Match) error
+	DeleteMatch(ctx context.Context, id int) error
+	ReviveMatches(ctx context.Context, ids []int32) error
+	StoreStraights(ctx context.Context, straights []*parsed.Straight, patch bool, messageKey string, sentAt time.Time) error
+
+	BeginSnapshotChunk(ctx context.Context, c consdb.SnapshotChunk) error
+	CompleteSnapshotChunk(ctx context.Context, c consdb.SnapshotChunk) (stale int64, completed bool, err error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
 type ConsumerKafka struct {
 	logger     *logger.Logger
 	consumer   *kafka.Consumer
-	postgresDB *consdb.PostgresDBClient
+	postgresDB Store
+	dispatcher *Dispatcher
+	dlq        *DeadLetterQueue
+	maxRetries int
+	// retention сколько хранить отметки об обработанных сообщениях, 0 не удаляет их
+	retention time.Duration
+	// attempts попытки в этом процессе, по ним растет пауза перед повтором. Лимит попыток
+	// проверяется по message_attempts
+	attempts map[consdb.MessageKey]int
+	// paused партиции, ожидающие повтора сообщения, и время, когда их нужно возобновить
+	paused map[partition]pausedPartition
+
+	// lastPoll и lastMessage время в UnixNano для проверок здоровья
+	lastPoll    atomic.Int64
+	lastMessage atomic.Int64
+	// handled и failed счетчики обработанных сообщений и неудачных попыток для правила оповещений
+	handled atomic.Uint64
+	failed  atomic.Uint64
 }
 
 func NewConsumerKafka(l *logger.Logger, opts *options.Options) *ConsumerKafka {
@@ -29,7 +98,7 @@ func NewConsumerKafka(l *logger.Logger, opts *options.Options) *ConsumerKafka {
 		"bootstrap.servers":  addr,
 		"group.id":           "pinnacle-consumer",
 		"auto.offset.reset":  "earliest",
-		"enable.auto.commit": true,
+		"enable.auto.commit": false,
 	}
 
 	// Создаем потребителя Kafka
@@ -46,24 +115,54 @@ func NewConsumerKafka(l *logger.Logger, opts *options.Options) *ConsumerKafka {
 		os.Exit(1)
 	}
 
+	if opts.AutoMigrate {
+		migrator, err := consdb.NewMigrator(postgresDB)
+		if err != nil {
+			l.Fatal("Failed to load migrations", err)
+		}
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			l.Fatal("Failed to migrate database", err)
+		}
+		l.Info("Database schema is up to date, applied migrations:", applied)
+	}
+
+	dlq, err := NewDeadLetterQueue(opts)
+	if err != nil {
+		l.Fatal("Failed to create dead-letter producer", err)
+	}
+
 	l.Info("Successfully connected to Kafka brokers", opts.KafkaTopic)
 
-	return &ConsumerKafka{
-		logger:     l,
+	ck := &ConsumerKafka{
+		logger:     l.Named("consumer"),
 		consumer:   consumer,
 		postgresDB: postgresDB,
+		dispatcher: NewDispatcher(),
+		dlq:        dlq,
+		maxRetries: opts.ConsumerRetries,
+		retention:  opts.ProcessedRetention,
+		attempts:   make(map[consdb.MessageKey]int),
+		paused:     make(map[partition]pausedPartition),
 	}
+	ck.registerHandlers()
+
+	return ck
 }
 
 func (ck *ConsumerKafka) Start(topic string) {
 	// Подписываемся на топик
 	err := ck.consumer.SubscribeTopics([]string{topic}, nil)
 	if err != nil {
-		ck.logger.Fatal("Failed to subscribe to topic %s", topic, err)
+		ck.logger.Fatalf("Failed to subscribe to topic %s: %v", topic, err)
 	}
 
 	ck.logger.Info("Subscribed to Kafka topic", topic)
 
+	if ck.retention > 0 {
+		go ck.pruneProcessed()
+	}
+
 	// Канал для сигналов остановки
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
@@ -78,8 +177,11 @@ func (ck *ConsumerKafka) Start(topic string) {
 			ck.logger.Info("Caught signal terminating", sig)
 			run = false
 		default:
+			ck.resumeDue(time.Now())
+
 			// Читаем сообщение с таймаутом
 			msg, err := ck.consumer.ReadMessage(100 * time.Millisecond)
+			ck.lastPoll.Store(time.Now().UnixNano())
 			if err != nil {
 				// Тайм-аут не является ошибкой
 				if e, ok := err.(kafka.Error); ok && e.Code() == kafka.ErrTimedOut {
@@ -92,106 +194,350 @@ func (ck *ConsumerKafka) Start(topic string) {
 			// Обрабатываем сообщение
 			ck.logger.Info("Received message",
 				*msg.TopicPartition.Topic, msg.TopicPartition.Partition, msg.TopicPartition.Offset)
+			ck.observeLag(msg)
+			ck.lastMessage.Store(time.Now().UnixNano())
 
-			ck.processMessage(msg.Value)
+			ck.handled.Add(1)
+			if err := ck.handleMessage(msg); err != nil {
+				ck.failed.Add(1)
+				if moved, attempts := ck.deadLetter(msg, err); !moved {
+					// Оффсет не коммитим: перечитываем это же сообщение после паузы
+					ck.rewind(msg, attempts)
+					continue
+				}
+			}
+
+			if _, err := ck.consumer.CommitMessage(msg); err != nil {
+				ck.logger.Error("Failed to commit offset", err)
+			}
 		}
 	}
 }
 
-func (ck *ConsumerKafka) processMessage(data []byte) {
-	// Сначала проверяем, если это сообщение о новых матчах
-	var matchData kafkadata.Match
-	if err := sonic.Unmarshal(data, &matchData); err == nil && matchData.EventType == constants.MATCH_NEW {
-		ck.logger.Info("Processing new matches", len(matchData.Data))
-		successCount := 0
-		errorCount := 0
-		for _, match := range matchData.Data {
-			if err := ck.postgresDB.StoreMatch(match); err != nil {
-				ck.logger.Error("Failed to store match", match.ID, err)
-				errorCount++
-			} else {
-				successCount++
-			}
+// handleMessage обрабатывает сообщение идемпотентно: уже обработанные пропускаются, а данные
+// сообщения и отметка об обработке пишутся одной транзакцией, поэтому падение между ними
+// не оставит записанное сообщение без отметки.
+// Трасса продолжается из заголовка traceparent, который поставил парсер
+func (ck *ConsumerKafka) handleMessage(msg *kafka.Message) (err error) {
+	key := messageKeyOf(msg)
+
+	ctx := tracing.Extract(context.Background(), msg.Headers)
+	ctx, span := tracing.Tracer().Start(ctx, "consumer.process "+key.Topic, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", key.Topic),
+			attribute.Int("messaging.destination.partition.id", int(key.Partition)),
+			attribute.Int64("messaging.kafka.offset", key.Offset),
+		))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	processed, err := ck.postgresDB.IsProcessed(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	if processed {
+		span.SetAttributes(attribute.Bool("pinnacle.duplicate", true))
+		ck.logger.Info("Skipping already processed message", key.String())
+		return nil
+	}
+
+	err = ck.postgresDB.WithinTx(ctx, func(ctx context.Context) error {
+		if err := ck.processMessage(withProducedAt(withMessageKey(ctx, key), msg), msg); err != nil {
+			return err
 		}
-		ck.logger.Info("Processed new matches: success=", successCount, " errors=", errorCount)
-		return
+		return ck.postgresDB.MarkProcessed(ctx, key)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Проверяем, если это сообщение об обновлении матчей
-	var matchUpdData kafkadata.MatchUpd
-	if err := sonic.Unmarshal(data, &matchUpdData); err == nil && matchUpdData.EventType == constants.MATCH_UPDATE {
-		ck.logger.Info("Processing match updates", len(matchUpdData.Data))
-		successCount := 0
-		errorCount := 0
-		for _, patch := range matchUpdData.Data {
-			// StoreMatch now handles RFC7396 patching internally
-			if err := ck.postgresDB.StoreMatch(patch); err != nil {
-				ck.logger.Error("Failed to apply match patch", patch.ID, err)
-				errorCount++
-			} else {
-				successCount++
-			}
+	delete(ck.attempts, key)
+	return nil
+}
+
+// pruneProcessed раз в pruneInterval удаляет отметки об обработке старше retention
+func (ck *ConsumerKafka) pruneProcessed() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		pruned, err := ck.postgresDB.PruneProcessed(ctx, now.Add(-ck.retention))
+		cancel()
+		if err != nil {
+			ck.logger.Warn("Failed to prune processed messages", err)
+			continue
+		}
+		if pruned > 0 {
+			ck.logger.Info("Pruned processed messages:", pruned)
 		}
-		ck.logger.Info("Processed match updates: success=", successCount, " errors=", errorCount)
-		return
 	}
+}
 
-	// Проверяем, если это сообщение об удалении матчей
-	var matchDelData kafkadata.DeletedMatch
-	if err := sonic.Unmarshal(data, &matchDelData); err == nil && matchDelData.EventType == constants.MATCH_DELETE {
-		ck.logger.Info("Processing match deletions", len(matchDelData.Data))
-		successCount := 0
-		errorCount := 0
-		for _, matchID := range matchDelData.Data {
-			if err := ck.postgresDB.DeleteMatch(matchID); err != nil {
-				ck.logger.Error("Failed to delete match", matchID, err)
-				errorCount++
-			} else {
-				successCount++
-			}
+// deadLetter решает судьбу сообщения после ошибки. Возвращает true, если сообщение ушло в DLQ
+// и его оффсет можно коммитить, и false, если сообщение нужно обработать еще раз, вместе с числом
+// сделанных попыток. Сообщения неизвестного формата отправляются в DLQ сразу, остальные после
+// maxRetries попыток. Недоступность базы попыткой не считается: сообщение не виновато, и без
+// message_attempts лимит все равно не проверить, поэтому оно повторяется, пока база не вернется
+func (ck *ConsumerKafka) deadLetter(msg *kafka.Message, cause error) (bool, int) {
+	key := messageKeyOf(msg)
+	ck.attempts[key]++
+	local := ck.attempts[key]
+
+	if unavailable(cause) {
+		ck.logger.Error("Store is unavailable, retrying without counting the attempt", key.String(), cause)
+		return false, local
+	}
+	attempts, err := ck.recordAttempt(key, cause)
+	if err != nil {
+		if !errors.Is(cause, ErrUnknownEvent) {
+			ck.logger.Error("Failed to record message attempt, retrying without counting it", key.String(), err)
+			return false, local
 		}
-		ck.logger.Info("Processed match deletions: success=", successCount, " errors=", errorCount)
+		attempts = local
+	}
+
+	if !errors.Is(cause, ErrUnknownEvent) && attempts <= ck.maxRetries {
+		ck.logger.Error("Failed to process message, retrying", key.String(), attempts, cause)
+		return false, attempts
+	}
+
+	if err := ck.dlq.Publish(msg, cause, attempts); err != nil {
+		ck.logger.Error("Failed to publish message to dead-letter topic", key.String(), err)
+		return false, attempts
+	}
+
+	delete(ck.attempts, key)
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+	if err := ck.postgresDB.ClearAttempts(ctx, key); err != nil {
+		ck.logger.Warn("Failed to clear message attempts", key.String(), err)
+	}
+	ck.logger.Warn("Message moved to dead-letter topic", key.String(), cause)
+	return true, attempts
+}
+
+// recordAttempt считает неудачную попытку в message_attempts, чтобы лимит попыток не сбрасывался
+// при перезапуске и ребалансировке
+func (ck *ConsumerKafka) recordAttempt(key consdb.MessageKey, cause error) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+	return ck.postgresDB.RecordAttempt(ctx, key, cause.Error())
+}
+
+// unavailable ошибка соединения с базой, а не обработки сообщения
+func unavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.Is(err, ErrStoreUnavailable) || errors.As(err, &connectErr) || errors.As(err, &netErr)
+}
+
+// CheckPoll проверяет, что цикл чтения не завис на обработке сообщения
+func (ck *ConsumerKafka) CheckPoll(context.Context) (string, error) {
+	last := ck.lastPoll.Load()
+	if last == 0 {
+		return "not started", nil
+	}
+	since := time.Since(time.Unix(0, last)).Round(time.Millisecond)
+	detail := fmt.Sprintf("last poll %s ago", since)
+	if since > pollStallTimeout {
+		return detail, fmt.Errorf("poll loop is stalled for %s", since)
+	}
+	if last := ck.lastMessage.Load(); last != 0 {
+		detail += fmt.Sprintf(", last message %s ago", time.Since(time.Unix(0, last)).Round(time.Second))
+	}
+	return detail, nil
+}
+
+// Counts возвращает число попыток обработки сообщений и число неудачных из них
+func (ck *ConsumerKafka) Counts() (total, failed uint64) {
+	return ck.handled.Load(), ck.failed.Load()
+}
+
+// CheckKafka проверяет доступность брокеров и сообщает число назначенных партиций
+func (ck *ConsumerKafka) CheckKafka(ctx context.Context) (string, error) {
+	detail, err := health.KafkaBrokers(ctx, ck.consumer)
+	if err != nil {
+		return detail, err
+	}
+	assigned, err := ck.consumer.Assignment()
+	if err != nil {
+		return detail, err
+	}
+	return fmt.Sprintf("%s, %d partitions assigned", detail, len(assigned)), nil
+}
+
+// CheckPostgres пингует базу
+func (ck *ConsumerKafka) CheckPostgres(ctx context.Context) (string, error) {
+	if err := ck.postgresDB.Ping(ctx); err != nil {
+		return "", err
+	}
+	return "ping ok", nil
+}
+
+// observeLag обновляет отставание партиции по high watermark из последнего fetch, без запроса к брокеру
+func (ck *ConsumerKafka) observeLag(msg *kafka.Message) {
+	tp := msg.TopicPartition
+	_, high, err := ck.consumer.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+	if err != nil || high < 0 {
 		return
 	}
+	lag := high - int64(tp.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	metrics.ConsumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+}
 
-	// Проверяем, если это сообщение о новых ставках
-	var betData kafkadata.Bet
-	if err := sonic.Unmarshal(data, &betData); err == nil && betData.EventType == constants.BET_NEW {
-		ck.logger.Info("Processing new bets", len(betData.Data))
-		successCount := 0
-		errorCount := 0
-		for _, straight := range betData.Data {
-			if err := ck.postgresDB.StoreStraight(straight); err != nil {
-				ck.logger.Error("Failed to store bet", straight.Key, err)
-				errorCount++
-			} else {
-				successCount++
-			}
+func messageKeyOf(msg *kafka.Message) consdb.MessageKey {
+	return consdb.MessageKey{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+	}
+}
+
+func (ck *ConsumerKafka) processMessage(ctx context.Context, msg *kafka.Message) error {
+	return ck.dispatcher.Dispatch(ctx, msg)
+}
+
+// partition партиция, поставленная на паузу
+type partition struct {
+	topic string
+	id    int32
+}
+
+type pausedPartition struct {
+	tp    kafka.TopicPartition
+	until time.Time
+}
+
+// rewind ставит партицию на паузу и возвращает ее позицию на необработанное сообщение.
+// Цикл чтения при этом не останавливается: остальные партиции читаются дальше, а эту
+// resumeDue возобновит после паузы, растущей с числом попыток
+func (ck *ConsumerKafka) rewind(msg *kafka.Message, attempts int) {
+	tp := msg.TopicPartition
+	if err := ck.consumer.Pause([]kafka.TopicPartition{tp}); err != nil {
+		ck.logger.Error("Failed to pause partition", tp, err)
+	}
+	if err := ck.consumer.Seek(tp, seekTimeoutMs); err != nil {
+		ck.logger.Error("Failed to seek to offset", tp, err)
+	}
+	ck.paused[partition{topic: *tp.Topic, id: tp.Partition}] = pausedPartition{
+		tp:    tp,
+		until: time.Now().Add(retryDelay(attempts)),
+	}
+}
+
+// resumeDue возобновляет партиции, пауза которых истекла. Партиция, отобранная ребалансировкой,
+// возобновляется с ошибкой, которая не важна: новый владелец читает ее без паузы
+func (ck *ConsumerKafka) resumeDue(now time.Time) {
+	for key, p := range ck.paused {
+		if now.Before(p.until) {
+			continue
+		}
+		delete(ck.paused, key)
+		if err := ck.consumer.Resume([]kafka.TopicPartition{p.tp}); err != nil {
+			ck.logger.Warn("Failed to resume partition", p.tp, err)
 		}
-		ck.logger.Info("Processed new bets: success=", successCount, " errors=", errorCount)
-		return
 	}
+}
+
+// retryDelay пауза перед попыткой attempts+1: retryBackoff, затем вдвое больше, до maxRetryBackoff
+func retryDelay(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
 
-	// Проверяем, если это сообщение об обновлении ставок
-	var betUpdData kafkadata.BetUpd
-	if err := sonic.Unmarshal(data, &betUpdData); err == nil && betUpdData.EventType == constants.BET_UPDATE {
-		ck.logger.Info("Processing %d bet updates", len(betUpdData.Data))
-		successCount := 0
-		errorCount := 0
-		for _, straight := range betUpdData.Data {
-			if err := ck.postgresDB.StoreStraight(straight); err != nil {
-				ck.logger.Error("Failed to update bet", straight.Key, err)
-				errorCount++
-			} else {
-				successCount++
+// registerHandlers связывает типы событий с обработчиками, новые типы добавляются здесь
+func (ck *ConsumerKafka) registerHandlers() {
+	ck.dispatcher.Register(constants.MATCH_NEW, Typed(ck.handleNewMatches))
+	ck.dispatcher.Register(constants.MATCH_UPDATE, Typed(ck.handleMatchUpdates))
+	ck.dispatcher.Register(constants.MATCH_DELETE, Typed(ck.handleMatchDeletions))
+	ck.dispatcher.Register(constants.MATCH_RESTORED, Typed(ck.handleRestoredMatches))
+	ck.dispatcher.Register(constants.BET_NEW, Typed(ck.handleNewBets))
+	ck.dispatcher.Register(constants.BET_UPDATE, Typed(ck.handleBetUpdates))
+	ck.dispatcher.Register(constants.MATCH_SNAPSHOT, Snapshots(ck.handleMatchSnapshot))
+	ck.dispatcher.Register(constants.BET_SNAPSHOT, Snapshots(ck.handleBetSnapshot))
+}
+
+func (ck *ConsumerKafka) handleNewMatches(ctx context.Context, matches []*parsed.Match) error {
+	ck.logger.Info("Processing new matches", len(matches))
+	return storeEach(ck, "new matches", matches, func(match *parsed.Match) (any, error) {
+		return match.ID, ck.postgresDB.StoreMatch(ctx, match)
+	})
+}
+
+func (ck *ConsumerKafka) handleMatchUpdates(ctx context.Context, patches []*parsed.Match) error {
+	ck.logger.Info("Processing match updates", len(patches))
+	return storeEach(ck, "match updates", patches, func(patch *parsed.Match) (any, error) {
+		// StoreMatch now handles RFC7396 patching internally
+		return patch.ID, ck.postgresDB.StoreMatch(ctx, patch)
+	})
+}
+
+func (ck *ConsumerKafka) handleMatchDeletions(ctx context.Context, matchIDs []int) error {
+	ck.logger.Info("Processing match deletions", len(matchIDs))
+	return storeEach(ck, "match deletions", matchIDs, func(matchID int) (any, error) {
+		return matchID, ck.postgresDB.DeleteMatch(ctx, matchID)
+	})
+}
+
+// handleRestoredMatches сохраняет вернувшиеся матчи целиком вместе со статусом
+func (ck *ConsumerKafka) handleRestoredMatches(ctx context.Context, matches []*parsed.Match) error {
+	ck.logger.Info("Processing restored matches", len(matches))
+	return storeEach(ck, "restored matches", matches, func(match *parsed.Match) (any, error) {
+		return match.ID, ck.postgresDB.StoreMatch(ctx, match)
+	})
+}
+
+func (ck *ConsumerKafka) handleNewBets(ctx context.Context, straights []*parsed.Straight) error {
+	return ck.storeStraights(ctx, "new bets", straights, false)
+}
+
+func (ck *ConsumerKafka) handleBetUpdates(ctx context.Context, straights []*parsed.Straight) error {
+	return ck.storeStraights(ctx, "bet updates", straights, true)
+}
+
+// storeStraights пишет все ставки сообщения одной пачкой, patch для патчей BET_UPDATE
+func (ck *ConsumerKafka) storeStraights(ctx context.Context, label string, straights []*parsed.Straight, patch bool) error {
+	start := time.Now()
+	if err := ck.postgresDB.StoreStraights(ctx, straights, patch, messageKeyFrom(ctx), producedAtFrom(ctx)); err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	ck.logger.Info("Processed "+label+":", len(straights), "in", time.Since(start))
+	return nil
+}
+
+// storeEach сохраняет элементы по одному и возвращает первую ошибку, если хотя бы один не сохранился
+func storeEach[T any](ck *ConsumerKafka, label string, items []T, store func(T) (any, error)) error {
+	successCount := 0
+	errorCount := 0
+	var firstErr error
+	for _, item := range items {
+		id, err := store(item)
+		if err != nil {
+			ck.logger.Error("Failed to process "+label, id, err)
+			errorCount++
+			if firstErr == nil {
+				firstErr = err
 			}
+		} else {
+			successCount++
 		}
-		ck.logger.Info("Processed bet updates: success=", successCount, " errors=", errorCount)
-		return
 	}
+	ck.logger.Info("Processed "+label+": success=", successCount, " errors=", errorCount)
 
-	// Если не удалось определить тип сообщения
-	ck.logger.Warn("Received message with unknown format")
+	if firstErr != nil {
+		return fmt.Errorf("%s: %d of %d failed: %w", label, errorCount, len(items), firstErr)
+	}
+	return nil
 }
 
 func (ck *ConsumerKafka) Stop() {
@@ -202,6 +548,10 @@ func (ck *ConsumerKafka) Stop() {
 		}
 	}
 
+	if ck.dlq != nil {
+		ck.dlq.Close()
+	}
+
 	// Закрываем соединение с Kafka
 	if ck.consumer != nil {
 		if err := ck.consumer.Close(); err != nil {
@@ -0,0 +1,181 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+)
+
+func TestHandleMessageSkipsRedelivered(t *testing.T) {
+	store := newFakeStore()
+	ck := newTestConsumer(t, store)
+	msg := betUpdateMessage(t, 10, 42)
+
+	var event kafkadata.Event[*parsed.Straight]
+	if err := sonic.Unmarshal(msg.Value, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := ck.handleMessage(msg); err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+	}
+
+	if store.straights != len(event.Data) {
+		t.Fatalf("stored %d straights after redelivery, want %d", store.straights, len(event.Data))
+	}
+	if !store.processed[messageKeyOf(msg)] {
+		t.Fatal("message is not marked as processed")
+	}
+}
+
+// failingMarkStore не может поставить отметку об обработке, например из-за обрыва соединения
+type failingMarkStore struct {
+	*fakeStore
+	fail bool
+}
+
+func (f *failingMarkStore) MarkProcessed(ctx context.Context, key consdb.MessageKey) error {
+	if f.fail {
+		f.fail = false
+		return errors.New("connection reset")
+	}
+	return f.fakeStore.MarkProcessed(ctx, key)
+}
+
+// TestHandleMessageRollsBackUnmarked ошибка MarkProcessed откатывает и записанные данные сообщения,
+// повторная обработка пишет их один раз
+func TestHandleMessageRollsBackUnmarked(t *testing.T) {
+	store := &failingMarkStore{fakeStore: newFakeStore(), fail: true}
+	ck := newTestConsumer(t, store)
+	msg := betUpdateMessage(t, 5, 7)
+
+	if err := ck.handleMessage(msg); err == nil {
+		t.Fatal("expected error from MarkProcessed")
+	}
+	if len(store.prices) != 0 || store.straights != 0 {
+		t.Fatalf("writes are kept after the failed mark: %d prices, %d straights", len(store.prices), store.straights)
+	}
+	if err := ck.handleMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := ck.handleMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	key := messageKeyOf(msg).String()
+	for price, n := range store.prices {
+		if n != 1 || !strings.HasSuffix(price, "/"+key) {
+			t.Fatalf("price %s written %d times, want once with message key %s", price, n, key)
+		}
+	}
+	if !store.processed[messageKeyOf(msg)] {
+		t.Fatal("message is not marked as processed")
+	}
+}
+
+// TestAttemptsSurviveRestart лимит попыток считается по базе, поэтому новый процесс или новый
+// владелец партиции после ребалансировки продолжает счет, а не начинает его заново
+func TestAttemptsSurviveRestart(t *testing.T) {
+	store := newFakeStore()
+	key := consdb.MessageKey{Topic: "bookmaker_events", Partition: 1, Offset: 10}
+	cause := errors.New("deadlock detected")
+
+	first := newTestConsumer(t, store)
+	for want := 1; want <= 2; want++ {
+		if got, err := first.recordAttempt(key, cause); err != nil || got != want {
+			t.Fatalf("attempt = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	restarted := newTestConsumer(t, store)
+	if got, _ := restarted.recordAttempt(key, cause); got != 3 {
+		t.Fatalf("attempt after restart = %d, want 3", got)
+	}
+
+	if err := store.MarkProcessed(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restarted.recordAttempt(consdb.MessageKey{Topic: key.Topic, Partition: 1, Offset: 11}, cause); got != 1 {
+		t.Fatalf("attempt of the next message = %d, want 1", got)
+	}
+}
+
+// downStore база, которая не отвечает на служебные запросы
+type downStore struct {
+	*fakeStore
+	processedErr error
+	attemptErr   error
+}
+
+func (d *downStore) IsProcessed(ctx context.Context, key consdb.MessageKey) (bool, error) {
+	if d.processedErr != nil {
+		return false, d.processedErr
+	}
+	return d.fakeStore.IsProcessed(ctx, key)
+}
+
+func (d *downStore) RecordAttempt(ctx context.Context, key consdb.MessageKey, cause string) (int, error) {
+	if d.attemptErr != nil {
+		return 0, d.attemptErr
+	}
+	return d.fakeStore.RecordAttempt(ctx, key, cause)
+}
+
+// TestStoreOutageDoesNotCountAttempts пока база недоступна, сообщение повторяется сколько угодно
+// раз и не уходит в DLQ (у тестового консьюмера его нет, Publish упал бы)
+func TestStoreOutageDoesNotCountAttempts(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for name, store := range map[string]*downStore{
+		"IsProcessed":   {fakeStore: newFakeStore(), processedErr: refused},
+		"RecordAttempt": {fakeStore: newFakeStore(), attemptErr: refused},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ck := newTestConsumer(t, store)
+			msg := betUpdateMessage(t, 1, 3)
+			cause := errors.New("deadlock detected")
+			if store.processedErr != nil {
+				cause = ck.handleMessage(msg)
+				if !errors.Is(cause, ErrStoreUnavailable) {
+					t.Fatalf("handleMessage = %v, want ErrStoreUnavailable", cause)
+				}
+			}
+
+			for i := 1; i <= ck.maxRetries+3; i++ {
+				moved, attempts := ck.deadLetter(msg, cause)
+				if moved {
+					t.Fatalf("moved to DLQ on attempt %d", i)
+				}
+				if attempts != i {
+					t.Fatalf("backoff attempts = %d, want %d", attempts, i)
+				}
+			}
+			if n := store.attempts[messageKeyOf(msg)]; n != 0 {
+				t.Fatalf("stored attempts = %d, want none during the outage", n)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		6:  30 * time.Second,
+		50: 30 * time.Second,
+	} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
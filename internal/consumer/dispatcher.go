@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrUnknownEvent возвращается, если для типа события не зарегистрирован обработчик
+var ErrUnknownEvent = errors.New("unknown event type")
+
+// Handler обрабатывает тело сообщения одного типа события
+type Handler interface {
+	Handle(ctx context.Context, payload []byte) error
+}
+
+// HandlerFunc позволяет использовать функцию как Handler
+type HandlerFunc func(ctx context.Context, payload []byte) error
+
+func (f HandlerFunc) Handle(ctx context.Context, payload []byte) error {
+	return f(ctx, payload)
+}
+
+// Typed создает обработчик, который декодирует сообщение ровно один раз в kafkadata.Event[T]
+func Typed[T any](fn func(ctx context.Context, data []T) error) Handler {
+	return HandlerFunc(func(ctx context.Context, payload []byte) error {
+		var event kafkadata.Event[T]
+		if err := sonic.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		return fn(ctx, event.Data)
+	})
+}
+
+// Snapshots адаптирует функцию, принимающую часть снимка состояния, к Handler
+func Snapshots[T any](fn func(ctx context.Context, snapshot kafkadata.Snapshot[T]) error) Handler {
+	return HandlerFunc(func(ctx context.Context, payload []byte) error {
+		var snapshot kafkadata.Snapshot[T]
+		if err := sonic.Unmarshal(payload, &snapshot); err != nil {
+			return err
+		}
+		return fn(ctx, snapshot)
+	})
+}
+
+// Dispatcher определяет тип события и передает сообщение зарегистрированному обработчику
+type Dispatcher struct {
+	handlers map[int]Handler
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[int]Handler, 8)}
+}
+
+// Register регистрирует обработчик для типа события, повторная регистрация заменяет предыдущий
+func (d *Dispatcher) Register(eventType int, h Handler) {
+	d.handlers[eventType] = h
+}
+
+func (d *Dispatcher) Dispatch(ctx context.Context, msg *kafka.Message) error {
+	eventType, err := EventType(msg)
+	if err != nil {
+		return err
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("pinnacle.event", metrics.EventName(eventType)))
+
+	h, ok := d.handlers[eventType]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownEvent, eventType)
+	}
+
+	start := time.Now()
+	err = h.Handle(ctx, msg.Value)
+	metrics.HandlerSeconds.WithLabelValues(metrics.EventName(eventType), metrics.Result(err)).
+		Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// EventType берет тип события из заголовка, а если его нет, читает только поле eventType из тела
+func EventType(msg *kafka.Message) (int, error) {
+	for _, h := range msg.Headers {
+		if h.Key == constants.HEADER_EVENT_TYPE {
+			eventType, err := strconv.Atoi(string(h.Value))
+			if err != nil {
+				return 0, fmt.Errorf("%w: bad header %q", ErrUnknownEvent, h.Value)
+			}
+			return eventType, nil
+		}
+	}
+
+	node, err := sonic.Get(msg.Value, "eventType")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownEvent, err)
+	}
+	eventType, err := node.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownEvent, err)
+	}
+
+	return int(eventType), nil
+}
+
+type messageKeyCtx struct{}
+
+// withMessageKey кладет в контекст ключ обрабатываемого сообщения
+func withMessageKey(ctx context.Context, key consdb.MessageKey) context.Context {
+	return context.WithValue(ctx, messageKeyCtx{}, key)
+}
+
+// messageKeyFrom возвращает ключ сообщения или пустую строку, если его нет в контексте
+func messageKeyFrom(ctx context.Context) string {
+	if key, ok := ctx.Value(messageKeyCtx{}).(consdb.MessageKey); ok {
+		return key.String()
+	}
+	return ""
+}
+
+type producedAtCtx struct{}
+
+// withProducedAt кладет в контекст время отправки сообщения парсером из timestamp kafka
+func withProducedAt(ctx context.Context, msg *kafka.Message) context.Context {
+	if msg.TimestampType != kafka.TimestampCreateTime {
+		return ctx
+	}
+	return context.WithValue(ctx, producedAtCtx{}, msg.Timestamp)
+}
+
+// producedAtFrom возвращает время отправки сообщения или нулевое время, если его нет
+func producedAtFrom(ctx context.Context) time.Time {
+	t, _ := ctx.Value(producedAtCtx{}).(time.Time)
+	return t
+}
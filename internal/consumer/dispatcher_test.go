@@ -0,0 +1,300 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+	"github.com/pararti/pinnacle-parser/internal/storage/consumer/pgtest"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+// fakeStore хранит в памяти то, что консьюмер записал бы в базу
+type fakeStore struct {
+	mu        sync.Mutex
+	processed map[consdb.MessageKey]bool
+	attempts  map[consdb.MessageKey]int
+	matches   map[int]*parsed.Match
+	deleted   []int
+	// prices история цен с защитой от повторной записи по ключу сообщения, как в price_values
+	prices    map[string]int
+	straights int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		processed: make(map[consdb.MessageKey]bool),
+		attempts:  make(map[consdb.MessageKey]int),
+		matches:   make(map[int]*parsed.Match),
+		prices:    make(map[string]int),
+	}
+}
+
+// WithinTx при ошибке fn возвращает записанное к состоянию до вызова, как откат транзакции.
+// Счетчики попыток, как и в базе, пишутся мимо транзакции
+func (f *fakeStore) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	f.mu.Lock()
+	processed, matches, prices := maps.Clone(f.processed), maps.Clone(f.matches), maps.Clone(f.prices)
+	deleted, straights := slices.Clone(f.deleted), f.straights
+	f.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		f.mu.Lock()
+		f.processed, f.matches, f.prices = processed, matches, prices
+		f.deleted, f.straights = deleted, straights
+		f.mu.Unlock()
+	}
+	return err
+}
+
+func (f *fakeStore) PruneProcessed(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStore) IsProcessed(_ context.Context, key consdb.MessageKey) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.processed[key], nil
+}
+
+func (f *fakeStore) MarkProcessed(_ context.Context, key consdb.MessageKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.processed[key] = true
+	delete(f.attempts, key)
+	return nil
+}
+
+func (f *fakeStore) RecordAttempt(_ context.Context, key consdb.MessageKey, _ string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts[key]++
+	return f.attempts[key], nil
+}
+
+func (f *fakeStore) ClearAttempts(_ context.Context, key consdb.MessageKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.attempts, key)
+	return nil
+}
+
+func (f *fakeStore) StoreMatch(_ context.Context, match *parsed.Match) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.matches[match.ID] = match
+	return nil
+}
+
+func (f *fakeStore) DeleteMatch(_ context.Context, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeStore) ReviveMatches(context.Context, []int32) error { return nil }
+
+func (f *fakeStore) StoreStraights(_ context.Context, straights []*parsed.Straight, _ bool, messageKey string, _ time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.straights += len(straights)
+	for _, s := range straights {
+		for _, p := range s.Prices {
+			if p.Price != 0 {
+				f.prices[fmt.Sprintf("%s/%s/%d/%s", s.Key, p.Designation, p.ParticipantId, messageKey)]++
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) BeginSnapshotChunk(context.Context, consdb.SnapshotChunk) error { return nil }
+
+func (f *fakeStore) CompleteSnapshotChunk(context.Context, consdb.SnapshotChunk) (int64, bool, error) {
+	return 0, false, nil
+}
+
+func (f *fakeStore) Ping(context.Context) error { return nil }
+func (f *fakeStore) Close() error               { return nil }
+
+// newTestConsumer консьюмер без kafka, который пишет в store
+func newTestConsumer(tb testing.TB, store Store) *ConsumerKafka {
+	tb.Helper()
+	l := logger.NewLogger()
+	if err := l.Configure(logger.Config{Level: "error"}); err != nil {
+		tb.Fatal(err)
+	}
+	l.Log.SetOutput(io.Discard)
+
+	ck := &ConsumerKafka{
+		logger:     l.Named("consumer"),
+		postgresDB: store,
+		dispatcher: NewDispatcher(),
+		maxRetries: 3,
+		attempts:   make(map[consdb.MessageKey]int),
+		paused:     make(map[partition]pausedPartition),
+	}
+	ck.registerHandlers()
+	return ck
+}
+
+// betUpdateMessage сообщение BET_UPDATE с патчами markets рынков по два исхода, как их шлет парсер
+func betUpdateMessage(tb testing.TB, markets int, offset int64) *kafka.Message {
+	tb.Helper()
+	patches := make([]*parsed.Straight, 0, markets)
+	for i := 0; i < markets; i++ {
+		straight := parsed.GenerateExampleStraight(1000 + i/20)
+		if patch := parsed.GenerateRandomStraightDelta(straight).GetUpdate(); patch != nil {
+			patch.CapturedAt = time.Now()
+			patches = append(patches, patch)
+		}
+	}
+
+	payload, err := sonic.Marshal(kafkadata.Event[*parsed.Straight]{
+		EventType: constants.BET_UPDATE,
+		Source:    constants.SOURCE,
+		Data:      patches,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	topic := constants.TOPIC
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: kafka.Offset(offset)},
+		Value:          payload,
+		Headers:        []kafka.Header{{Key: constants.HEADER_EVENT_TYPE, Value: []byte(strconv.Itoa(constants.BET_UPDATE))}},
+		Timestamp:      time.Now(),
+		TimestampType:  kafka.TimestampCreateTime,
+	}
+}
+
+// benchmarkMarkets размер пачки в пике live: несколько сотен матчей по несколько рынков
+const benchmarkMarkets = 5000
+
+// BenchmarkDispatchBetUpdate разбор и запись пачки BET_UPDATE через Dispatcher: тип берется
+// из заголовка, тело разбирается один раз
+func BenchmarkDispatchBetUpdate(b *testing.B) {
+	store := newFakeStore()
+	ck := newTestConsumer(b, store)
+	msg := betUpdateMessage(b, benchmarkMarkets, 0)
+	ctx := context.Background()
+
+	b.SetBytes(int64(len(msg.Value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ck.dispatcher.Dispatch(ctx, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if store.straights == 0 {
+		b.Fatal("bets were not stored")
+	}
+}
+
+// BenchmarkDispatchBetUpdateWithoutHeader то же для сообщений без заголовка eventType:
+// тип читается из тела через sonic.Get без полного разбора
+func BenchmarkDispatchBetUpdateWithoutHeader(b *testing.B) {
+	ck := newTestConsumer(b, newFakeStore())
+	msg := betUpdateMessage(b, benchmarkMarkets, 0)
+	msg.Headers = nil
+	ctx := context.Background()
+
+	b.SetBytes(int64(len(msg.Value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ck.dispatcher.Dispatch(ctx, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeBetUpdateSequential прежний способ для сравнения: тело разбиралось по очереди
+// в структуры всех типов событий, пока eventType не совпадет, BET_UPDATE проверялся последним
+func BenchmarkDecodeBetUpdateSequential(b *testing.B) {
+	store := newFakeStore()
+	msg := betUpdateMessage(b, benchmarkMarkets, 0)
+	ctx := context.Background()
+
+	b.SetBytes(int64(len(msg.Value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var match kafkadata.Match
+		if err := sonic.Unmarshal(msg.Value, &match); err == nil && match.EventType == constants.MATCH_NEW {
+			b.Fatal("unexpected MATCH_NEW")
+		}
+		var matchUpd kafkadata.MatchUpd
+		if err := sonic.Unmarshal(msg.Value, &matchUpd); err == nil && matchUpd.EventType == constants.MATCH_UPDATE {
+			b.Fatal("unexpected MATCH_UPDATE")
+		}
+		var matchDel kafkadata.DeletedMatch
+		if err := sonic.Unmarshal(msg.Value, &matchDel); err == nil && matchDel.EventType == constants.MATCH_DELETE {
+			b.Fatal("unexpected MATCH_DELETE")
+		}
+		var bet kafkadata.Bet
+		if err := sonic.Unmarshal(msg.Value, &bet); err == nil && bet.EventType == constants.BET_NEW {
+			b.Fatal("unexpected BET_NEW")
+		}
+		var betUpd kafkadata.BetUpd
+		if err := sonic.Unmarshal(msg.Value, &betUpd); err != nil || betUpd.EventType != constants.BET_UPDATE {
+			b.Fatal("BET_UPDATE not decoded", err)
+		}
+		if err := store.StoreStraights(ctx, betUpd.Data, true, "", time.Time{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDispatchBetUpdatePostgres пачка BET_UPDATE до настоящей базы, см. pgtest.NewDatabase.
+// Каждая итерация — новое сообщение, чтобы история цен действительно писалась
+func BenchmarkDispatchBetUpdatePostgres(b *testing.B) {
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	db, err := consdb.NewPostgresDBClient(pgtest.NewDatabase(b), l)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := consdb.NewMigrator(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+
+	ck := newTestConsumer(b, db)
+	messages := make([]*kafka.Message, b.N)
+	for i := range messages {
+		messages[i] = betUpdateMessage(b, benchmarkMarkets, int64(i))
+	}
+
+	b.ResetTimer()
+	for _, msg := range messages {
+		if err := ck.handleMessage(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package consumer
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// Заголовки, которыми сообщение дополняется при отправке в dead-letter топик
+const (
+	dlqHeaderPrefix    = "dlq."
+	dlqHeaderError     = "dlq.error"
+	dlqHeaderTopic     = "dlq.topic"
+	dlqHeaderPartition = "dlq.partition"
+	dlqHeaderOffset    = "dlq.offset"
+	dlqHeaderAttempts  = "dlq.attempts"
+	dlqHeaderFailedAt  = "dlq.failedAt"
+)
+
+const (
+	dlqFlushTimeoutMs = 5000
+	// replayIdleTimeout сколько ждать новых сообщений, прежде чем считать очередь вычитанной
+	replayIdleTimeout = 10 * time.Second
+	replayGroupID     = "pinnacle-consumer-dlq-replay"
+)
+
+// DeadLetterQueue публикует сообщения, которые не удалось обработать, в отдельный топик
+type DeadLetterQueue struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func NewDeadLetterQueue(opts *options.Options) (*DeadLetterQueue, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": opts.KafkaAddress + ":" + opts.KafkaPort,
+		"client.id":         "pinnacle-consumer-dlq",
+		"acks":              "all",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterQueue{producer: p, topic: opts.DeadLetterTopic()}, nil
+}
+
+// Publish синхронно отправляет исходное сообщение в DLQ вместе с ошибкой и его координатами
+func (d *DeadLetterQueue) Publish(msg *kafka.Message, cause error, attempts int) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+6)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: dlqHeaderError, Value: []byte(cause.Error())},
+		kafka.Header{Key: dlqHeaderTopic, Value: []byte(*msg.TopicPartition.Topic)},
+		kafka.Header{Key: dlqHeaderPartition, Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		kafka.Header{Key: dlqHeaderOffset, Value: []byte(msg.TopicPartition.Offset.String())},
+		kafka.Header{Key: dlqHeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: dlqHeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	return d.produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &d.topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	})
+}
+
+func (d *DeadLetterQueue) produce(msg *kafka.Message) error {
+	delivery := make(chan kafka.Event, 1)
+	if err := d.producer.Produce(msg, delivery); err != nil {
+		return err
+	}
+
+	ev := <-delivery
+	m, ok := ev.(*kafka.Message)
+	if !ok {
+		return errors.New("unexpected delivery event")
+	}
+
+	return m.TopicPartition.Error
+}
+
+func (d *DeadLetterQueue) Close() {
+	d.producer.Flush(dlqFlushTimeoutMs)
+	d.producer.Close()
+}
+
+// ReplayDeadLetters перечитывает DLQ и возвращает сообщения в исходные топики без dlq-заголовков.
+// Использует отдельную группу, поэтому повторный запуск не отправит уже переигранные сообщения.
+// Сообщения в DLQ - старые дельты: переигранный BET_UPDATE или MATCH_UPDATE перезапишет более
+// свежие данные, пришедшие после него. Поэтому без force очередь только читается (без коммита)
+// и выводится сводка по типам событий и возрасту, а отправка выполняется только с force
+func ReplayDeadLetters(l *logger.Logger, opts *options.Options, force bool) error {
+	var dlq *DeadLetterQueue
+	topic := opts.DeadLetterTopic()
+	if force {
+		var err error
+		if dlq, err = NewDeadLetterQueue(opts); err != nil {
+			return err
+		}
+		defer dlq.Close()
+	}
+
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  opts.KafkaAddress + ":" + opts.KafkaPort,
+		"group.id":           replayGroupID,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.SubscribeTopics([]string{topic}, nil); err != nil {
+		return err
+	}
+
+	if force {
+		l.Info("Replaying dead letters from", topic)
+	} else {
+		l.Info("Dry run, reading dead letters from", topic)
+	}
+
+	summary := newReplaySummary()
+	for {
+		msg, err := c.ReadMessage(replayIdleTimeout)
+		if err != nil {
+			if e, ok := err.(kafka.Error); ok && e.Code() == kafka.ErrTimedOut {
+				break
+			}
+			return err
+		}
+		summary.add(msg)
+		if !force {
+			continue
+		}
+
+		target, headers := replayTarget(msg, opts.KafkaTopic)
+		err = dlq.produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &target, Partition: kafka.PartitionAny},
+			Key:            msg.Key,
+			Value:          msg.Value,
+			Headers:        headers,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.CommitMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	if force {
+		l.Info("Dead letters replayed:", summary.total, "by event type:", summary.byType)
+		return nil
+	}
+	l.Info("Dead letters found:", summary.total, "by event type:", summary.byType)
+	if !summary.oldest.IsZero() {
+		l.Info("Oldest dead letter failed at", summary.oldest.Format(time.RFC3339))
+	}
+	if summary.total > 0 {
+		l.Warn("Replayed deltas overwrite newer data for the same matches, run `dlq replay --force` to send them")
+	}
+	return nil
+}
+
+// replayTarget исходный топик сообщения и его заголовки без dlq-заголовков
+func replayTarget(msg *kafka.Message, defaultTopic string) (string, []kafka.Header) {
+	topic := defaultTopic
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h.Key == dlqHeaderTopic {
+			topic = string(h.Value)
+		}
+		if strings.HasPrefix(h.Key, dlqHeaderPrefix) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+	return topic, headers
+}
+
+// replaySummary сколько сообщений каждого типа лежит в DLQ и когда упало самое старое
+type replaySummary struct {
+	total  int
+	byType map[string]int
+	oldest time.Time
+}
+
+func newReplaySummary() *replaySummary {
+	return &replaySummary{byType: make(map[string]int)}
+}
+
+func (s *replaySummary) add(msg *kafka.Message) {
+	s.total++
+	name := "unknown"
+	if eventType, err := EventType(msg); err == nil {
+		name = strconv.Itoa(eventType)
+	}
+	s.byType[name]++
+
+	for _, h := range msg.Headers {
+		if h.Key != dlqHeaderFailedAt {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, string(h.Value)); err == nil && (s.oldest.IsZero() || at.Before(s.oldest)) {
+			s.oldest = at
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package consumer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+)
+
+func deadLetter(eventType int, failedAt string) *kafka.Message {
+	topic := "bookmaker_events.dlq"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers: []kafka.Header{
+			{Key: constants.HEADER_EVENT_TYPE, Value: []byte(strconv.Itoa(eventType))},
+			{Key: dlqHeaderTopic, Value: []byte("bookmaker_events")},
+			{Key: dlqHeaderError, Value: []byte("deadlock detected")},
+			{Key: dlqHeaderFailedAt, Value: []byte(failedAt)},
+		},
+	}
+}
+
+func TestReplayTarget(t *testing.T) {
+	topic, headers := replayTarget(deadLetter(constants.BET_UPDATE, "2026-10-01T10:00:00Z"), "default")
+	if topic != "bookmaker_events" {
+		t.Fatalf("topic = %q", topic)
+	}
+	if len(headers) != 1 || headers[0].Key != constants.HEADER_EVENT_TYPE {
+		t.Fatalf("headers = %v, want only the event type", headers)
+	}
+
+	if topic, _ := replayTarget(&kafka.Message{}, "default"); topic != "default" {
+		t.Fatalf("topic without dlq.topic = %q, want default", topic)
+	}
+}
+
+func TestReplaySummary(t *testing.T) {
+	s := newReplaySummary()
+	s.add(deadLetter(constants.BET_UPDATE, "2026-10-02T10:00:00Z"))
+	s.add(deadLetter(constants.BET_UPDATE, "2026-10-01T10:00:00Z"))
+	s.add(deadLetter(constants.MATCH_UPDATE, "bad"))
+	s.add(&kafka.Message{Value: []byte("{")})
+
+	if s.total != 4 {
+		t.Fatalf("total = %d", s.total)
+	}
+	if s.byType[strconv.Itoa(constants.BET_UPDATE)] != 2 || s.byType[strconv.Itoa(constants.MATCH_UPDATE)] != 1 || s.byType["unknown"] != 1 {
+		t.Fatalf("byType = %v", s.byType)
+	}
+	if want := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC); !s.oldest.Equal(want) {
+		t.Fatalf("oldest = %v, want %v", s.oldest, want)
+	}
+}
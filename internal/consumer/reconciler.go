@@ -0,0 +1,64 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	consdb "github.com/pararti/pinnacle-parser/internal/storage/consumer"
+)
+
+// handleMatchSnapshot сверяет матчи с частью снимка: матчи из снимка сохраняются как обычные,
+// а после приема всех частей отсутствующие в нем матчи помечаются устаревшими
+func (ck *ConsumerKafka) handleMatchSnapshot(ctx context.Context, snapshot kafkadata.Snapshot[*parsed.Match]) error {
+	return ck.reconcile(ctx, snapshotChunkOf(snapshot), func() error {
+		err := storeEach(ck, "snapshot matches", snapshot.Data, func(match *parsed.Match) (any, error) {
+			return match.ID, ck.postgresDB.StoreMatch(ctx, match)
+		})
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int32, 0, len(snapshot.Data))
+		for _, match := range snapshot.Data {
+			ids = append(ids, int32(match.ID))
+		}
+		return ck.postgresDB.ReviveMatches(ctx, ids)
+	})
+}
+
+func (ck *ConsumerKafka) handleBetSnapshot(ctx context.Context, snapshot kafkadata.Snapshot[*parsed.Straight]) error {
+	return ck.reconcile(ctx, snapshotChunkOf(snapshot), func() error {
+		return ck.storeStraights(ctx, "snapshot bets", snapshot.Data, false)
+	})
+}
+
+// reconcile регистрирует снимок до записи части, чтобы ее строки не оказались старше начала снимка
+func (ck *ConsumerKafka) reconcile(ctx context.Context, chunk consdb.SnapshotChunk, store func() error) error {
+	if err := ck.postgresDB.BeginSnapshotChunk(ctx, chunk); err != nil {
+		return fmt.Errorf("begin snapshot %s: %w", chunk.SnapshotID, err)
+	}
+	if err := store(); err != nil {
+		return err
+	}
+
+	stale, completed, err := ck.postgresDB.CompleteSnapshotChunk(ctx, chunk)
+	if err != nil {
+		return fmt.Errorf("complete snapshot %s: %w", chunk.SnapshotID, err)
+	}
+	if completed {
+		ck.logger.Info("Snapshot", chunk.SnapshotID, "event type", chunk.EventType, "applied, marked stale:", stale)
+	}
+	return nil
+}
+
+func snapshotChunkOf[T any](snapshot kafkadata.Snapshot[T]) consdb.SnapshotChunk {
+	return consdb.SnapshotChunk{
+		SnapshotID: snapshot.SnapshotID,
+		EventType:  snapshot.EventType,
+		TakenAt:    snapshot.TakenAt,
+		Chunk:      snapshot.Chunk,
+		Chunks:     snapshot.Chunks,
+	}
+}
@@ -1,41 +1,149 @@
 package core
 
 import (
+	"context"
 	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/alert"
+	"github.com/pararti/pinnacle-parser/internal/grpcapi"
+	"github.com/pararti/pinnacle-parser/internal/health"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
 	"github.com/pararti/pinnacle-parser/internal/options"
+	"github.com/pararti/pinnacle-parser/internal/stateapi"
 	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/internal/stream"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"net/http"
 	"os"
+	"time"
 )
 
 type App struct {
-	Opts    *options.Options
-	Logger  *logger.Logger
-	Engine  abstruct.Engine
-	Storage *storage.MapStorage
-	Sender  abstruct.Sender
+	Opts     *options.Options
+	Reloader *options.Reloader
+	Logger   *logger.Logger
+	Engine   abstruct.Engine
+	Storage  abstruct.StateStore
+	Sender   abstruct.Sender
+	State    *stateapi.Server
+	Grpc     *grpcapi.Server
 }
 
-func InitApp() *App {
-	l := logger.NewLogger()
-
-	o, err := options.NewOptions()
-	if err != nil {
-		l.Fatal(err.Error())
-		os.Exit(1)
+func InitApp(l *logger.Logger, r *options.Reloader) *App {
+	o := r.Current()
+	if err := l.Configure(o.LoggerConfig()); err != nil {
+		l.Fatal("Некорректные настройки логов:", err)
+	}
+	if names := o.PlaintextSecrets(); len(names) > 0 {
+		l.Warn("Секреты заданы открытым текстом, замените их ссылками file:, env: или vault::", names)
 	}
+	alerter := alert.Setup(l, "parser", o)
+	r.OnReload(func(o *options.Options) {
+		if err := l.Configure(o.LoggerConfig()); err != nil {
+			l.Error("Не удалось применить настройки логов:", err)
+		}
+		tracing.SetSampleRatio(o.OtelSampleRatio)
+		alerter.Reconfigure(o)
+	})
 
-	s := storage.NewMapStorage()
+	s := newStateStore(l, o)
 	//sender := NewSenderKafka(l, o, s)
 	var e abstruct.Engine
 	var sender abstruct.Sender
+	ks := NewSenderKafka(l, o, s)
+	checker := health.NewChecker()
+	checker.Ready("kafka", ks.CheckKafka)
 	if o.TestMode {
-		sender = NewTestSender(NewSenderKafka(l, o, s))
+		sender = NewTestSender(ks)
 		e = NewTestMode(l, sender)
 	} else {
-		sender = NewSenderKafka(l, o, s)
-		e = NewEngine(l, s)
+		sender = ks
+		engine := NewEngine(l, s)
+		checker.Live("chrome", engine.CheckChrome)
+		checker.Ready("login", engine.CheckLogin)
+		checker.Ready("payloads", payloadFreshness(s, o.HealthMaxDataAge))
+		alerter.AddRule(alert.Stale("нет ставок от источника", "ставки",
+			func() time.Time { return s.Stats().LastBetsAt },
+			func() time.Duration { return r.Current().AlertBetsStale }))
+		engine.SetRoutes(o.MatchRoutes, o.BetRoutes)
+		site := o.Site
+		r.OnReload(func(o *options.Options) {
+			engine.SetRoutes(o.MatchRoutes, o.BetRoutes)
+			if o.Site == site {
+				return
+			}
+			site = o.Site
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := engine.Navigate(ctx, site); err != nil {
+				l.Error("Не удалось открыть новый site:", err)
+			}
+		})
+		e = engine
+	}
+
+	state := stateapi.NewServer(l, s)
+
+	stateTopic := NewStateTopic(l, ks, s, o.StateTopicName())
+	if err := stateTopic.Ensure(); err != nil {
+		l.Warn("Не удалось создать state топик "+o.StateTopicName()+":", err)
+	}
+	ks.AddListener(stateTopic)
+
+	hub := stream.NewHub(l, s)
+	ks.AddListener(hub)
+	state.Handle("GET /stream/ws", http.HandlerFunc(hub.ServeWS))
+	state.Handle("GET /stream/sse", http.HandlerFunc(hub.ServeSSE))
+
+	metrics.RegisterParser(metrics.NewStateCollector(s))
+	state.Handle("GET /metrics", metrics.Handler())
+	checker.Register(state)
+
+	grpcServer := grpcapi.NewServer(l, s, hub)
+
+	return &App{Logger: l, Opts: o, Reloader: r, Storage: s, Engine: e, Sender: sender, State: state, Grpc: grpcServer}
+}
+
+// payloadFreshness проверяет, что матчи и ставки приходили от источника не дольше maxAge назад.
+// В тестовом режиме события идут мимо хранилища, поэтому проверка там не регистрируется
+func payloadFreshness(s abstruct.StateStore, maxAge time.Duration) health.Check {
+	return func(context.Context) (string, error) {
+		stats := s.Stats()
+		matches, err := health.Freshness("matchups", stats.LastMatchesAt, maxAge)
+		if err != nil {
+			return matches, err
+		}
+		bets, err := health.Freshness("straights", stats.LastBetsAt, maxAge)
+		return matches + ", " + bets, err
 	}
+}
 
-	return &App{Logger: l, Opts: o, Storage: s, Engine: e, Sender: sender}
+// newStateStore создает хранилище состояния по stateBackend. Файл состояния нужен только
+// хранилищу в памяти, redis сам переживает рестарт парсера
+func newStateStore(l *logger.Logger, o *options.Options) abstruct.StateStore {
+	grace := storage.DeleteGrace{Misses: o.DeleteGraceMisses, After: o.DeleteGrace}
+	switch o.StateBackend {
+	case "redis":
+		s, err := storage.NewRedisStorage(l, grace, o.RedisAddress, o.RedisPassword, o.RedisDB, o.RedisPrefix)
+		if err != nil {
+			l.Fatal("Не удалось подключиться к redis "+o.RedisAddress+":", err)
+			os.Exit(1)
+		}
+		return s
+	case "memory", "":
+	default:
+		l.Fatal("Неизвестный stateBackend:", o.StateBackend)
+		os.Exit(1)
+	}
+
+	s := storage.NewMapStorage(grace)
+	if o.StateFile != "" {
+		n, err := s.LoadCheckpoint(o.StateFile)
+		if err != nil {
+			l.Error("Не удалось восстановить состояние из "+o.StateFile+":", err)
+		} else if n > 0 {
+			l.Info("Восстановлено матчей из файла состояния:", n)
+		}
+	}
+	return s
 }
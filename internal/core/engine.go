@@ -2,7 +2,10 @@ package core
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bytedance/sonic"
@@ -10,31 +13,78 @@ import (
 	"github.com/chromedp/chromedp"
 
 	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
 	"github.com/pararti/pinnacle-parser/internal/models/parsed"
 	"github.com/pararti/pinnacle-parser/internal/options"
-	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const matchSuffix = "related"
-const straightSuffix = "straight"
+// matchKind и betKind вид перехваченного ответа в метриках и трассах
+const matchKind = "related"
+const betKind = "straight"
 const maxTime = 1<<63 - 1 //290 years
 
 type Engine struct {
 	logger    *logger.Logger
 	Sender    *abstruct.Sender
-	Storage   *storage.MapStorage
-	matchChan chan []byte
-	betChan   chan []byte
+	Storage   abstruct.StateStore
+	matchChan chan captured
+	betChan   chan captured
+	routes    atomic.Pointer[routes]
+
+	// browser контекст chromedp для проверок здоровья, nil до запуска браузера
+	mu       sync.RWMutex
+	browser  context.Context
+	loggedIn atomic.Bool
+
+	// clockOffset разница между часами Chrome и его монотонным временем событий CDP
+	clockOffset atomic.Pointer[time.Duration]
 }
 
-func NewEngine(l *logger.Logger, s *storage.MapStorage) *Engine {
-	return &Engine{
-		logger:    l,
+// routes окончания адресов ответов с матчами и со ставками, см. matchRoutes и betRoutes
+type routes struct {
+	matches []string
+	bets    []string
+}
+
+func NewEngine(l *logger.Logger, s abstruct.StateStore) *Engine {
+	e := &Engine{
+		logger:    l.Named("engine"),
 		Storage:   s,
-		matchChan: make(chan []byte, 10),
-		betChan:   make(chan []byte, 10),
+		matchChan: make(chan captured, 10),
+		betChan:   make(chan captured, 10),
+	}
+	e.SetRoutes([]string{matchKind}, []string{betKind})
+	return e
+}
+
+// SetRoutes меняет окончания адресов перехватываемых ответов, действует со следующего ответа
+func (e *Engine) SetRoutes(matches, bets []string) {
+	e.routes.Store(&routes{matches: matches, bets: bets})
+}
+
+// route выбирает по адресу ответа его вид и канал обработки, false если ответ не нужен
+func (e *Engine) route(url string) (string, chan captured, bool) {
+	r := e.routes.Load()
+	switch {
+	case hasSuffix(url, r.matches):
+		return matchKind, e.matchChan, true
+	case hasSuffix(url, r.bets):
+		return betKind, e.betChan, true
+	}
+	return "", nil, false
+}
+
+func hasSuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
 	}
+	return false
 }
 
 func (e *Engine) Start(appOpts *options.Options) {
@@ -61,7 +111,7 @@ func (e *Engine) Start(appOpts *options.Options) {
 
 	if appOpts.RemoteChromeURL != "" {
 		// Connect to remote Chrome instance
-		e.logger.Info("Подключение к удаленному Chrome по адресу %s", appOpts.RemoteChromeURL)
+		e.logger.Infof("Подключение к удаленному Chrome по адресу %s", appOpts.Display("remoteChromeURL"))
 		allocCtx, cancelAlloc = chromedp.NewRemoteAllocator(context.Background(), appOpts.RemoteChromeURL, chromedp.NoModifyURL)
 	} else {
 		// Use local Chrome instance
@@ -71,6 +121,9 @@ func (e *Engine) Start(appOpts *options.Options) {
 
 	ctx, cancel := chromedp.NewContext(allocCtx)
 	defer cancel()
+	e.mu.Lock()
+	e.browser = ctx
+	e.mu.Unlock()
 
 	e.logger.Info("Проверка статуса авторизации...")
 
@@ -96,6 +149,7 @@ func (e *Engine) Start(appOpts *options.Options) {
 	}
 
 	if isAuthenticated {
+		e.loggedIn.Store(true)
 		e.logger.Info("Пользователь уже авторизован. Продолжаем работу...")
 	} else {
 		e.logger.Warn("Требуется авторизация")
@@ -105,7 +159,7 @@ func (e *Engine) Start(appOpts *options.Options) {
 
 	// Enable network events
 	if err := chromedp.Run(ctx, network.Enable()); err != nil {
-		e.logger.Fatal("Failed to enable network events: %v", err)
+		e.logger.Fatalf("Failed to enable network events: %v", err)
 	}
 
 	go e.processMatches()
@@ -114,39 +168,14 @@ func (e *Engine) Start(appOpts *options.Options) {
 	e.logger.Info("Запуск браузерного движка и прослушивания событий")
 
 	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if request, ok := ev.(*network.EventRequestWillBeSent); ok {
+			e.calibrateClock(request)
+		}
 		if response, ok := ev.(*network.EventResponseReceived); ok {
 			if response.Type == network.ResourceTypeFetch {
-				if strings.HasSuffix(response.Response.URL, matchSuffix) {
-					go func(requestID network.RequestID) {
-						var body []byte
-						err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
-							var err error
-							body, err = network.GetResponseBody(requestID).Do(ctx)
-
-							return err
-						}))
-						if err != nil {
-							e.logger.Warn("Failed to get body:", err)
-							return
-						}
-						e.matchChan <- body
-					}(response.RequestID)
-				} else if strings.HasSuffix(response.Response.URL, straightSuffix) {
-					go func(requestID network.RequestID) {
-						var body []byte
-						err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
-							var err error
-							body, err = network.GetResponseBody(requestID).Do(ctx)
-							return err
-						}))
-						if err != nil {
-							e.logger.Warn("Failed to get body:", err)
-							return
-						}
-						e.betChan <- body
-					}(response.RequestID)
+				if kind, out, ok := e.route(response.Response.URL); ok {
+					go e.capture(ctx, response, kind, out)
 				}
-
 			}
 		}
 	})
@@ -157,33 +186,95 @@ func (e *Engine) Start(appOpts *options.Options) {
 		chromedp.Reload(),
 		chromedp.Sleep(time.Duration(maxTime)),
 	); err != nil {
-		e.logger.Fatal("Navigation error: %v", err)
+		e.logger.Fatalf("Navigation error: %v", err)
+	}
+}
+
+// captured тело ответа и контекст трассы, начатой при его получении. Span трассы
+// закрывается после записи данных в хранилище
+type captured struct {
+	ctx  context.Context
+	at   time.Time
+	body []byte
+}
+
+// capture забирает тело ответа из браузера и начинает трассу изменения цены
+func (e *Engine) capture(ctx context.Context, response *network.EventResponseReceived, kind string, out chan<- captured) {
+	traceCtx, span := tracing.Tracer().Start(context.Background(), "engine.capture "+kind,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("url.full", response.Response.URL), attribute.String("pinnacle.kind", kind)))
+
+	var body []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetResponseBody(response.RequestID).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		tracing.Fail(span, err)
+		span.End()
+		e.logger.Warn("Failed to get body:", err)
+		return
+	}
+	span.SetAttributes(attribute.Int("pinnacle.body_size", len(body)))
+	metrics.CapturedBodies.WithLabelValues(kind).Inc()
+	out <- captured{ctx: traceCtx, at: e.capturedAt(response), body: body}
+}
+
+// calibrateClock запоминает смещение монотонного времени CDP относительно часов Chrome.
+// У ответа есть только монотонное время, а у запроса есть оба, поэтому смещение берется из запросов
+func (e *Engine) calibrateClock(request *network.EventRequestWillBeSent) {
+	if request.Timestamp == nil || request.WallTime == nil {
+		return
 	}
+	offset := request.WallTime.Time().Sub(request.Timestamp.Time())
+	e.clockOffset.Store(&offset)
+}
+
+// capturedAt время получения ответа браузером. Без калибровки монотонное время считается от
+// загрузки этой машины, что неверно для удаленного Chrome, поэтому тогда берется время парсера
+func (e *Engine) capturedAt(response *network.EventResponseReceived) time.Time {
+	offset := e.clockOffset.Load()
+	if response.Timestamp == nil || offset == nil {
+		return time.Now()
+	}
+	return response.Timestamp.Time().Add(*offset)
 }
 
 func (e *Engine) processMatches() {
-	for body := range e.matchChan {
+	for c := range e.matchChan {
+		span := trace.SpanFromContext(c.ctx)
 		var matches []*parsed.Match
-		if err := sonic.Unmarshal(body, &matches); err != nil {
+		if err := sonic.Unmarshal(c.body, &matches); err != nil {
+			metrics.UnmarshalErrors.WithLabelValues(matchKind).Inc()
+			tracing.Fail(span, err)
 			e.logger.Error("Failed to unmarshal match data:", err)
-		} else {
-			e.Storage.SetMatches(matches)
+		} else if len(matches) > 0 {
+			e.Storage.SetMatches(c.ctx, matches)
 		}
+		span.End()
 	}
 }
 
+// processBets передает в хранилище только что полученный пакет ставок. Прежние пакеты не
+// повторяются, иначе рынки удаленного матча вернулись бы со следующим пакетом
 func (e *Engine) processBets() {
-	batches := make(map[int][]*parsed.Straight)
-	for body := range e.betChan {
+	for c := range e.betChan {
+		span := trace.SpanFromContext(c.ctx)
 		var bets []*parsed.Straight
-		if err := sonic.Unmarshal(body, &bets); err != nil {
+		if err := sonic.Unmarshal(c.body, &bets); err != nil {
+			metrics.UnmarshalErrors.WithLabelValues(betKind).Inc()
+			tracing.Fail(span, err)
 			e.logger.Error("Failed to unmarshal bet data:", err)
 		} else {
 			if len(bets) > 0 {
-				batches[bets[0].MatchupID] = bets
-				e.Storage.SetBets(batches)
+				for _, bet := range bets {
+					bet.CapturedAt = c.at
+				}
+				e.Storage.SetBets(c.ctx, map[int][]*parsed.Straight{bets[0].MatchupID: bets})
 			}
 		}
+		span.End()
 	}
 }
 
@@ -202,13 +293,14 @@ func (e *Engine) performLogin(ctx context.Context, username, password, link stri
 		return
 	}
 
+	e.loggedIn.Store(true)
 	e.logger.Info("Успешная авторизация! Ожидаем появления модального окна...")
 
 	modalSelector := `button[data-test-id="Button"][type="button"][class*="button-l9TRHt6rdY fullWidth-RjvaOdiHkK ellipsis medium-sdlPvkH2AX dead-center ghostOnLight-DuD1oNNBJh"]`
 
 	err = chromedp.Run(ctx, chromedp.Sleep(3*time.Second))
 	if err != nil {
-		e.logger.Warn("Ошибка при ожидании: %v", err)
+		e.logger.Warnf("Ошибка при ожидании: %v", err)
 	}
 
 	err = chromedp.Run(ctx,
@@ -220,7 +312,7 @@ func (e *Engine) performLogin(ctx context.Context, username, password, link stri
 	)
 
 	if err != nil {
-		e.logger.Error("Не удалось найти кнопку с точным селектором: %v", err)
+		e.logger.Errorf("Не удалось найти кнопку с точным селектором: %v", err)
 		e.logger.Error("Пробуем альтернативный селектор")
 
 		err = chromedp.Run(ctx,
@@ -228,7 +320,7 @@ func (e *Engine) performLogin(ctx context.Context, username, password, link stri
 		)
 
 		if err != nil {
-			e.logger.Error("Альтернативный селектор не сработал: %v", err)
+			e.logger.Errorf("Альтернативный селектор не сработал: %v", err)
 			e.logger.Error("Пробуем еще один вариант")
 
 			err = chromedp.Run(ctx,
@@ -238,8 +330,61 @@ func (e *Engine) performLogin(ctx context.Context, username, password, link stri
 	}
 
 	if err != nil {
-		e.logger.Warn("Не удалось закрыть модальное окно: %v. Продолжаем работу...", err)
+		e.logger.Warnf("Не удалось закрыть модальное окно: %v. Продолжаем работу...", err)
 	} else {
 		e.logger.Info("Успешно закрыли модальное окно!")
 	}
 }
+
+// CheckChrome проверяет, что вкладка браузера отвечает
+func (e *Engine) CheckChrome(ctx context.Context) (string, error) {
+	var state string
+	if err := e.evaluate(ctx, `document.readyState`, &state); err != nil {
+		return "", err
+	}
+	return "document " + state, nil
+}
+
+// CheckLogin проверяет, что парсер авторизовался и страница не показывает форму входа
+func (e *Engine) CheckLogin(ctx context.Context) (string, error) {
+	if !e.loggedIn.Load() {
+		return "", errors.New("login has not completed")
+	}
+	var loginForm bool
+	if err := e.evaluate(ctx, `!!document.querySelector('input#password')`, &loginForm); err != nil {
+		return "", err
+	}
+	if loginForm {
+		return "", errors.New("login form is shown, session has expired")
+	}
+	return "logged in", nil
+}
+
+// evaluate выполняет выражение во вкладке парсера с таймаутом из ctx
+func (e *Engine) evaluate(ctx context.Context, expr string, res any) error {
+	return e.run(ctx, chromedp.Evaluate(expr, res))
+}
+
+// Navigate открывает site во вкладке парсера, например после смены site в настройках.
+// Перехват ответов продолжает работать на новой странице
+func (e *Engine) Navigate(ctx context.Context, site string) error {
+	return e.run(ctx, chromedp.Navigate(site))
+}
+
+// run выполняет действия во вкладке парсера с таймаутом из ctx
+func (e *Engine) run(ctx context.Context, actions ...chromedp.Action) error {
+	e.mu.RLock()
+	browser := e.browser
+	e.mu.RUnlock()
+	if browser == nil {
+		return errors.New("browser is not started")
+	}
+
+	// chromedp берет вкладку из контекста browser, поэтому отмену ctx переносим на него вручную
+	runCtx, cancel := context.WithCancel(browser)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	return chromedp.Run(runCtx, actions...)
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func TestEngineRoutes(t *testing.T) {
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	e := NewEngine(l, nil)
+
+	check := func(url, wantKind string) {
+		t.Helper()
+		kind, out, ok := e.route(url)
+		if wantKind == "" {
+			if ok {
+				t.Fatalf("%s routed as %s", url, kind)
+			}
+			return
+		}
+		want := e.matchChan
+		if wantKind == betKind {
+			want = e.betChan
+		}
+		if !ok || kind != wantKind || out != want {
+			t.Fatalf("%s routed as %q, want %q", url, kind, wantKind)
+		}
+	}
+
+	check("https://guest.api.arcadia.pinnacle.com/0.1/matchups/1/related", matchKind)
+	check("https://guest.api.arcadia.pinnacle.com/0.1/matchups/1/markets/straight", betKind)
+	check("https://guest.api.arcadia.pinnacle.com/0.1/sports", "")
+
+	// после перезагрузки настроек старые окончания больше не перехватываются
+	e.SetRoutes([]string{"matchups", "related/v2"}, []string{"markets"})
+	check("https://guest.api.arcadia.pinnacle.com/0.1/matchups/1/related", "")
+	check("https://guest.api.arcadia.pinnacle.com/0.1/leagues/1/matchups", matchKind)
+	check("https://guest.api.arcadia.pinnacle.com/0.1/matchups/1/related/v2", matchKind)
+	check("https://guest.api.arcadia.pinnacle.com/0.1/leagues/1/markets", betKind)
+}
+
+func testMatch(id int) *parsed.Match {
+	return &parsed.Match{ID: id, ParentId: 1, StartTime: time.Unix(1_700_000_000, 0),
+		League: &parsed.League{ID: 1, Name: "League", Sport: &parsed.Sport{ID: 29, Name: "Soccer"}}}
+}
+
+// TestEngineBetsAfterDelete следующий пакет ставок не возвращает рынки удаленного матча
+func TestEngineBetsAfterDelete(t *testing.T) {
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	s := storage.NewMapStorage(storage.DeleteGrace{})
+	e := NewEngine(l, s)
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		e.processBets()
+		close(done)
+	}()
+	payload := func(bets ...*parsed.Straight) {
+		t.Helper()
+		body, err := sonic.Marshal(bets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.betChan <- captured{ctx: ctx, at: time.Now(), body: body}
+	}
+	waitBets := func(matchID int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); len(s.MatchBets(matchID)) == 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("bets of match %d are not stored", matchID)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	s.SetMatches(ctx, []*parsed.Match{testMatch(1), testMatch(2)})
+	payload(parsed.GenerateExampleStraight(1))
+	waitBets(1)
+	s.GetNewMatches()
+	s.GetNewBets()
+
+	s.SetMatches(ctx, []*parsed.Match{testMatch(2)})
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatalf("deleted = %v, want [1]", deleted)
+	}
+
+	payload(parsed.GenerateExampleStraight(2))
+	close(e.betChan)
+	<-done
+
+	if bets := s.MatchBets(1); len(bets) != 0 {
+		t.Fatalf("markets of the deleted match are back: %d", len(bets))
+	}
+	newBets, _ := s.GetNewBets()
+	if len(newBets) != 1 || newBets[0].MatchupID != 2 {
+		t.Fatalf("BET_NEW = %+v, want only match 2", newBets)
+	}
+}
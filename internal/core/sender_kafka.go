@@ -1,24 +1,52 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/health"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
 	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
 	"github.com/pararti/pinnacle-parser/internal/options"
-	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/constants"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queueFullRetries сколько раз повторить Produce при переполненной очереди продюсера, прежде чем
+// вернуть отличия в хранилище; produceBackoff пауза перед повтором и после неудачной отправки
+const (
+	queueFullRetries = 5
+	produceBackoff   = 200 * time.Millisecond
 )
 
 type SenderKafka struct {
 	logger   *logger.Logger
 	producer *kafka.Producer
-	store    *storage.MapStorage
+	store    abstruct.StateStore
+	// outbox хранилище, которому нужно подтверждать доставку отличий; nil, если не нужно
+	outbox abstruct.Outbox
+	// requeuer хранилище без outbox, которому возвращаются отличия, не принятые kafka
+	requeuer abstruct.Requeuer
+	// listeners получают события после отправки в kafka, например стриминг клиентам
+	listeners []abstruct.EventListener
+	// sending держат отправки отличий от выборки из хранилища до Produce, Drain ждет их
+	sending sync.RWMutex
+
+	snapshotInterval  time.Duration
+	snapshotChunkSize int
 }
 
-func NewSenderKafka(l *logger.Logger, options *options.Options, s *storage.MapStorage) *SenderKafka {
+func NewSenderKafka(l *logger.Logger, options *options.Options, s abstruct.StateStore) *SenderKafka {
 	addr := options.KafkaAddress + ":" + options.KafkaPort
 	l.Info("Kafka адрес: ", addr)
 	kconf := &kafka.ConfigMap{
@@ -31,110 +59,336 @@ func NewSenderKafka(l *logger.Logger, options *options.Options, s *storage.MapSt
 		l.Fatal("Ну удалось создать продюсер kafka:", err)
 	}
 
-	return &SenderKafka{logger: l, producer: p, store: s}
+	sk := &SenderKafka{
+		logger:            l.Named("sender"),
+		producer:          p,
+		store:             s,
+		snapshotInterval:  options.SnapshotInterval,
+		snapshotChunkSize: options.SnapshotChunkSize,
+	}
+	if outbox, ok := s.(abstruct.Outbox); ok {
+		sk.outbox = outbox
+	} else if requeuer, ok := s.(abstruct.Requeuer); ok {
+		sk.requeuer = requeuer
+	}
+	return sk
+}
+
+// AddListener подписывает listener на события; вызывать до Start
+func (sk *SenderKafka) AddListener(l abstruct.EventListener) {
+	sk.listeners = append(sk.listeners, l)
+}
+
+func (sk *SenderKafka) notify(eventType int, data any) {
+	for _, l := range sk.listeners {
+		l.OnEvent(eventType, data)
+	}
 }
 
 func (sk *SenderKafka) Send(data []byte, topic *string) {
+	sk.produce(context.Background(), data, topic, nil, nil, nil)
+}
+
+// sendEvent отправляет событие с заголовком типа, чтобы консьюмеру не приходилось разбирать тело.
+// items отличия события, outbox записи хранилища, которые подтверждаются после доставки.
+// У снимков items nil: их не нужно возвращать в хранилище, следующий снимок уйдет по расписанию
+func (sk *SenderKafka) sendEvent(ctx context.Context, eventType int, items any, data []byte, topic *string, outbox []string) {
+	headers := []kafka.Header{{Key: constants.HEADER_EVENT_TYPE, Value: []byte(strconv.Itoa(eventType))}}
+	var event *pendingEvent
+	if items != nil {
+		event = &pendingEvent{eventType: eventType, items: items, outbox: outbox}
+	}
+	sk.produce(ctx, data, topic, nil, headers, event)
+}
+
+// pendingEvent отличия отправленного события: после доставки подтверждаются записи outbox,
+// при ошибке отличия возвращаются в хранилище
+type pendingEvent struct {
+	eventType int
+	items     any
+	outbox    []string
+}
+
+// delivered подтверждает доставку события хранилищу с outbox
+func (sk *SenderKafka) delivered(event *pendingEvent) {
+	if event != nil {
+		sk.ack(event.outbox)
+	}
+}
+
+// failed возвращает отличия недоставленного события в хранилище. Хранилище с outbox отправит
+// неподтвержденные записи повторно само
+func (sk *SenderKafka) failed(event *pendingEvent) {
+	if event != nil && sk.requeuer != nil {
+		sk.requeuer.Requeue(event.eventType, event.items)
+	}
+}
+
+// taken записи outbox, отличия из которых только что забрал Get* для eventType
+func (sk *SenderKafka) taken(eventType int) []string {
+	if sk.outbox == nil {
+		return nil
+	}
+	return sk.outbox.Taken(eventType)
+}
+
+func (sk *SenderKafka) ack(ids []string) {
+	if sk.outbox != nil && len(ids) > 0 {
+		sk.outbox.Ack(ids)
+	}
+}
+
+// startSend начинает span отправки отличий, который продолжает трассу захвата
+func startSend(origin tracing.Origin, eventType, n int) (context.Context, trace.Span) {
+	return origin.Start(context.Background(), "sender."+metrics.EventName(eventType),
+		trace.WithAttributes(attribute.Int("pinnacle.items", n)))
+}
+
+// delivery Opaque сообщения, возвращается в отчете о доставке
+type delivery struct {
+	sent time.Time
+	// span отправки, закрывается в listenEvent; nil, если сообщение не трассируется
+	span trace.Span
+	// event отличия события; nil для снимков и state топика
+	event *pendingEvent
+}
+
+// produce отправляет сообщение. Если ctx несет span, отправка получает свой span
+// до отчета о доставке, а traceparent уходит в заголовках для консьюмера. При переполненной
+// очереди продюсера Produce повторяется, при остальных ошибках отличия event возвращаются в хранилище
+func (sk *SenderKafka) produce(ctx context.Context, data []byte, topic *string, key []byte, headers []kafka.Header, event *pendingEvent) {
+	d := &delivery{sent: time.Now(), event: event}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		ctx, d.span = tracing.Tracer().Start(ctx, "kafka.produce "+*topic, trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attribute.String("messaging.system", "kafka"), attribute.String("messaging.destination.name", *topic)))
+		tracing.Inject(ctx, &headers)
+	}
+
 	msg := kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: topic, Partition: kafka.PartitionAny},
+		Key:            key,
 		Value:          data,
+		Headers:        headers,
+		Opaque:         d,
 	}
 
-	err := sk.producer.Produce(&msg, nil)
-	if err != nil {
-		if err.(kafka.Error).Code() == kafka.ErrQueueFull {
-			sk.logger.Error("Kafka переполнена очередь ждём одну секунду")
-			//у нас переполнена очередь подождём секунду
-			time.Sleep(time.Millisecond * 200)
-		} else {
-			sk.logger.Error("Kafka ошибка", err)
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = sk.producer.Produce(&msg, nil)
+		if !isQueueFull(err) {
+			break
+		}
+		metrics.KafkaProduceFailures.WithLabelValues(*topic, "queue_full").Inc()
+		if attempt == queueFullRetries {
+			break
 		}
+		sk.logger.Warn("Kafka переполнена очередь, повторяем отправку")
+		time.Sleep(produceBackoff)
+	}
+	if err == nil {
+		return
+	}
+
+	if d.span != nil {
+		tracing.Fail(d.span, err)
+		d.span.End()
+	}
+	if !isQueueFull(err) {
+		metrics.KafkaProduceFailures.WithLabelValues(*topic, "produce").Inc()
 	}
+	sk.logger.Error("Kafka ошибка", err)
+	sk.failed(event)
+	// иначе возвращенные отличия сразу уйдут снова и упрутся в ту же ошибку
+	time.Sleep(produceBackoff)
+}
+
+func isQueueFull(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && kerr.Code() == kafka.ErrQueueFull
 }
 
 func (sk *SenderKafka) Start(topic string) {
 	sk.logger.Info("Запуск отправки сообщений в кафку")
 	go sk.listenEvent()
+	notes := sk.store.Notifications()
+	if sk.snapshotInterval > 0 {
+		go sk.sendSnapshots(topic)
+	}
 
 	go func() {
-		for n := range sk.store.MatchNewChan {
-			matches := sk.store.GetNewMatches(n)
-			if len(matches) == 0 {
-				continue
-			}
-			data := kafkadata.Match{EventType: constants.MATCH_NEW, Source: constants.SOURCE, Data: matches}
-			jsonData, err := sonic.Marshal(data)
-			if err != nil {
-				sk.logger.Error("Failed to marshal new matches data:", err)
-				continue
-			}
-			sk.Send(jsonData, &topic)
+		for range notes.MatchNew {
+			sk.drainable(func() {
+				matches, origin := sk.store.GetNewMatches()
+				outbox := sk.taken(constants.MATCH_NEW)
+				if len(matches) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.MATCH_NEW, len(matches))
+				data := kafkadata.Match{EventType: constants.MATCH_NEW, Source: constants.SOURCE, Data: matches}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal new matches data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.MATCH_NEW, matches, jsonData, &topic, outbox)
+				sk.notify(constants.MATCH_NEW, matches)
+				span.End()
+			})
 		}
 	}()
 
 	go func() {
-		for n := range sk.store.BetNewChan {
-			bets := sk.store.GetNewBets(n)
-			if len(bets) == 0 {
-				continue
-			}
-			data := kafkadata.Bet{EventType: constants.BET_NEW, Source: constants.SOURCE, Data: bets}
-			jsonData, err := sonic.Marshal(data)
-			if err != nil {
-				sk.logger.Error("Failed to marshal new matches data:", err)
-				continue
-			}
-			sk.Send(jsonData, &topic)
+		for range notes.BetNew {
+			sk.drainable(func() {
+				bets, origin := sk.store.GetNewBets()
+				outbox := sk.taken(constants.BET_NEW)
+				if len(bets) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.BET_NEW, len(bets))
+				data := kafkadata.Bet{EventType: constants.BET_NEW, Source: constants.SOURCE, Data: bets}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal new matches data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.BET_NEW, bets, jsonData, &topic, outbox)
+				sk.notify(constants.BET_NEW, bets)
+				span.End()
+			})
 		}
 	}()
 
 	go func() {
-		for n := range sk.store.BetUpdChan {
-			betsData := sk.store.GetUpdatedBets(n)
-			if len(betsData) == 0 {
-				continue
-			}
-			data := kafkadata.BetUpd{EventType: constants.BET_UPDATE, Source: constants.SOURCE, Data: betsData}
-			jsonData, err := sonic.Marshal(data)
-			if err != nil {
-				sk.logger.Error("Failed to marshal bet update data:", err)
-				continue
-			}
-			sk.Send(jsonData, &topic)
+		for range notes.BetUpd {
+			sk.drainable(func() {
+				betsData, origin := sk.store.GetUpdatedBets()
+				outbox := sk.taken(constants.BET_UPDATE)
+				if len(betsData) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.BET_UPDATE, len(betsData))
+				data := kafkadata.BetUpd{EventType: constants.BET_UPDATE, Source: constants.SOURCE, Data: betsData}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal bet update data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.BET_UPDATE, betsData, jsonData, &topic, outbox)
+				sk.notify(constants.BET_UPDATE, betsData)
+				span.End()
+			})
 		}
 	}()
 
 	go func() {
-		for n := range sk.store.MatchUpdChan {
-			matchData := sk.store.GetUpdatedMatches(n)
-			if len(matchData) == 0 {
-				continue
-			}
-			data := kafkadata.MatchUpd{EventType: constants.MATCH_UPDATE, Source: constants.SOURCE, Data: matchData}
-			jsonData, err := sonic.Marshal(data)
-			if err != nil {
-				sk.logger.Error("Failed to marshal match update data:", err)
-				continue
-			}
-			sk.Send(jsonData, &topic)
+		for range notes.MatchUpd {
+			sk.drainable(func() {
+				matchData, origin := sk.store.GetUpdatedMatches()
+				outbox := sk.taken(constants.MATCH_UPDATE)
+				if len(matchData) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.MATCH_UPDATE, len(matchData))
+				data := kafkadata.MatchUpd{EventType: constants.MATCH_UPDATE, Source: constants.SOURCE, Data: matchData}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal match update data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.MATCH_UPDATE, matchData, jsonData, &topic, outbox)
+				sk.notify(constants.MATCH_UPDATE, matchData)
+				span.End()
+			})
 		}
 	}()
 
 	go func() {
-		for deletedMatchIds := range sk.store.MatchDelChan {
-			data := kafkadata.DeletedMatch{EventType: constants.MATCH_DELETE, Source: constants.SOURCE, Data: deletedMatchIds}
-			jsonData, err := sonic.Marshal(data)
-			if err != nil {
-				sk.logger.Error("Failed to marshal match delete data:", err)
-				continue
-			}
-			sk.Send(jsonData, &topic)
+		for range notes.MatchRestored {
+			sk.drainable(func() {
+				matches, origin := sk.store.GetRestoredMatches()
+				outbox := sk.taken(constants.MATCH_RESTORED)
+				if len(matches) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.MATCH_RESTORED, len(matches))
+				data := kafkadata.Event[*parsed.Match]{EventType: constants.MATCH_RESTORED, Source: constants.SOURCE, Data: matches}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal restored matches data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.MATCH_RESTORED, matches, jsonData, &topic, outbox)
+				sk.notify(constants.MATCH_RESTORED, matches)
+				span.End()
+			})
+		}
+	}()
+
+	go func() {
+		for range notes.MatchDel {
+			sk.drainable(func() {
+				deletedMatchIds, origin := sk.store.GetDeletedMatches()
+				outbox := sk.taken(constants.MATCH_DELETE)
+				if len(deletedMatchIds) == 0 {
+					sk.ack(outbox)
+					return
+				}
+				ctx, span := startSend(origin, constants.MATCH_DELETE, len(deletedMatchIds))
+				data := kafkadata.DeletedMatch{EventType: constants.MATCH_DELETE, Source: constants.SOURCE, Data: deletedMatchIds}
+				jsonData, err := sonic.Marshal(data)
+				if err != nil {
+					tracing.Fail(span, err)
+					span.End()
+					sk.logger.Error("Failed to marshal match delete data:", err)
+					return
+				}
+				sk.sendEvent(ctx, constants.MATCH_DELETE, deletedMatchIds, jsonData, &topic, outbox)
+				sk.notify(constants.MATCH_DELETE, deletedMatchIds)
+				span.End()
+			})
 		}
 	}()
 
 	select {}
 }
 
+// CheckKafka запрашивает метаданные кластера, чтобы убедиться, что брокеры доступны продюсеру
+func (sk *SenderKafka) CheckKafka(ctx context.Context) (string, error) {
+	return health.KafkaBrokers(ctx, sk.producer)
+}
+
+// drainable выполняет отправку под sending, чтобы забранные из хранилища отличия не потерялись
+// между выборкой и Produce при остановке
+func (sk *SenderKafka) drainable(send func()) {
+	sk.sending.RLock()
+	defer sk.sending.RUnlock()
+	send()
+}
+
+// Drain дожидается начатых отправок, останавливает новые и ждет доставки очереди продюсера
+// не дольше timeout. Возвращает число недоставленных сообщений. Незабранные отличия остаются
+// в хранилище и попадают в файл состояния
+func (sk *SenderKafka) Drain(timeout time.Duration) int {
+	sk.sending.Lock()
+	return sk.producer.Flush(int(timeout.Milliseconds()))
+}
+
 func (sk *SenderKafka) Stop() {
 	sk.producer.Close()
 }
@@ -144,8 +398,26 @@ func (sk *SenderKafka) listenEvent() {
 		switch ev := e.(type) {
 		case *kafka.Message:
 			m := ev
+			topic := ""
+			if m.TopicPartition.Topic != nil {
+				topic = *m.TopicPartition.Topic
+			}
+			d, _ := m.Opaque.(*delivery)
 			if m.TopicPartition.Error != nil {
+				metrics.KafkaProduceFailures.WithLabelValues(topic, "delivery").Inc()
 				sk.logger.Error("Ошибка в доставке сообщения: " + m.TopicPartition.Error.Error())
+				if d != nil {
+					sk.failed(d.event)
+				}
+			} else if d != nil {
+				metrics.KafkaProduceSeconds.WithLabelValues(topic).Observe(time.Since(d.sent).Seconds())
+				sk.delivered(d.event)
+			}
+			if d != nil && d.span != nil {
+				tracing.Fail(d.span, m.TopicPartition.Error)
+				d.span.SetAttributes(attribute.Int("messaging.destination.partition.id", int(m.TopicPartition.Partition)),
+					attribute.Int64("messaging.kafka.offset", int64(m.TopicPartition.Offset)))
+				d.span.End()
 			}
 		case kafka.Error:
 			sk.logger.Error("Ошибка kafka: " + ev.Error())
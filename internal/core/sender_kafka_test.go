@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// TestSenderRequeuesRejected событие, которое продюсер не принял, возвращается в хранилище
+func TestSenderRequeuesRejected(t *testing.T) {
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	// брокер не нужен: сообщение больше message.max.bytes отклоняется в самом Produce
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "127.0.0.1:1", "message.max.bytes": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	s := storage.NewMapStorage(storage.DeleteGrace{})
+	ctx := context.Background()
+	s.SetMatches(ctx, []*parsed.Match{testMatch(1)})
+	s.SetBets(ctx, map[int][]*parsed.Straight{1: {parsed.GenerateExampleStraight(1)}})
+	s.GetNewMatches()
+	bets, _ := s.GetNewBets()
+
+	sk := &SenderKafka{logger: l, producer: p, store: s, requeuer: s}
+	topic := "bookmaker_event"
+	sk.sendEvent(ctx, constants.BET_NEW, bets, []byte(strings.Repeat("x", 2000)), &topic, nil)
+
+	requeued, _ := s.GetNewBets()
+	if len(requeued) != 1 || requeued[0].Key != bets[0].Key {
+		t.Fatalf("requeued bets = %+v, want %s", requeued, bets[0].Key)
+	}
+
+	// снимки не возвращаются: следующий уйдет по расписанию
+	sk.sendEvent(ctx, constants.BET_SNAPSHOT, nil, []byte(strings.Repeat("x", 2000)), &topic, nil)
+	if again, _ := s.GetNewBets(); len(again) != 0 {
+		t.Fatalf("snapshot requeued %d bets", len(again))
+	}
+}
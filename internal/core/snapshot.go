@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+)
+
+// sendSnapshots периодически отправляет полное состояние хранилища, чтобы консьюмеры,
+// пропустившие дельты, могли сверить с ним свои данные
+func (sk *SenderKafka) sendSnapshots(topic string) {
+	ticker := time.NewTicker(sk.snapshotInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sk.sendSnapshot(topic)
+	}
+}
+
+func (sk *SenderKafka) sendSnapshot(topic string) {
+	matches := sk.store.MatchList()
+	if len(matches) == 0 {
+		// пустой снимок пометил бы у консьюмера все данные устаревшими, например сразу после старта
+		sk.logger.Warn("Хранилище пустое, снимок состояния не отправлен")
+		return
+	}
+
+	bets := make([]*parsed.Straight, 0, len(matches)*8)
+	for _, m := range matches {
+		bets = append(bets, sk.store.MatchBets(m.ID)...)
+	}
+
+	takenAt := time.Now().UTC()
+	id := strconv.FormatInt(takenAt.UnixMilli(), 10)
+	sendSnapshotChunks(sk, topic, constants.MATCH_SNAPSHOT, id, takenAt, matches)
+	sendSnapshotChunks(sk, topic, constants.BET_SNAPSHOT, id, takenAt, bets)
+	sk.logger.Info("Отправлен снимок состояния", id, "матчей:", len(matches), "рынков:", len(bets))
+}
+
+// sendSnapshotChunks делит снимок на части по snapshotChunkSize элементов
+func sendSnapshotChunks[T any](sk *SenderKafka, topic string, eventType int, id string, takenAt time.Time, items []T) {
+	size := max(sk.snapshotChunkSize, 1)
+	chunks := max((len(items)+size-1)/size, 1)
+
+	for chunk := 0; chunk < chunks; chunk++ {
+		data := items[min(chunk*size, len(items)):min((chunk+1)*size, len(items))]
+		jsonData, err := sonic.Marshal(kafkadata.Snapshot[T]{
+			EventType:  eventType,
+			Source:     constants.SOURCE,
+			SnapshotID: id,
+			TakenAt:    takenAt,
+			Chunk:      chunk,
+			Chunks:     chunks,
+			Data:       data,
+		})
+		if err != nil {
+			sk.logger.Error("Failed to marshal snapshot chunk:", err)
+			return
+		}
+		sk.sendEvent(context.Background(), eventType, nil, jsonData, &topic, nil)
+	}
+}
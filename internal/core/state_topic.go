@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+const stateTopicTimeout = 10 * time.Second
+
+// StateTopic ведет log-compacted топик с полным текущим состоянием. Ключ match:<id> содержит
+// parsed.Match, ключ bet:<matchId>:<key> содержит parsed.Straight. При удалении матча
+// по всем его ключам отправляются tombstone, поэтому компакция оставляет только живые записи
+type StateTopic struct {
+	logger *logger.Logger
+	sender *SenderKafka
+	store  abstruct.StateStore
+	topic  string
+
+	// mu упорядочивает чтение состояния и отправку, иначе старая версия записи могла бы уйти позже новой
+	mu sync.Mutex
+	// markets ключи рынков, опубликованные для матча, нужны для tombstone после удаления матча
+	markets map[int]map[string]struct{}
+}
+
+func NewStateTopic(l *logger.Logger, sk *SenderKafka, s abstruct.StateStore, topic string) *StateTopic {
+	st := &StateTopic{logger: l.Named("statetopic"), sender: sk, store: s, topic: topic, markets: make(map[int]map[string]struct{})}
+
+	// рынки восстановленных после рестарта матчей уже есть в топике, их тоже нужно удалить вместе с матчем
+	for _, m := range s.MatchList() {
+		for _, bet := range s.MatchBets(m.ID) {
+			if st.markets[m.ID] == nil {
+				st.markets[m.ID] = make(map[string]struct{})
+			}
+			st.markets[m.ID][bet.Key] = struct{}{}
+		}
+	}
+
+	return st
+}
+
+// Ensure создает топик с cleanup.policy=compact, если его еще нет
+func (st *StateTopic) Ensure() error {
+	admin, err := kafka.NewAdminClientFromProducer(st.sender.producer)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTopicTimeout)
+	defer cancel()
+
+	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{{
+		Topic:         st.topic,
+		NumPartitions: 1,
+		Config:        map[string]string{"cleanup.policy": "compact"},
+	}})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if res.Error.Code() != kafka.ErrNoError && res.Error.Code() != kafka.ErrTopicAlreadyExists {
+			return res.Error
+		}
+	}
+
+	return nil
+}
+
+// OnEvent реализует abstruct.EventListener. Из событий берутся только идентификаторы,
+// сами записи читаются из хранилища, так как update события содержат лишь изменения
+func (st *StateTopic) OnEvent(eventType int, data any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	switch items := data.(type) {
+	case []*parsed.Match:
+		for _, m := range items {
+			if m != nil {
+				st.publishMatch(m.ID)
+			}
+		}
+	case []*parsed.Straight:
+		for _, s := range items {
+			if s != nil {
+				st.publishBet(s.MatchupID, s.Key)
+			}
+		}
+	case []int:
+		if eventType == constants.MATCH_DELETE {
+			for _, id := range items {
+				st.deleteMatch(id)
+			}
+		}
+	}
+}
+
+func (st *StateTopic) publishMatch(id int) {
+	match, ok := st.store.Match(id)
+	if !ok {
+		// матч уже удален, tombstone отправит deleteMatch
+		return
+	}
+	st.publish(matchStateKey(id), match)
+}
+
+func (st *StateTopic) publishBet(matchID int, key string) {
+	bet, ok := st.store.Bet(matchID, key)
+	if !ok {
+		return
+	}
+	if st.markets[matchID] == nil {
+		st.markets[matchID] = make(map[string]struct{})
+	}
+	st.markets[matchID][key] = struct{}{}
+	st.publish(betStateKey(matchID, key), bet)
+}
+
+func (st *StateTopic) deleteMatch(id int) {
+	for key := range st.markets[id] {
+		st.sender.produce(context.Background(), nil, &st.topic, betStateKey(id, key), nil, nil)
+	}
+	delete(st.markets, id)
+	st.sender.produce(context.Background(), nil, &st.topic, matchStateKey(id), nil, nil)
+}
+
+func (st *StateTopic) publish(key []byte, v any) {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		st.logger.Error("Failed to marshal state record:", err)
+		return
+	}
+	st.sender.produce(context.Background(), data, &st.topic, key, nil, nil)
+}
+
+func matchStateKey(id int) []byte {
+	return []byte("match:" + strconv.Itoa(id))
+}
+
+func betStateKey(matchID int, key string) []byte {
+	return []byte("bet:" + strconv.Itoa(matchID) + ":" + key)
+}
@@ -28,7 +28,7 @@ type TestMode struct {
 
 func NewTestMode(l *logger.Logger, s abstruct.Sender) *TestMode {
 	return &TestMode{
-		logger:     l,
+		logger:     l.Named("testmode"),
 		sender:     s,
 		matches:    make(map[int]*parsed.Match),
 		bets:       make(map[int]map[string]*parsed.Straight), // Initialize bets map
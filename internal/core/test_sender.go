@@ -1,5 +1,7 @@
 package core
 
+import "time"
+
 type TestSender struct {
 	sender *SenderKafka
 }
@@ -11,6 +13,10 @@ func NewTestSender(kafka *SenderKafka) *TestSender {
 func (ts *TestSender) Send(m []byte, s *string) {
 	ts.sender.Send(m, s)
 }
+func (ts *TestSender) Drain(timeout time.Duration) int {
+	return ts.sender.Drain(timeout)
+}
+
 func (ts *TestSender) Start(s string) {
 	select {}
 }
@@ -0,0 +1,150 @@
+package grpcapi
+
+import (
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/stream"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/pb/parserv1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toFilter(f *parserv1.Filter) stream.Filter {
+	return stream.Filter{
+		SportIDs:    toSet(f.GetSportIds()),
+		LeagueIDs:   toSet(f.GetLeagueIds()),
+		MatchIDs:    toSet(f.GetMatchIds()),
+		MarketTypes: toStringSet(f.GetMarketTypes()),
+	}
+}
+
+func toSet(ids []int64) map[int]struct{} {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		set[int(id)] = struct{}{}
+	}
+	return set
+}
+
+func toStringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// toEvent переводит событие хаба в сообщение gRPC, eventType совпадает со значениями EventType.
+// В *_UPDATE optional-поля задаются, только если патч отмечает их изменение
+func toEvent(msg stream.Message) *parserv1.Event {
+	event := &parserv1.Event{Type: parserv1.EventType(msg.EventType)}
+	patch := msg.EventType == constants.MATCH_UPDATE || msg.EventType == constants.BET_UPDATE
+	switch data := msg.Data.(type) {
+	case []*parsed.Match:
+		event.Matches = toMatches(data, patch)
+	case []*parsed.Straight:
+		event.Markets = toMarkets(data, patch)
+	case []int:
+		event.DeletedMatchIds = make([]int64, len(data))
+		for i, id := range data {
+			event.DeletedMatchIds[i] = int64(id)
+		}
+	}
+	return event
+}
+
+func toMatches(matches []*parsed.Match, patch bool) []*parserv1.Match {
+	out := make([]*parserv1.Match, len(matches))
+	for i, m := range matches {
+		out[i] = toMatch(m, patch)
+	}
+	return out
+}
+
+func toMatch(m *parsed.Match, patch bool) *parserv1.Match {
+	match := &parserv1.Match{
+		Id:        int64(m.ID),
+		ParentId:  int64(m.ParentId),
+		BestOfX:   int64(m.BestOfX),
+		StartTime: toTimestamp(m.StartTime),
+		League:    toLeague(m.League),
+		UpdatedAt: toTimestamp(m.UpdatedAt),
+		Status:    m.Status,
+	}
+	if !patch || m.IsLiveSet {
+		match.IsLive = proto.Bool(m.IsLive)
+	}
+	for _, p := range m.Participants {
+		match.Participants = append(match.Participants, &parserv1.Participant{
+			Id:        int64(p.Id),
+			Alignment: p.Alignment,
+			Name:      p.Name,
+		})
+	}
+	return match
+}
+
+func toLeague(l *parsed.League) *parserv1.League {
+	if l == nil {
+		return nil
+	}
+	league := &parserv1.League{
+		Id:         int64(l.ID),
+		Name:       l.Name,
+		Group:      l.Group,
+		IsHidden:   l.IsHidden,
+		IsPromoted: l.IsPromoted,
+		IsSticky:   l.IsSticky,
+		Sequence:   int64(l.Sequence),
+	}
+	if l.Sport != nil {
+		league.Sport = &parserv1.Sport{Id: int64(l.Sport.ID), Name: l.Sport.Name}
+	}
+	return league
+}
+
+func toMarkets(bets []*parsed.Straight, patch bool) []*parserv1.Market {
+	out := make([]*parserv1.Market, len(bets))
+	for i, s := range bets {
+		market := &parserv1.Market{
+			Key:       s.Key,
+			MatchId:   int64(s.MatchupID),
+			Side:      s.Side,
+			Status:    s.Status,
+			Type:      s.Type,
+			UpdatedAt: toTimestamp(s.UpdatedAt),
+		}
+		if !patch || s.PeriodSet {
+			market.Period = proto.Int64(int64(s.Period))
+		}
+		for _, p := range s.Prices {
+			price := &parserv1.Price{
+				Designation:   p.Designation,
+				Price:         int64(p.Price),
+				ParticipantId: int64(p.ParticipantId),
+			}
+			if !patch || p.PointsSet {
+				price.Points = proto.Float64(p.Points)
+			}
+			market.Prices = append(market.Prices, price)
+		}
+		out[i] = market
+	}
+	return out
+}
+
+// toTimestamp нулевое время не передается, как и omitempty в JSON
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,92 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/stream"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"github.com/pararti/pinnacle-parser/pkg/pb/parserv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server gRPC API над состоянием парсера. Подписки обслуживает тот же stream.Hub, что и WebSocket/SSE
+type Server struct {
+	parserv1.UnimplementedParserServiceServer
+
+	logger *logger.Logger
+	store  abstruct.StateStore
+	hub    *stream.Hub
+	srv    *grpc.Server
+}
+
+func NewServer(l *logger.Logger, s abstruct.StateStore, hub *stream.Hub) *Server {
+	srv := &Server{logger: l.Named("grpcapi"), store: s, hub: hub, srv: grpc.NewServer()}
+	parserv1.RegisterParserServiceServer(srv.srv, srv)
+
+	return srv
+}
+
+// Start блокирующе слушает адрес, ошибка запуска завершает процесс
+func (s *Server) Start(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.logger.Fatal("gRPC server failed to listen:", err)
+	}
+	s.logger.Info("gRPC API listening on", addr)
+	if err := s.srv.Serve(lis); err != nil {
+		s.logger.Fatal("gRPC server failed:", err)
+	}
+}
+
+func (s *Server) Stop() {
+	s.srv.GracefulStop()
+}
+
+func (s *Server) GetSnapshot(_ context.Context, req *parserv1.GetSnapshotRequest) (*parserv1.GetSnapshotResponse, error) {
+	matches, bets := s.hub.Snapshot(toFilter(req.GetFilter()))
+
+	return &parserv1.GetSnapshotResponse{Matches: toMatches(matches, false), Markets: toMarkets(bets, false)}, nil
+}
+
+func (s *Server) GetMatch(_ context.Context, req *parserv1.GetMatchRequest) (*parserv1.Match, error) {
+	match, ok := s.store.Match(int(req.GetId()))
+	if !ok {
+		return nil, status.Error(codes.NotFound, "match not found")
+	}
+
+	return toMatch(match, false), nil
+}
+
+func (s *Server) ListMarkets(_ context.Context, req *parserv1.ListMarketsRequest) (*parserv1.ListMarketsResponse, error) {
+	return &parserv1.ListMarketsResponse{Markets: toMarkets(s.store.MatchBets(int(req.GetMatchId())), false)}, nil
+}
+
+func (s *Server) Subscribe(req *parserv1.SubscribeRequest, srv grpc.ServerStreamingServer[parserv1.Event]) error {
+	client, snapshot := s.hub.Subscribe(toFilter(req.GetFilter()))
+	defer s.hub.Unsubscribe(client)
+
+	if !req.GetSkipSnapshot() {
+		for _, msg := range snapshot {
+			if err := srv.Send(toEvent(msg)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case msg := <-client.Messages():
+			if err := srv.Send(toEvent(msg)); err != nil {
+				return err
+			}
+		case <-client.Done():
+			return status.Error(codes.ResourceExhausted, "subscriber is too slow")
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+}
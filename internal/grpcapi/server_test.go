@@ -0,0 +1,199 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/internal/stream"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"github.com/pararti/pinnacle-parser/pkg/pb/parserv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testMatch(id, sportID int) *parsed.Match {
+	return &parsed.Match{
+		ID:       id,
+		ParentId: id,
+		IsLive:   false,
+		League:   &parsed.League{ID: sportID * 10, Name: "League", Sport: &parsed.Sport{ID: sportID, Name: "Sport"}},
+	}
+}
+
+func testBet(matchID int) *parsed.Straight {
+	return &parsed.Straight{
+		Key:       "s;0;m",
+		MatchupID: matchID,
+		Period:    0,
+		Status:    parsed.BET_STATUS_OPEN,
+		Type:      "moneyline",
+		Prices: []*parsed.Price{
+			{Designation: "home", Price: -110, ParticipantId: 1},
+			{Designation: "away", Price: 105, Points: 1.5, ParticipantId: 2},
+		},
+	}
+}
+
+// newTestServer сервер на bufconn с матчами 1 (вид спорта 1) и 2 (вид спорта 2) и рынком матча 1
+func newTestServer(t *testing.T) (parserv1.ParserServiceClient, *stream.Hub) {
+	t.Helper()
+	ctx := context.Background()
+	store := storage.NewMapStorage(storage.DeleteGrace{})
+	store.SetMatches(ctx, []*parsed.Match{testMatch(1, 1), testMatch(2, 2)})
+	store.SetBets(ctx, map[int][]*parsed.Straight{1: {testBet(1)}})
+
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	hub := stream.NewHub(l, store)
+	srv := NewServer(l, store, hub)
+
+	lis := bufconn.Listen(1 << 20)
+	go srv.srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return parserv1.NewParserServiceClient(conn), hub
+}
+
+func TestGetMatch(t *testing.T) {
+	client, _ := newTestServer(t)
+	ctx := context.Background()
+
+	match, err := client.GetMatch(ctx, &parserv1.GetMatchRequest{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.GetId() != 1 || match.GetLeague().GetSport().GetId() != 1 {
+		t.Fatalf("match = %v", match)
+	}
+	// у полного матча optional-поля заданы и с нулевым значением
+	if match.IsLive == nil || match.GetIsLive() {
+		t.Fatalf("is_live = %v, want present false", match.IsLive)
+	}
+
+	_, err = client.GetMatch(ctx, &parserv1.GetMatchRequest{Id: 42})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("err = %v, want NotFound", err)
+	}
+}
+
+func TestListMarkets(t *testing.T) {
+	client, _ := newTestServer(t)
+	resp, err := client.ListMarkets(context.Background(), &parserv1.ListMarketsRequest{MatchId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetMarkets()) != 1 {
+		t.Fatalf("markets = %v", resp.GetMarkets())
+	}
+	market := resp.GetMarkets()[0]
+	if market.Period == nil || market.GetPeriod() != 0 {
+		t.Fatalf("period = %v, want present 0", market.Period)
+	}
+	if p := market.GetPrices()[0]; p.Points == nil || p.GetPoints() != 0 {
+		t.Fatalf("points = %v, want present 0", p.Points)
+	}
+}
+
+func TestGetSnapshotFilter(t *testing.T) {
+	client, _ := newTestServer(t)
+	resp, err := client.GetSnapshot(context.Background(), &parserv1.GetSnapshotRequest{
+		Filter: &parserv1.Filter{SportIds: []int64{2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetMatches()) != 1 || resp.GetMatches()[0].GetId() != 2 || len(resp.GetMarkets()) != 0 {
+		t.Fatalf("snapshot = %v", resp)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	client, hub := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := client.Subscribe(ctx, &parserv1.SubscribeRequest{Filter: &parserv1.Filter{MatchIds: []int64{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []parserv1.EventType{parserv1.EventType_EVENT_TYPE_MATCH_NEW, parserv1.EventType_EVENT_TYPE_BET_NEW} {
+		event, err := sub.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.GetType() != want {
+			t.Fatalf("snapshot event = %v, want %v", event.GetType(), want)
+		}
+	}
+
+	// событие другого матча отфильтровывается, патч матча 1 приходит
+	hub.OnEvent(constants.MATCH_UPDATE, []*parsed.Match{{ID: 2, IsLiveSet: true}})
+	hub.OnEvent(constants.MATCH_UPDATE, []*parsed.Match{{ID: 1, IsLiveSet: true}})
+	event, err := sub.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.GetType() != parserv1.EventType_EVENT_TYPE_MATCH_UPDATE || len(event.GetMatches()) != 1 {
+		t.Fatalf("event = %v", event)
+	}
+	if m := event.GetMatches()[0]; m.GetId() != 1 || m.IsLive == nil || m.GetIsLive() {
+		t.Fatalf("match patch = %v, want is_live present false", m)
+	}
+}
+
+// TestToEventPatchPresence в *_UPDATE нулевое значение передается, только если патч отмечает изменение
+func TestToEventPatchPresence(t *testing.T) {
+	bets := toEvent(stream.Message{EventType: constants.BET_UPDATE, Data: []*parsed.Straight{
+		{Key: "a", MatchupID: 1, Period: 0, PeriodSet: true, Prices: []*parsed.Price{
+			{Designation: "home", Points: 0, PointsSet: true},
+			{Designation: "away", Price: 120},
+		}},
+		{Key: "b", MatchupID: 1, Status: "closed"},
+	}})
+	changed, unchanged := bets.GetMarkets()[0], bets.GetMarkets()[1]
+	if changed.Period == nil || changed.GetPeriod() != 0 {
+		t.Fatalf("changed period = %v, want present 0", changed.Period)
+	}
+	if unchanged.Period != nil {
+		t.Fatalf("unchanged period = %v, want absent", *unchanged.Period)
+	}
+	if p := changed.GetPrices()[0]; p.Points == nil || p.GetPoints() != 0 {
+		t.Fatalf("changed points = %v, want present 0", p.Points)
+	}
+	if p := changed.GetPrices()[1]; p.Points != nil {
+		t.Fatalf("unchanged points = %v, want absent", *p.Points)
+	}
+
+	matches := toEvent(stream.Message{EventType: constants.MATCH_UPDATE, Data: []*parsed.Match{
+		{ID: 1, IsLiveSet: true},
+		{ID: 2, Status: parsed.MATCH_STATUS_SUSPENDED},
+	}})
+	if m := matches.GetMatches()[0]; m.IsLive == nil || m.GetIsLive() {
+		t.Fatalf("changed is_live = %v, want present false", m.IsLive)
+	}
+	if m := matches.GetMatches()[1]; m.IsLive != nil {
+		t.Fatalf("unchanged is_live = %v, want absent", *m.IsLive)
+	}
+
+	// в полных событиях поля заданы всегда
+	full := toEvent(stream.Message{EventType: constants.BET_NEW, Data: []*parsed.Straight{testBet(1)}})
+	if m := full.GetMarkets()[0]; m.Period == nil || m.GetPrices()[0].Points == nil {
+		t.Fatalf("BET_NEW market = %v, want period and points present", m)
+	}
+}
@@ -0,0 +1,45 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Freshness проверяет, что данные приходили не дольше maxAge назад. Нулевое время значит,
+// что данных еще не было
+func Freshness(what string, last time.Time, maxAge time.Duration) (string, error) {
+	if last.IsZero() {
+		return "", errors.New("no " + what + " received yet")
+	}
+	age := time.Since(last).Round(time.Second)
+	detail := fmt.Sprintf("last %s %s ago", what, age)
+	if maxAge > 0 && age > maxAge {
+		return detail, fmt.Errorf("%s are stale: %s > %s", what, age, maxAge)
+	}
+	return detail, nil
+}
+
+// metadataClient kafka.Producer или kafka.Consumer
+type metadataClient interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+// KafkaBrokers запрашивает метаданные кластера и сообщает, сколько брокеров доступно клиенту
+func KafkaBrokers(ctx context.Context, client metadataClient) (string, error) {
+	timeout := checkTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	md, err := client.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return "", err
+	}
+	if len(md.Brokers) == 0 {
+		return "", errors.New("no brokers available")
+	}
+	return fmt.Sprintf("%d brokers", len(md.Brokers)), nil
+}
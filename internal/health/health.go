@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// checkTimeout сколько ждать одну проверку, чтобы зависшая зависимость не держала пробу
+const checkTimeout = 3 * time.Second
+
+// Check проверяет один компонент. detail попадает в ответ как есть, ошибка означает, что
+// компонент неисправен
+type Check func(ctx context.Context) (detail string, err error)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker отдает /healthz и /readyz. Проверки liveness показывают, что процесс может
+// работать дальше, и входят в обе пробы; проверки readiness касаются внешних зависимостей
+// и свежести данных, при их отказе процесс не перезапускают, а только снимают с трафика
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Live добавляет проверку liveness
+func (c *Checker) Live(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+}
+
+// Ready добавляет проверку readiness
+func (c *Checker) Ready(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+// Register вешает пробы на mux
+func (c *Checker) Register(mux interface{ Handle(string, http.Handler) }) {
+	mux.Handle("GET /healthz", http.HandlerFunc(c.healthz))
+	mux.Handle("GET /readyz", http.HandlerFunc(c.readyz))
+}
+
+// Report ответ пробы
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type CheckResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+func (c *Checker) healthz(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.liveness...)
+	c.mu.RUnlock()
+	writeReport(w, run(r.Context(), checks))
+}
+
+func (c *Checker) readyz(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := append(append([]namedCheck(nil), c.liveness...), c.readiness...)
+	c.mu.RUnlock()
+	writeReport(w, run(r.Context(), checks))
+}
+
+// run выполняет проверки параллельно, каждую со своим таймаутом
+func run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: statusOK, Checks: make([]CheckResult, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			detail, err := nc.check(checkCtx)
+			res := CheckResult{Name: nc.name, Status: statusOK, Detail: detail,
+				Duration: time.Since(start).Round(time.Millisecond).String()}
+			if err != nil {
+				res.Status = statusFail
+				res.Error = err.Error()
+			}
+			report.Checks[i] = res
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Status != statusOK {
+			report.Status = statusFail
+			break
+		}
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	code := http.StatusOK
+	if report.Status != statusOK {
+		code = http.StatusServiceUnavailable
+	}
+	body, err := sonic.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
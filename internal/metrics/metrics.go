@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Метрики парсера
+var (
+	// CapturedBodies тела XHR ответов, перехваченные движком, по виду URL
+	CapturedBodies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "parser",
+		Name:      "captured_bodies_total",
+		Help:      "Response bodies captured from the browser by URL kind.",
+	}, []string{"kind"})
+
+	// UnmarshalErrors тела, которые не удалось разобрать в processMatches и processBets
+	UnmarshalErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "parser",
+		Name:      "unmarshal_errors_total",
+		Help:      "Captured bodies that failed to unmarshal by URL kind.",
+	}, []string{"kind"})
+
+	// DiffEvents отличия, отданные хранилищем отправителю, по типу события
+	DiffEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "parser",
+		Name:      "diff_events_total",
+		Help:      "Changes taken from the state store by event type.",
+	}, []string{"event"})
+
+	// KafkaProduceSeconds время от Produce до подтверждения доставки
+	KafkaProduceSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "parser",
+		Name:      "kafka_produce_seconds",
+		Help:      "Time from producing a message to its delivery report.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"topic"})
+
+	// KafkaProduceFailures сообщения, которые не удалось отправить: queue_full, produce или delivery
+	KafkaProduceFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "parser",
+		Name:      "kafka_produce_failures_total",
+		Help:      "Messages that failed to produce or were not delivered.",
+	}, []string{"topic", "reason"})
+)
+
+// Метрики консьюмера
+var (
+	// ConsumerLag сообщения партиции, которые консьюмер еще не прочитал
+	ConsumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "consumer",
+		Name:      "lag_messages",
+		Help:      "Messages between the high watermark and the last consumed offset.",
+	}, []string{"topic", "partition"})
+
+	// HandlerSeconds время обработки сообщения обработчиком типа события
+	HandlerSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "consumer",
+		Name:      "handler_seconds",
+		Help:      "Message processing time by event type and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"event", "result"})
+
+	// DBQuerySeconds время запросов PostgresDBClient по виду запроса
+	DBQuerySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "consumer",
+		Name:      "db_query_seconds",
+		Help:      "PostgreSQL query latency by statement kind and result.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"statement", "result"})
+)
+
+// RegisterParser регистрирует метрики парсера и дополнительные коллекторы, например состояния
+func RegisterParser(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(CapturedBodies, UnmarshalErrors, DiffEvents, KafkaProduceSeconds, KafkaProduceFailures)
+	prometheus.MustRegister(collectors...)
+}
+
+func RegisterConsumer() {
+	prometheus.MustRegister(ConsumerLag, HandlerSeconds, DBQuerySeconds)
+}
+
+// Handler отдает зарегистрированные метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Result значение метки result
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// EventName название типа события для меток
+func EventName(eventType int) string {
+	switch eventType {
+	case constants.MATCH_NEW:
+		return "match_new"
+	case constants.MATCH_UPDATE:
+		return "match_update"
+	case constants.MATCH_DELETE:
+		return "match_delete"
+	case constants.BET_NEW:
+		return "bet_new"
+	case constants.BET_UPDATE:
+		return "bet_update"
+	case constants.MATCH_SNAPSHOT:
+		return "match_snapshot"
+	case constants.BET_SNAPSHOT:
+		return "bet_snapshot"
+	case constants.MATCH_RESTORED:
+		return "match_restored"
+	default:
+		return strconv.Itoa(eventType)
+	}
+}
+
+// ObserveDiff учитывает n отличий типа eventType
+func ObserveDiff(eventType int, n int) {
+	if n > 0 {
+		DiffEvents.WithLabelValues(EventName(eventType)).Add(float64(n))
+	}
+}
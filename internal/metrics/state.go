@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stateMatchesDesc = prometheus.NewDesc("parser_state_matches", "Matches in the parser state.", nil, nil)
+	stateMarketsDesc = prometheus.NewDesc("parser_state_markets", "Markets in the parser state.", nil, nil)
+	stateMissingDesc = prometheus.NewDesc("parser_state_missing_matches",
+		"Matches missing from the source and waiting for the delete grace period.", nil, nil)
+	backlogDesc = prometheus.NewDesc("parser_backlog_changes",
+		"Changes detected by the state store and not yet taken by the sender.", []string{"event"}, nil)
+	backlogAgeDesc = prometheus.NewDesc("parser_backlog_oldest_seconds",
+		"Age of the oldest change not yet taken by the sender.", nil, nil)
+	coalescedDesc = prometheus.NewDesc("parser_notifications_coalesced_total",
+		"Wake-ups merged into a still pending one.", nil, nil)
+)
+
+// StateCollector снимает метрики со Stats хранилища при каждом запросе /metrics
+type StateCollector struct {
+	store abstruct.StateStore
+}
+
+func NewStateCollector(s abstruct.StateStore) *StateCollector {
+	return &StateCollector{store: s}
+}
+
+func (c *StateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateMatchesDesc
+	ch <- stateMarketsDesc
+	ch <- stateMissingDesc
+	ch <- backlogDesc
+	ch <- backlogAgeDesc
+	ch <- coalescedDesc
+}
+
+func (c *StateCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.store.Stats()
+	ch <- prometheus.MustNewConstMetric(stateMatchesDesc, prometheus.GaugeValue, float64(stats.Matches))
+	ch <- prometheus.MustNewConstMetric(stateMarketsDesc, prometheus.GaugeValue, float64(stats.Markets))
+	ch <- prometheus.MustNewConstMetric(stateMissingDesc, prometheus.GaugeValue, float64(stats.Missing))
+
+	b := stats.Backlog
+	for event, n := range map[string]int{
+		"match_new":      b.NewMatches,
+		"match_update":   b.UpdatedMatches,
+		"match_delete":   b.DeletedMatches,
+		"match_restored": b.RestoredMatches,
+		"bet_new":        b.NewBets,
+		"bet_update":     b.UpdatedBets,
+	} {
+		ch <- prometheus.MustNewConstMetric(backlogDesc, prometheus.GaugeValue, float64(n), event)
+	}
+
+	age := 0.0
+	if !b.OldestAt.IsZero() {
+		age = time.Since(b.OldestAt).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(backlogAgeDesc, prometheus.GaugeValue, age)
+	ch <- prometheus.MustNewConstMetric(coalescedDesc, prometheus.CounterValue, float64(b.Coalesced))
+}
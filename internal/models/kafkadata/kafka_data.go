@@ -1,6 +1,8 @@
 package kafkadata
 
 import (
+	"time"
+
 	"github.com/pararti/pinnacle-parser/internal/models/parsed"
 )
 
@@ -33,3 +35,22 @@ type DeletedMatch struct {
 	Source    string `json:"source"`
 	Data      []int  `json:"data"`
 }
+
+// Event общий конверт сообщения с типизированными данными
+type Event[T any] struct {
+	EventType int    `json:"eventType"`
+	Source    string `json:"source"`
+	Data      []T    `json:"data"`
+}
+
+// Snapshot часть периодического снимка полного состояния. Снимок состоит из Chunks частей
+// с одинаковым SnapshotID, Chunk нумеруется с нуля
+type Snapshot[T any] struct {
+	EventType  int       `json:"eventType"`
+	Source     string    `json:"source"`
+	SnapshotID string    `json:"snapshotId"`
+	TakenAt    time.Time `json:"takenAt"`
+	Chunk      int       `json:"chunk"`
+	Chunks     int       `json:"chunks"`
+	Data       []T       `json:"data"`
+}
@@ -0,0 +1,68 @@
+package parsed
+
+// Clone методы возвращают глубокие копии без отметок об изменениях,
+// чтобы данные хранилища можно было читать вне его блокировки
+
+func (s *Sport) Clone() *Sport {
+	if s == nil {
+		return nil
+	}
+	return &Sport{ID: s.ID, Name: s.Name}
+}
+
+func (l *League) Clone() *League {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.Sport = l.Sport.Clone()
+	c.Changes = nil
+	return &c
+}
+
+func (p *Participant) Clone() *Participant {
+	if p == nil {
+		return nil
+	}
+	return &Participant{Id: p.Id, Alignment: p.Alignment, Name: p.Name}
+}
+
+func (m *Match) Clone() *Match {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.League = m.League.Clone()
+	c.Changes = nil
+	if m.Participants != nil {
+		c.Participants = make([]*Participant, len(m.Participants))
+		for i, p := range m.Participants {
+			c.Participants[i] = p.Clone()
+		}
+	}
+	return &c
+}
+
+func (p *Price) Clone() *Price {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Changes = nil
+	return &c
+}
+
+func (s *Straight) Clone() *Straight {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Changes = nil
+	if s.Prices != nil {
+		c.Prices = make([]*Price, len(s.Prices))
+		for i, p := range s.Prices {
+			c.Prices[i] = p.Clone()
+		}
+	}
+	return &c
+}
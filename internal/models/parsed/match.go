@@ -200,15 +200,19 @@ func (p *Participant) CreatePatch() *Participant {
 }
 
 type Match struct {
-	BestOfX      int             `json:"bestOfX,omitempty"`
-	ID           int             `json:"id,omitempty"`
-	IsLive       bool            `json:"isLive,omitempty"`
-	League       *League         `json:"league,omitempty"`
-	Participants []*Participant  `json:"participants,omitempty"`
-	StartTime    time.Time       `json:"startTime,omitempty"`
-	ParentId     int             `json:"parentId,omitempty"`
-	StatusFlag   int8            `json:"-"`
-	Changes      map[string]bool `json:"-"`
+	BestOfX      int            `json:"bestOfX,omitempty"`
+	ID           int            `json:"id,omitempty"`
+	IsLive       bool           `json:"isLive,omitempty"`
+	League       *League        `json:"league,omitempty"`
+	Participants []*Participant `json:"participants,omitempty"`
+	StartTime    time.Time      `json:"startTime,omitempty"`
+	ParentId     int            `json:"parentId,omitempty"`
+	Status       string         `json:"status,omitempty"`
+	// IsLiveSet в патче отмечает, что isLive изменился: false omitempty не передает
+	IsLiveSet  bool            `json:"isLiveSet,omitempty"`
+	StatusFlag int8            `json:"-"`
+	Changes    map[string]bool `json:"-"`
+	UpdatedAt  time.Time       `json:"-"`
 }
 
 func (m *Match) MarkChanged(field string) {
@@ -262,8 +266,11 @@ func (m *Match) CreatePatch() *Match {
 				patch.BestOfX = m.BestOfX
 			case "isLive":
 				patch.IsLive = m.IsLive
+				patch.IsLiveSet = true
 			case "startTime":
 				patch.StartTime = m.StartTime
+			case "status":
+				patch.Status = m.Status
 			case "league":
 				// When league changes, include full hierarchy with Sport
 				if m.League != nil {
@@ -0,0 +1,31 @@
+package parsed
+
+import (
+	"testing"
+
+	"github.com/bytedance/sonic"
+)
+
+// TestMatchPatchIsLiveFalse isLive=false omitempty не передает, патч отмечает изменение isLiveSet
+func TestMatchPatchIsLiveFalse(t *testing.T) {
+	m := &Match{ID: 1, IsLive: false}
+	m.MarkChanged("isLive")
+
+	payload, err := sonic.Marshal(m.CreatePatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch Match
+	if err := sonic.Unmarshal(payload, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if !patch.IsLiveSet || patch.IsLive {
+		t.Fatalf("patch %s, want isLiveSet with isLive false", payload)
+	}
+
+	m = &Match{ID: 1, Status: MATCH_STATUS_ACTIVE}
+	m.MarkChanged("status")
+	if patch := m.CreatePatch(); patch.IsLiveSet {
+		t.Fatal("unchanged isLive is marked as set")
+	}
+}
@@ -6,3 +6,20 @@ const (
 	STATUS_UPDATED
 	STATUS_DELETED
 )
+
+// Статусы матча: в состоянии парсера бывают active, suspended и missing, settled и removed
+// консьюмер выставляет в matches.status при удалении матча
+const (
+	MATCH_STATUS_ACTIVE = "active"
+	// MATCH_STATUS_SUSPENDED у матча есть рынки, но ни один не открыт
+	MATCH_STATUS_SUSPENDED = "suspended"
+	// MATCH_STATUS_MISSING матча нет в последних данных своей группы, он ждет deleteGrace
+	MATCH_STATUS_MISSING = "missing"
+	// MATCH_STATUS_SETTLED матч пропал после начала
+	MATCH_STATUS_SETTLED = "settled"
+	// MATCH_STATUS_REMOVED матч пропал до начала
+	MATCH_STATUS_REMOVED = "removed"
+)
+
+// BET_STATUS_OPEN статус открытого рынка в данных источника
+const BET_STATUS_OPEN = "open"
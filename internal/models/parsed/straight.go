@@ -3,14 +3,17 @@ package parsed
 import (
 	"fmt"
 	"math/rand"
+	"time"
 )
 
 type Price struct {
-	Designation   string          `json:"designation,omitempty"`
-	Price         int             `json:"price,omitempty"`
-	Points        float64         `json:"points,omitempty"`
-	ParticipantId int             `json:"participantId,omitempty"`
-	Changes       map[string]bool `json:"-"`
+	Designation   string  `json:"designation,omitempty"`
+	Price         int     `json:"price,omitempty"`
+	Points        float64 `json:"points,omitempty"`
+	ParticipantId int     `json:"participantId,omitempty"`
+	// PointsSet в патче отмечает, что points изменились: 0 — допустимая фора, а omitempty его не передает
+	PointsSet bool            `json:"pointsSet,omitempty"`
+	Changes   map[string]bool `json:"-"`
 }
 
 func (p *Price) MarkChanged(field string) {
@@ -21,15 +24,20 @@ func (p *Price) MarkChanged(field string) {
 }
 
 type Straight struct {
-	Key        string          `json:"key,omitempty"`
-	MatchupID  int             `json:"matchupId,omitempty"`
-	Period     int             `json:"period,omitempty"`
-	Prices     []*Price        `json:"prices,omitempty"`
-	Side       string          `json:"side,omitempty"`
-	Status     string          `json:"status,omitempty"`
-	Type       string          `json:"type,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	MatchupID int      `json:"matchupId,omitempty"`
+	Period    int      `json:"period,omitempty"`
+	Prices    []*Price `json:"prices,omitempty"`
+	Side      string   `json:"side,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	// PeriodSet в патче отмечает, что period изменился: 0 — весь матч, а omitempty его не передает
+	PeriodSet  bool            `json:"periodSet,omitempty"`
 	StatusFlag int8            `json:"-"`
 	Changes    map[string]bool `json:"-"`
+	UpdatedAt  time.Time       `json:"-"`
+	// CapturedAt когда браузер получил ответ с текущими значениями рынка, по часам Chrome
+	CapturedAt time.Time `json:"capturedAt"`
 }
 
 func (s *Straight) MarkChanged(field string) {
@@ -47,9 +55,11 @@ func (s *Straight) GetUpdate() *Straight {
 	upd.MatchupID = s.MatchupID
 	upd.Key = s.Key
 	upd.Type = s.Type
+	upd.CapturedAt = s.CapturedAt
 	for field := range s.Changes {
 		if field == "period" {
 			upd.Period = s.Period
+			upd.PeriodSet = true
 			continue
 		}
 		if field == "prices" {
@@ -72,6 +82,7 @@ func (s *Straight) GetUpdate() *Straight {
 					}
 					if field == "points" {
 						price.Points = p.Points
+						price.PointsSet = true
 						continue
 					}
 				}
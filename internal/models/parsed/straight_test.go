@@ -0,0 +1,57 @@
+package parsed
+
+import (
+	"testing"
+
+	"github.com/bytedance/sonic"
+)
+
+// TestStraightUpdateZeroValues period 0 и points 0 — допустимые значения, в патче они должны
+// отличаться от отсутствующих полей, хотя omitempty их не передает
+func TestStraightUpdateZeroValues(t *testing.T) {
+	stored := &Straight{
+		Key:       "s;1;s;-1.5",
+		MatchupID: 10,
+		Period:    0,
+		Prices: []*Price{
+			{Designation: "home", Price: -110, Points: 0},
+			{Designation: "away", Price: 105, Points: 0},
+		},
+	}
+	stored.MarkChanged("period")
+	stored.MarkChanged("prices")
+	stored.Prices[0].MarkChanged("points")
+	stored.Prices[1].MarkChanged("price")
+
+	payload, err := sonic.Marshal(stored.GetUpdate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch Straight
+	if err := sonic.Unmarshal(payload, &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	if !patch.PeriodSet || patch.Period != 0 {
+		t.Errorf("period = %d, set = %v, want 0 set in %s", patch.Period, patch.PeriodSet, payload)
+	}
+	if len(patch.Prices) != 2 {
+		t.Fatalf("prices = %d, want 2 in %s", len(patch.Prices), payload)
+	}
+	if !patch.Prices[0].PointsSet || patch.Prices[0].Points != 0 {
+		t.Errorf("home points = %g, set = %v, want 0 set", patch.Prices[0].Points, patch.Prices[0].PointsSet)
+	}
+	if patch.Prices[1].PointsSet {
+		t.Errorf("away points are marked as set, but only the price changed")
+	}
+}
+
+func TestStraightUpdateWithoutPeriod(t *testing.T) {
+	stored := &Straight{Key: "s;1;m", MatchupID: 10, Period: 1, Status: "open"}
+	stored.MarkChanged("status")
+
+	upd := stored.GetUpdate()
+	if upd.PeriodSet || upd.Period != 0 {
+		t.Fatalf("unchanged period is sent: %d, set = %v", upd.Period, upd.PeriodSet)
+	}
+}
@@ -0,0 +1,246 @@
+package options
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-yaml/yaml"
+)
+
+// EnvPrefix префикс переменных окружения: kafkaTopic задается PINNACLE_KAFKA_TOPIC
+const EnvPrefix = "PINNACLE_"
+
+// defaultConfigPaths где искать файл конфигурации, если он не задан: при go run из cmd/
+// или cmd/<сервис>/, из корня репозитория
+var defaultConfigPaths = []string{"../config/settings.yaml", "../../config/settings.yaml", "config/settings.yaml"}
+
+// NewOptions собирает настройки слоями, каждый следующий переопределяет предыдущий:
+// значения по умолчанию, файлы конфигурации, переменные окружения PINNACLE_* и флаги.
+// После этого ссылки на секреты заменяются значениями.
+// Файлы задаются флагом --config (можно несколько раз) или PINNACLE_CONFIG через запятую.
+// Возвращает аргументы, оставшиеся после флагов, например подкоманду
+func NewOptions(args []string) (*Options, []string, error) {
+	o := Options{sources: make(map[string]string)}
+	o.fillDefaultValues()
+
+	fs, flags := o.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	paths := flags.configs
+	if len(paths) == 0 {
+		paths = splitList(os.Getenv(EnvPrefix + "CONFIG"))
+	}
+	if len(paths) == 0 {
+		for _, path := range defaultConfigPaths {
+			if _, err := os.Stat(path); err == nil {
+				paths = []string{path}
+				break
+			}
+		}
+	}
+	for _, path := range paths {
+		if err := o.loadFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	o.files = paths
+
+	if err := o.loadEnv(); err != nil {
+		return nil, nil, err
+	}
+	for _, f := range flags.values {
+		if err := setField(o.field(f.name), f.raw); err != nil {
+			return nil, nil, fmt.Errorf("флаг --%s: %w", f.name, err)
+		}
+		o.sources[f.name] = "flag --" + f.name
+	}
+
+	if err := o.resolveSecrets(); err != nil {
+		return nil, nil, err
+	}
+	return &o, fs.Args(), nil
+}
+
+func (o *Options) loadFile(path string) error {
+	yamlData, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New("Не удалось загрузить файл конфигурации " + err.Error())
+	}
+	if err := yaml.Unmarshal(yamlData, o); err != nil {
+		return errors.New("Не удалось выгрузить файл конфигурации " + path + " в структуру " + err.Error())
+	}
+
+	var keys map[string]any
+	_ = yaml.Unmarshal(yamlData, &keys)
+	for key := range keys {
+		o.sources[key] = "file " + path
+	}
+	return nil
+}
+
+func (o *Options) loadEnv() error {
+	for _, name := range fieldNames() {
+		env := EnvName(name)
+		raw, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := setField(o.field(name), raw); err != nil {
+			return fmt.Errorf("%s: %w", env, err)
+		}
+		o.sources[name] = "env " + env
+	}
+	return nil
+}
+
+// EnvName имя переменной окружения для поля: remoteChromeURL -> PINNACLE_REMOTE_CHROME_URL
+func EnvName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return EnvPrefix + b.String()
+}
+
+// Source откуда взято значение поля: default, file, env или flag
+func (o *Options) Source(name string) string {
+	if src, ok := o.sources[name]; ok {
+		return src
+	}
+	return "default"
+}
+
+type flagValue struct {
+	name string
+	raw  string
+}
+
+type parsedFlags struct {
+	configs []string
+	values  []flagValue
+}
+
+// flagSet создает флаг на каждое поле с тем же именем, что и в yaml. Значения применяются
+// после файлов и окружения, поэтому здесь только запоминаются
+func (o *Options) flagSet() (*flag.FlagSet, *parsedFlags) {
+	parsed := &parsedFlags{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.Func("config", "файл конфигурации, можно указать несколько раз", func(path string) error {
+		parsed.configs = append(parsed.configs, path)
+		return nil
+	})
+	for _, name := range fieldNames() {
+		fs.Var(&recordedFlag{name: name, parsed: parsed, isBool: o.field(name).Kind() == reflect.Bool},
+			name, "то же, что "+EnvName(name))
+	}
+	return fs, parsed
+}
+
+type recordedFlag struct {
+	name   string
+	parsed *parsedFlags
+	isBool bool
+}
+
+func (f *recordedFlag) String() string { return "" }
+
+func (f *recordedFlag) Set(raw string) error {
+	f.parsed.values = append(f.parsed.values, flagValue{name: f.name, raw: raw})
+	return nil
+}
+
+func (f *recordedFlag) IsBoolFlag() bool { return f.isBool }
+
+var optionsType = reflect.TypeOf(Options{})
+
+// fieldNames имена полей Options в порядке объявления, как в yaml
+func fieldNames() []string {
+	names := make([]string, 0, optionsType.NumField())
+	for i := 0; i < optionsType.NumField(); i++ {
+		if name := yamlName(optionsType.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if !f.IsExported() || name == "-" {
+		return ""
+	}
+	return name
+}
+
+// fieldSpec описание поля по имени из yaml
+func fieldSpec(name string) (reflect.StructField, bool) {
+	for i := 0; i < optionsType.NumField(); i++ {
+		if f := optionsType.Field(i); yamlName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func (o *Options) field(name string) reflect.Value {
+	f, _ := fieldSpec(name)
+	return reflect.ValueOf(o).Elem().FieldByIndex(f.Index)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField записывает строковое значение из окружения или флага в поле. Списки задаются
+// через запятую, словари как key=value через запятую, остальное разбирается как в yaml
+func setField(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(raw)))
+	case v.Kind() == reflect.Map:
+		m := make(map[string]string)
+		for _, pair := range splitList(raw) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("ожидается key=value, получено %q", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return yaml.Unmarshal([]byte(raw), v.Addr().Interface())
+	}
+	return nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
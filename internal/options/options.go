@@ -1,53 +1,181 @@
 package options
 
 import (
-	"errors"
-	"os"
-
-	"github.com/go-yaml/yaml"
+	"time"
 
 	"github.com/pararti/pinnacle-parser/pkg/defaults"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
 )
 
 const site = "https://www.pinnacle.com"
 
+// Options настройки сервисов. Имя поля в yaml служит и именем флага, и основой переменной
+// окружения (см. EnvName). Поля с тегом secret можно задавать ссылками file:, env: и vault:
+// (см. secrets.go), их значения не выводятся в config print. Поля с тегом reload применяются
+// без перезапуска (см. Reloader)
 type Options struct {
-	CookieDir       string `yaml:"cookieDir,omitempty"`
-	Site            string `yaml:"site,omitempty"`
-	UserAgent       string `yaml:"userAgent,omitempty"`
+	CookieDir string `yaml:"cookieDir,omitempty"`
+	Site      string `yaml:"site,omitempty" reload:"true"`
+	// MatchRoutes и BetRoutes окончания адресов ответов, которые перехватываются как матчи и как ставки
+	MatchRoutes []string `yaml:"matchRoutes,omitempty" reload:"true"`
+	BetRoutes   []string `yaml:"betRoutes,omitempty" reload:"true"`
+	UserAgent   string   `yaml:"userAgent,omitempty"`
+	// LogPath файл логов, который пишется вместе с stdout и ротируется по logMaxSizeMB,
+	// logMaxBackups и logMaxAgeDays
 	LogPath         string `yaml:"logPath,omitempty"`
 	TestMode        bool   `yaml:"testMode,omitempty"`
 	KafkaAddress    string `yaml:"kafkaAddress,omitempty"`
 	KafkaPort       string `yaml:"kafkaPort,omitempty"`
 	KafkaTopic      string `yaml:"kafkaTopic,omitempty"`
 	Login           string `yaml:"login,omitempty"`
-	Password        string `yaml:"password,omitempty"`
-	DbConnection    string `yaml:"dbConnection,omitempty"`
-	ProducerSentry  string `yaml:"producerSentry,omitempty"`
-	ConsumerSentry  string `yaml:"consumerSentry,omitempty"`
-	RemoteChromeURL string `yaml:"remoteChromeURL,omitempty"`
-}
-
-func NewOptions() (*Options, error) {
-	o := Options{}
-	o.fillDefaultValues()
-
-	yamlData, err := os.ReadFile("../config/settings.yaml")
-	if err != nil {
-		return nil, errors.New("Не удалось загрузить файл конфигурации " + err.Error())
-	} else {
-		err = yaml.Unmarshal(yamlData, &o)
-		if err != nil {
-			return nil, errors.New("Не удалось выгрузить файл конфигурации в структуру " + err.Error())
-		}
-	}
+	Password        string `yaml:"password,omitempty" secret:"true"`
+	DbConnection    string `yaml:"dbConnection,omitempty" secret:"url"`
+	ProducerSentry  string `yaml:"producerSentry,omitempty" secret:"url"`
+	ConsumerSentry  string `yaml:"consumerSentry,omitempty" secret:"url"`
+	RemoteChromeURL string `yaml:"remoteChromeURL,omitempty" secret:"url"`
+	DlqTopic        string `yaml:"dlqTopic,omitempty"`
+	StateTopic      string `yaml:"stateTopic,omitempty"`
+	ConsumerRetries int    `yaml:"consumerRetries,omitempty"`
+	AutoMigrate     bool   `yaml:"autoMigrate,omitempty"`
+	ApiAddress      string `yaml:"apiAddress,omitempty"`
+	HttpAddress     string `yaml:"httpAddress,omitempty"`
+	GrpcAddress     string `yaml:"grpcAddress,omitempty"`
+	// ProcessedRetention сколько консьюмер хранит отметки об обработанных сообщениях, должно быть
+	// не меньше retention kafkaTopic; 0 отключает очистку
+	ProcessedRetention time.Duration `yaml:"processedRetention,omitempty"`
+	// MetricsAddress адрес /metrics, /healthz и /readyz консьюмера, у парсера они на httpAddress
+	MetricsAddress string `yaml:"metricsAddress,omitempty"`
+	// SnapshotInterval период отправки полного состояния, 0 отключает снимки
+	SnapshotInterval  time.Duration `yaml:"snapshotInterval,omitempty"`
+	SnapshotChunkSize int           `yaml:"snapshotChunkSize,omitempty"`
+	// StateFile файл, в который сохраняется состояние парсера для восстановления после рестарта,
+	// пустое значение отключает сохранение
+	StateFile          string        `yaml:"stateFile,omitempty"`
+	CheckpointInterval time.Duration `yaml:"checkpointInterval,omitempty"`
+	// RestoreGrace сколько ждать восстановленные матчи от источника, прежде чем удалить их
+	RestoreGrace time.Duration `yaml:"restoreGrace,omitempty"`
+	// DeleteGraceMisses и DeleteGrace сколько пакетов группы подряд или сколько времени матч может
+	// отсутствовать, прежде чем уйдет MATCH_DELETE, срабатывает первое; до этого у него статус missing
+	DeleteGraceMisses int           `yaml:"deleteGraceMisses,omitempty"`
+	DeleteGrace       time.Duration `yaml:"deleteGrace,omitempty"`
+	// StateBackend хранилище состояния парсера: memory или redis. В redis состояние общее
+	// для нескольких экземпляров, stateFile при этом не используется
+	StateBackend  string `yaml:"stateBackend,omitempty"`
+	RedisAddress  string `yaml:"redisAddress,omitempty"`
+	RedisPassword string `yaml:"redisPassword,omitempty" secret:"true"`
+	RedisDB       int    `yaml:"redisDB,omitempty"`
+	RedisPrefix   string `yaml:"redisPrefix,omitempty"`
+	// HealthMaxDataAge через сколько без данных источника /readyz парсера перестает отвечать 200
+	HealthMaxDataAge time.Duration `yaml:"healthMaxDataAge,omitempty"`
+	// OtelEndpoint host:port OTLP/gRPC коллектора трасс, пустое значение отключает трассировку.
+	// OtelSampleRatio доля записываемых трасс от 0 до 1
+	OtelEndpoint    string  `yaml:"otelEndpoint,omitempty"`
+	OtelSampleRatio float64 `yaml:"otelSampleRatio,omitempty" reload:"true"`
+	// LogLevel уровень логов по умолчанию, LogLevels уровни отдельных пакетов, например consumer: debug.
+	// LogFormat text или json
+	LogLevel      string            `yaml:"logLevel,omitempty" reload:"true"`
+	LogLevels     map[string]string `yaml:"logLevels,omitempty" reload:"true"`
+	LogFormat     string            `yaml:"logFormat,omitempty" reload:"true"`
+	LogMaxSizeMB  int               `yaml:"logMaxSizeMB,omitempty"`
+	LogMaxBackups int               `yaml:"logMaxBackups,omitempty"`
+	LogMaxAgeDays int               `yaml:"logMaxAgeDays,omitempty"`
+	// Оповещения об ошибках в логе и срабатывании правил. Каналы включаются заполненными полями:
+	// telegram по alertTelegramToken и alertTelegramChatID, webhook по alertWebhookURL,
+	// почта по alertSMTPAddress и alertEmailTo
+	AlertTelegramURL    string   `yaml:"alertTelegramURL,omitempty" reload:"true"`
+	AlertTelegramToken  string   `yaml:"alertTelegramToken,omitempty" secret:"true" reload:"true"`
+	AlertTelegramChatID string   `yaml:"alertTelegramChatID,omitempty" reload:"true"`
+	AlertWebhookURL     string   `yaml:"alertWebhookURL,omitempty" secret:"url" reload:"true"`
+	AlertSMTPAddress    string   `yaml:"alertSMTPAddress,omitempty" reload:"true"`
+	AlertSMTPUser       string   `yaml:"alertSMTPUser,omitempty" reload:"true"`
+	AlertSMTPPassword   string   `yaml:"alertSMTPPassword,omitempty" secret:"true" reload:"true"`
+	AlertEmailFrom      string   `yaml:"alertEmailFrom,omitempty" reload:"true"`
+	AlertEmailTo        []string `yaml:"alertEmailTo,omitempty" reload:"true"`
+	// AlertDedupWindow сколько не повторять одинаковое оповещение, AlertRatePerMinute сколько
+	// оповещений отправляется в минуту, остальные подавляются
+	AlertDedupWindow   time.Duration `yaml:"alertDedupWindow,omitempty" reload:"true"`
+	AlertRatePerMinute int           `yaml:"alertRatePerMinute,omitempty" reload:"true"`
+	// AlertCheckInterval период проверки правил. AlertBetsStale через сколько без ставок от источника
+	// срабатывает правило парсера, AlertErrorRate доля ошибок обработки для правила консьюмера;
+	// нулевые значения отключают правила
+	AlertCheckInterval time.Duration `yaml:"alertCheckInterval,omitempty"`
+	AlertBetsStale     time.Duration `yaml:"alertBetsStale,omitempty" reload:"true"`
+	AlertErrorRate     float64       `yaml:"alertErrorRate,omitempty" reload:"true"`
+	// VaultAddress адрес Vault для ссылок vault:, VaultToken токен доступа (можно ссылкой file: или env:)
+	VaultAddress string `yaml:"vaultAddress,omitempty"`
+	VaultToken   string `yaml:"vaultToken,omitempty" secret:"true"`
 
-	return &o, nil
+	// sources откуда взято значение каждого поля, secretRefs ссылки, из которых получены
+	// секреты; нужны для config print
+	sources    map[string]string
+	secretRefs map[string]string
+	// files загруженные файлы конфигурации, за ними следит Reloader
+	files []string
 }
 
 func (o *Options) fillDefaultValues() {
 	o.UserAgent = defaults.UserAgent
 	o.Site = site
+	o.MatchRoutes = []string{"related"}
+	o.BetRoutes = []string{"straight"}
 	o.KafkaAddress = "localhost"
 	o.KafkaPort = "9092"
+	o.ConsumerRetries = 3
+	o.ProcessedRetention = 7 * 24 * time.Hour
+	o.AutoMigrate = true
+	o.ApiAddress = ":8081"
+	o.HttpAddress = ":8090"
+	o.GrpcAddress = ":9090"
+	o.MetricsAddress = ":9100"
+	o.SnapshotInterval = 5 * time.Minute
+	o.SnapshotChunkSize = 500
+	o.CheckpointInterval = 30 * time.Second
+	o.RestoreGrace = 10 * time.Minute
+	o.DeleteGraceMisses = 3
+	o.DeleteGrace = 30 * time.Second
+	o.StateBackend = "memory"
+	o.RedisAddress = "localhost:6379"
+	o.RedisPrefix = "pinnacle"
+	o.HealthMaxDataAge = 2 * time.Minute
+	o.OtelSampleRatio = 1
+	o.LogLevel = "info"
+	o.LogFormat = "text"
+	o.LogMaxSizeMB = 100
+	o.LogMaxBackups = 5
+	o.LogMaxAgeDays = 14
+	o.AlertTelegramURL = "https://api.telegram.org"
+	o.AlertDedupWindow = 10 * time.Minute
+	o.AlertRatePerMinute = 10
+	o.AlertCheckInterval = time.Minute
+	o.AlertBetsStale = 5 * time.Minute
+	o.AlertErrorRate = 0.1
+}
+
+// LoggerConfig возвращает настройки логов
+func (o *Options) LoggerConfig() logger.Config {
+	return logger.Config{
+		Level:      o.LogLevel,
+		Levels:     o.LogLevels,
+		Format:     o.LogFormat,
+		File:       o.LogPath,
+		MaxSizeMB:  o.LogMaxSizeMB,
+		MaxBackups: o.LogMaxBackups,
+		MaxAgeDays: o.LogMaxAgeDays,
+	}
+}
+
+// DeadLetterTopic возвращает топик для необработанных сообщений, по умолчанию <kafkaTopic>.dlq
+func (o *Options) DeadLetterTopic() string {
+	if o.DlqTopic != "" {
+		return o.DlqTopic
+	}
+	return o.KafkaTopic + ".dlq"
+}
+
+// StateTopicName возвращает compacted топик с полным состоянием, по умолчанию <kafkaTopic>.state
+func (o *Options) StateTopicName() string {
+	if o.StateTopic != "" {
+		return o.StateTopic
+	}
+	return o.KafkaTopic + ".state"
 }
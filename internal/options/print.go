@@ -0,0 +1,94 @@
+package options
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const redacted = "******"
+
+// Print выводит действующие значения всех полей и их источник. Значения полей с тегом
+// secret скрываются, у адресов с secret:"url" остаются схема и хост; секреты, заданные
+// ссылкой, выводятся ссылкой
+func (o *Options) Print(w io.Writer) error {
+	for _, name := range fieldNames() {
+		if _, err := fmt.Fprintf(w, "%s: %s  # %s\n", name, o.Display(name), o.Source(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Display значение поля для вывода, секреты скрыты
+func (o *Options) Display(name string) string {
+	f, ok := fieldSpec(name)
+	if !ok {
+		return ""
+	}
+	if ref, ok := o.secretRefs[name]; ok {
+		return strconv.Quote(ref)
+	}
+	v := o.field(name)
+	if v.IsZero() {
+		return formatValue(v)
+	}
+
+	switch f.Tag.Get("secret") {
+	case "url":
+		return redactURL(v.String())
+	case "":
+		return formatValue(v)
+	default:
+		return strconv.Quote(redacted)
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return fmt.Sprint(v.Interface())
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	case v.Kind() == reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case v.Kind() == reflect.Map:
+		pairs := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			pairs = append(pairs, fmt.Sprintf("%v: %v", key.Interface(), v.MapIndex(key).Interface()))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// redactURL скрывает учетные данные, путь и параметры адреса: в DSN sentry ключ стоит на месте
+// пользователя, у вебхуков секрет в пути, у удаленного Chrome токен в параметрах
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return strconv.Quote(redacted)
+	}
+	out := u.Scheme + "://"
+	if u.User != nil {
+		out += redacted + "@"
+	}
+	out += u.Host
+	if u.Path != "" && u.Path != "/" {
+		out += "/" + redacted
+	}
+	if u.RawQuery != "" {
+		out += "?" + redacted
+	}
+	return strconv.Quote(out)
+}
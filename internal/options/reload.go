@@ -0,0 +1,151 @@
+package options
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// watchInterval как часто проверять время изменения файлов конфигурации. Опрос вместо
+// inotify переживает замену файла через symlink, как у ConfigMap в k8s
+const watchInterval = 2 * time.Second
+
+// Reloader перечитывает настройки при изменении файлов конфигурации и по SIGHUP.
+// Новые настройки собираются теми же слоями, что и при старте, и проверяются Validate;
+// применяются только поля с тегом reload, об остальных изменениях пишется предупреждение
+type Reloader struct {
+	logger  *logger.Logger
+	args    []string
+	service Service
+	current atomic.Pointer[Options]
+
+	// mu защищает subscribers и сборку нового набора настроек. Обработчики вызываются
+	// без нее: они могут работать долго (переход на новый site), а OnReload ждал бы их
+	mu          sync.Mutex
+	subscribers []func(o *Options)
+	// reloading не дает двум перезагрузкам вызывать обработчики вперемешку
+	reloading sync.Mutex
+}
+
+// NewReloader args — аргументы, с которыми вызывался NewOptions
+func NewReloader(l *logger.Logger, o *Options, args []string, service Service) *Reloader {
+	r := &Reloader{logger: l.Named("options"), args: args, service: service}
+	r.current.Store(o)
+	return r
+}
+
+// Current действующие настройки. Возвращаемое значение не меняется, после перезагрузки
+// Current возвращает новый экземпляр
+func (r *Reloader) Current() *Options {
+	return r.current.Load()
+}
+
+// OnReload добавляет обработчик, который получает настройки после каждой успешной перезагрузки
+func (r *Reloader) OnReload(fn func(o *Options)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Watch следит за файлами конфигурации и SIGHUP, блокирует вызывающего
+func (r *Reloader) Watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	modified := modTimes(r.Current().files)
+	for {
+		select {
+		case <-hup:
+			r.logger.Info("Получен SIGHUP, перечитываем настройки")
+		case <-ticker.C:
+			next := modTimes(r.Current().files)
+			if maps.Equal(next, modified) {
+				continue
+			}
+			modified = next
+			r.logger.Info("Файл конфигурации изменился, перечитываем настройки")
+		}
+
+		if err := r.Reload(); err != nil {
+			r.logger.Error("Настройки не перезагружены:", err)
+		}
+	}
+}
+
+// Reload перечитывает настройки и применяет изменения полей с тегом reload. При ошибке
+// загрузки или проверки действующие настройки не меняются
+func (r *Reloader) Reload() error {
+	next, _, err := NewOptions(r.args)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(r.service); err != nil {
+		return fmt.Errorf("некорректные настройки:\n%w", err)
+	}
+
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	r.mu.Lock()
+	current := r.current.Load()
+	applied := *current
+	applied.sources = maps.Clone(current.sources)
+	applied.files = next.files
+
+	var changed, restart []string
+	for _, name := range fieldNames() {
+		before, after := current.field(name), next.field(name)
+		if reflect.DeepEqual(before.Interface(), after.Interface()) {
+			continue
+		}
+		if f, _ := fieldSpec(name); f.Tag.Get("reload") == "" {
+			restart = append(restart, name)
+			continue
+		}
+		applied.field(name).Set(after)
+		applied.sources[name] = next.Source(name)
+		changed = append(changed, fmt.Sprintf("%s: %s -> %s", name, current.Display(name), next.Display(name)))
+	}
+
+	if len(changed) > 0 {
+		r.current.Store(&applied)
+	}
+	subscribers := slices.Clone(r.subscribers)
+	r.mu.Unlock()
+
+	if len(restart) > 0 {
+		r.logger.Warn("Изменения вступят в силу после перезапуска:", restart)
+	}
+	if len(changed) == 0 {
+		r.logger.Info("Настройки, применяемые без перезапуска, не изменились")
+		return nil
+	}
+
+	for _, fn := range subscribers {
+		fn(&applied)
+	}
+	r.logger.Info("Настройки перезагружены:", strings.Join(changed, "; "))
+	return nil
+}
+
+func modTimes(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			times[path] = info.ModTime()
+		}
+	}
+	return times
+}
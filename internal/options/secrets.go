@@ -0,0 +1,197 @@
+package options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// vaultTimeout сколько ждать ответ Vault на один путь
+const vaultTimeout = 10 * time.Second
+
+// Ссылки на секреты в полях с тегом secret. Вместо значения в поле пишется ссылка:
+//
+//	file:/run/secrets/db_password        содержимое файла без завершающих пробелов и переводов строк
+//	env:DB_PASSWORD                      значение переменной окружения
+//	vault:secret/data/pinnacle#password  поле password секрета из Vault (KV v1 и v2) по vaultAddress
+var secretSchemes = []string{"file:", "env:", "vault:"}
+
+func isSecretRef(value string) bool {
+	for _, scheme := range secretSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecrets заменяет ссылки в полях с тегом secret значениями. Сами ссылки сохраняются
+// для config print, значения секретов не попадают ни в ошибки, ни в вывод
+func (o *Options) resolveSecrets() error {
+	o.secretRefs = make(map[string]string)
+
+	// токен Vault нужен для остальных ссылок, поэтому разрешается первым и без vault:
+	if strings.HasPrefix(o.VaultToken, "vault:") {
+		return errors.New("vaultToken не может ссылаться на Vault")
+	}
+	if err := o.resolveSecret("vaultToken", nil); err != nil {
+		return err
+	}
+
+	vault := &vaultClient{address: o.VaultAddress, token: o.VaultToken, cache: make(map[string]map[string]any)}
+	for _, name := range fieldNames() {
+		if name == "vaultToken" {
+			continue
+		}
+		if err := o.resolveSecret(name, vault); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Options) resolveSecret(name string, vault *vaultClient) error {
+	f, _ := fieldSpec(name)
+	v := o.field(name)
+	if f.Tag.Get("secret") == "" || v.Kind() != reflect.String || !isSecretRef(v.String()) {
+		return nil
+	}
+
+	ref := v.String()
+	value, err := resolveRef(ref, vault)
+	if err != nil {
+		return fmt.Errorf("секрет %s (%s): %w", name, ref, err)
+	}
+	o.secretRefs[name] = ref
+	v.SetString(value)
+	return nil
+}
+
+func resolveRef(ref string, vault *vaultClient) (string, error) {
+	scheme, target, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "file":
+		data, err := os.ReadFile(target)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), " \r\n\t"), nil
+	case "env":
+		value, ok := os.LookupEnv(target)
+		if !ok {
+			return "", errors.New("переменная окружения не задана")
+		}
+		return value, nil
+	case "vault":
+		path, key, ok := strings.Cut(target, "#")
+		if !ok || key == "" {
+			return "", errors.New("ожидается vault:<путь>#<ключ>")
+		}
+		return vault.get(path, key)
+	}
+	return "", errors.New("неизвестная схема " + scheme)
+}
+
+// vaultClient читает секреты через HTTP API Vault, каждый путь запрашивается один раз
+type vaultClient struct {
+	address string
+	token   string
+	cache   map[string]map[string]any
+}
+
+func (c *vaultClient) get(path, key string) (string, error) {
+	data, ok := c.cache[path]
+	if !ok {
+		var err error
+		if data, err = c.read(path); err != nil {
+			return "", err
+		}
+		c.cache[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("в секрете %s нет ключа %s", path, key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("ключ %s секрета %s не строка", key, path)
+	}
+	return s, nil
+}
+
+func (c *vaultClient) read(path string) (map[string]any, error) {
+	if c.address == "" {
+		return nil, errors.New("не задан vaultAddress")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault ответил %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := sonic.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("ответ vault: %w", err)
+	}
+	// в KV v2 значения лежат в data.data, рядом с data.metadata
+	if nested, ok := reply.Data["data"].(map[string]any); ok {
+		if _, hasMeta := reply.Data["metadata"]; hasMeta {
+			return nested, nil
+		}
+	}
+	return reply.Data, nil
+}
+
+// PlaintextSecrets поля с секретами, заданные открытым текстом в файле или флаге, а не ссылкой.
+// В переменных окружения секреты допустимы: так их передают оркестраторы
+func (o *Options) PlaintextSecrets() []string {
+	var names []string
+	for _, name := range fieldNames() {
+		f, _ := fieldSpec(name)
+		secret := f.Tag.Get("secret")
+		v := o.field(name)
+		if secret == "" || v.IsZero() || o.secretRefs[name] != "" {
+			continue
+		}
+		if src := o.Source(name); !strings.HasPrefix(src, "file ") && !strings.HasPrefix(src, "flag ") {
+			continue
+		}
+		if secret == "url" && !strings.Contains(redactURL(v.String()), redacted) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,115 @@
+package options
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newVault httptest заглушка Vault: KV v2 secret/data/pinnacle, KV v1 kv/pinnacle
+// и запрещенный путь secret/data/forbidden
+func newVault(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("X-Vault-Token") != "s.root" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/pinnacle":
+			w.Write([]byte(`{"data":{"data":{"password":"p4ss","dsn":"postgres://u:p@db/pinnacle"},"metadata":{"version":3}}}`))
+		case "/v1/kv/pinnacle":
+			w.Write([]byte(`{"data":{"password":"v1-pass"}}`))
+		case "/v1/secret/data/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func newVaultClient(address, token string) *vaultClient {
+	return &vaultClient{address: address, token: token, cache: make(map[string]map[string]any)}
+}
+
+func TestVaultHit(t *testing.T) {
+	srv, requests := newVault(t)
+	vault := newVaultClient(srv.URL+"/", "s.root")
+
+	for key, want := range map[string]string{"password": "p4ss", "dsn": "postgres://u:p@db/pinnacle"} {
+		got, err := resolveRef("vault:secret/data/pinnacle#"+key, vault)
+		if err != nil || got != want {
+			t.Fatalf("%s = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("vault requests = %d, want 1 per path", n)
+	}
+
+	if got, err := resolveRef("vault:kv/pinnacle#password", vault); err != nil || got != "v1-pass" {
+		t.Fatalf("KV v1 password = %q, %v", got, err)
+	}
+}
+
+func TestVaultMissingKey(t *testing.T) {
+	srv, _ := newVault(t)
+	_, err := resolveRef("vault:secret/data/pinnacle#token", newVaultClient(srv.URL, "s.root"))
+	if err == nil || !strings.Contains(err.Error(), "нет ключа token") {
+		t.Fatalf("err = %v, want a missing key error", err)
+	}
+
+	if _, err := resolveRef("vault:secret/data/absent#password", newVaultClient(srv.URL, "s.root")); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("err = %v, want 404", err)
+	}
+}
+
+func TestVaultForbidden(t *testing.T) {
+	srv, _ := newVault(t)
+	for _, c := range []struct{ path, token string }{
+		{"secret/data/forbidden", "s.root"},
+		{"secret/data/pinnacle", "s.wrong"},
+	} {
+		_, err := resolveRef("vault:"+c.path+"#password", newVaultClient(srv.URL, c.token))
+		if err == nil || !strings.Contains(err.Error(), "403") {
+			t.Fatalf("%s: err = %v, want 403", c.path, err)
+		}
+		if strings.Contains(err.Error(), c.token) {
+			t.Fatalf("error leaks the token: %v", err)
+		}
+	}
+}
+
+// TestResolveSecretsFromVault ссылка в поле заменяется значением, а сама ссылка остается для config print
+func TestResolveSecretsFromVault(t *testing.T) {
+	srv, _ := newVault(t)
+	t.Setenv("PINNACLE_TEST_VAULT_TOKEN", "s.root")
+	o := &Options{
+		VaultAddress: srv.URL,
+		VaultToken:   "env:PINNACLE_TEST_VAULT_TOKEN",
+		Password:     "vault:secret/data/pinnacle#password",
+	}
+	if err := o.resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if o.Password != "p4ss" || o.VaultToken != "s.root" {
+		t.Fatalf("password = %q, vaultToken = %q", o.Password, o.VaultToken)
+	}
+	if o.secretRefs["password"] != "vault:secret/data/pinnacle#password" {
+		t.Fatalf("secretRefs = %v", o.secretRefs)
+	}
+
+	o = &Options{VaultAddress: srv.URL, VaultToken: "s.wrong", Password: "vault:secret/data/pinnacle#password"}
+	err := o.resolveSecrets()
+	if err == nil || !strings.Contains(err.Error(), "секрет password") || strings.Contains(err.Error(), "p4ss") {
+		t.Fatalf("err = %v", err)
+	}
+}
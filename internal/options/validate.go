@@ -0,0 +1,295 @@
+package options
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+)
+
+// Service сервис, для которого проверяются настройки: у каждого свой набор обязательных полей
+type Service string
+
+const (
+	Parser   Service = "parser"
+	Consumer Service = "consumer"
+	API      Service = "api"
+)
+
+// FieldError ошибка в значении одного поля
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError все ошибки настроек сразу, по одной на строку
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	lines := make([]string, len(e))
+	for i, fe := range e {
+		lines[i] = fe.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReportValidation выводит результат Validate для config validate и возвращает ошибку,
+// если настройки некорректны
+func (o *Options) ReportValidation(w io.Writer, service Service) error {
+	err := o.Validate(service)
+	var invalid ValidationError
+	if !errors.As(err, &invalid) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "настройки %s корректны\n", service)
+		}
+		return err
+	}
+	for _, fe := range invalid {
+		fmt.Fprintln(w, fe.Error())
+	}
+	return fmt.Errorf("ошибок в настройках %s: %d", service, len(invalid))
+}
+
+type validator struct {
+	o      *Options
+	errors ValidationError
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate проверяет настройки сервиса service и возвращает ValidationError со всеми
+// найденными ошибками. Проверяется только формат значений, доступность kafka и базы нет
+func (o *Options) Validate(service Service) error {
+	v := &validator{o: o}
+	v.common()
+	switch service {
+	case Parser:
+		v.parser()
+	case Consumer:
+		v.consumer()
+	case API:
+		v.api()
+	default:
+		v.fail("service", "неизвестный сервис %q", service)
+	}
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
+
+func (v *validator) common() {
+	o := v.o
+	v.level("logLevel", o.LogLevel)
+	for pkg, level := range o.LogLevels {
+		v.level("logLevels."+pkg, level)
+	}
+	v.oneOf("logFormat", o.LogFormat, "text", "json")
+	v.ratio("otelSampleRatio", o.OtelSampleRatio)
+	v.hostPort("otelEndpoint", o.OtelEndpoint)
+	v.httpURL("vaultAddress", o.VaultAddress)
+
+	if (o.AlertTelegramToken == "") != (o.AlertTelegramChatID == "") {
+		v.fail("alertTelegramChatID", "alertTelegramToken и alertTelegramChatID задаются вместе")
+	}
+	v.httpURL("alertTelegramURL", o.AlertTelegramURL)
+	v.httpURL("alertWebhookURL", o.AlertWebhookURL)
+	if o.AlertSMTPAddress != "" {
+		v.hostPort("alertSMTPAddress", o.AlertSMTPAddress)
+		if len(o.AlertEmailTo) == 0 {
+			v.fail("alertEmailTo", "обязательно при заданном alertSMTPAddress")
+		}
+		v.email("alertEmailFrom", o.AlertEmailFrom)
+		for _, to := range o.AlertEmailTo {
+			v.email("alertEmailTo", to)
+		}
+	}
+	v.nonNegative("alertRatePerMinute", o.AlertRatePerMinute)
+	v.ratio("alertErrorRate", o.AlertErrorRate)
+
+	for _, name := range fieldNames() {
+		if f := o.field(name); f.Type() == durationType && f.Int() < 0 {
+			v.fail(name, "отрицательная длительность %s", f.Interface())
+		}
+	}
+}
+
+func (v *validator) kafka() {
+	o := v.o
+	v.required("kafkaAddress", o.KafkaAddress)
+	v.required("kafkaTopic", o.KafkaTopic)
+	if port, err := strconv.Atoi(o.KafkaPort); err != nil || port < 1 || port > 65535 {
+		v.fail("kafkaPort", "ожидается порт от 1 до 65535, получено %q", o.KafkaPort)
+	}
+}
+
+func (v *validator) parser() {
+	o := v.o
+	v.kafka()
+	v.required("site", o.Site)
+	v.httpURL("site", o.Site)
+	v.routes("matchRoutes", o.MatchRoutes)
+	v.routes("betRoutes", o.BetRoutes)
+	for _, bet := range o.BetRoutes {
+		for _, match := range o.MatchRoutes {
+			if bet != "" && match != "" && (strings.HasSuffix(bet, match) || strings.HasSuffix(match, bet)) {
+				v.fail("betRoutes", "окончание %q пересекается с окончанием %q из matchRoutes", bet, match)
+			}
+		}
+	}
+	if !o.TestMode {
+		v.required("login", o.Login)
+		v.required("password", o.Password)
+	}
+	if o.RemoteChromeURL != "" {
+		u, err := url.Parse(o.RemoteChromeURL)
+		if err != nil || u.Host == "" || (u.Scheme != "ws" && u.Scheme != "wss" && u.Scheme != "http" && u.Scheme != "https") {
+			// значение не выводится: в адресе может быть токен
+			v.fail("remoteChromeURL", "ожидается адрес ws://, wss://, http:// или https:// с хостом")
+		}
+	}
+	v.hostPort("httpAddress", o.HttpAddress)
+	v.hostPort("grpcAddress", o.GrpcAddress)
+	v.positive("snapshotChunkSize", o.SnapshotChunkSize)
+	v.nonNegative("deleteGraceMisses", o.DeleteGraceMisses)
+	if o.StateBackend != "" {
+		v.oneOf("stateBackend", o.StateBackend, "memory", "redis")
+	}
+	if o.StateBackend == "redis" {
+		v.required("redisAddress", o.RedisAddress)
+		v.hostPort("redisAddress", o.RedisAddress)
+		v.nonNegative("redisDB", o.RedisDB)
+	}
+	if o.StateFile != "" && o.CheckpointInterval <= 0 {
+		v.fail("checkpointInterval", "должен быть больше нуля при заданном stateFile")
+	}
+}
+
+func (v *validator) consumer() {
+	v.kafka()
+	v.database()
+	v.nonNegative("consumerRetries", v.o.ConsumerRetries)
+	v.hostPort("metricsAddress", v.o.MetricsAddress)
+}
+
+func (v *validator) api() {
+	v.database()
+	v.required("apiAddress", v.o.ApiAddress)
+	v.hostPort("apiAddress", v.o.ApiAddress)
+}
+
+func (v *validator) database() {
+	if !v.required("dbConnection", v.o.DbConnection) {
+		return
+	}
+	if _, err := pgconn.ParseConfig(v.o.DbConnection); err != nil {
+		// текст ошибки pgconn не выводится: в нем может быть строка подключения
+		v.fail("dbConnection", "не разбирается как строка подключения PostgreSQL")
+	}
+}
+
+func (v *validator) required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "обязательное поле")
+		return false
+	}
+	return true
+}
+
+// routes проверяет список окончаний адресов: хотя бы одно окончание и ни одного пустого
+func (v *validator) routes(field string, suffixes []string) {
+	if len(suffixes) == 0 {
+		v.fail(field, "обязательное поле")
+	}
+	for _, suffix := range suffixes {
+		if strings.TrimSpace(suffix) == "" {
+			v.fail(field, "пустое окончание адреса")
+		}
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.fail(field, "ожидается одно из %s, получено %q", strings.Join(allowed, ", "), value)
+}
+
+func (v *validator) level(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := logrus.ParseLevel(value); err != nil {
+		v.fail(field, "неизвестный уровень %q, ожидается debug, info, warn или error", value)
+	}
+}
+
+func (v *validator) ratio(field string, value float64) {
+	if value < 0 || value > 1 {
+		v.fail(field, "ожидается число от 0 до 1, получено %g", value)
+	}
+}
+
+func (v *validator) positive(field string, value int) {
+	if value <= 0 {
+		v.fail(field, "должно быть больше нуля, получено %d", value)
+	}
+}
+
+func (v *validator) nonNegative(field string, value int) {
+	if value < 0 {
+		v.fail(field, "не может быть отрицательным, получено %d", value)
+	}
+}
+
+// hostPort проверяет адрес вида host:port или :port, пустое значение допустимо
+func (v *validator) hostPort(field, value string) {
+	if value == "" {
+		return
+	}
+	_, port, err := net.SplitHostPort(value)
+	if err == nil {
+		var n int
+		n, err = strconv.Atoi(port)
+		if err == nil && (n < 0 || n > 65535) {
+			err = fmt.Errorf("port out of range")
+		}
+	}
+	if err != nil {
+		v.fail(field, "ожидается host:port, получено %q", value)
+	}
+}
+
+// httpURL проверяет адрес http(s), пустое значение допустимо. Значение не выводится:
+// в адресах вебхуков и Vault могут быть секреты
+func (v *validator) httpURL(field, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		v.fail(field, "ожидается адрес http:// или https:// с хостом")
+	}
+}
+
+func (v *validator) email(field, value string) {
+	if _, err := mail.ParseAddress(value); err != nil {
+		v.fail(field, "некорректный адрес почты %q", value)
+	}
+}
@@ -0,0 +1,42 @@
+package options
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRoutes(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		matches, bets []string
+		want          []string
+	}{
+		{"defaults", []string{"related"}, []string{"straight"}, nil},
+		{"empty", nil, []string{""}, []string{"matchRoutes", "betRoutes"}},
+		{"same suffix", []string{"related"}, []string{"related"}, []string{"betRoutes"}},
+		{"overlapping suffix", []string{"markets"}, []string{"markets/straight", "straight/markets"}, []string{"betRoutes"}},
+	} {
+		o := &Options{}
+		o.fillDefaultValues()
+		o.KafkaTopic = "bookmaker_event"
+		o.TestMode = true
+		o.MatchRoutes, o.BetRoutes = c.matches, c.bets
+
+		var invalid ValidationError
+		if err := o.Validate(Parser); err != nil && !errors.As(err, &invalid) {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var fields []string
+		for _, e := range invalid {
+			fields = append(fields, e.Field)
+		}
+		if len(fields) != len(c.want) {
+			t.Fatalf("%s: errors %v, want fields %v", c.name, invalid, c.want)
+		}
+		for i := range fields {
+			if fields[i] != c.want[i] {
+				t.Fatalf("%s: errors %v, want fields %v", c.name, invalid, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package stateapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// Server read-only HTTP API над текущим состоянием парсера
+type Server struct {
+	logger *logger.Logger
+	store  abstruct.StateStore
+	mux    *http.ServeMux
+}
+
+// matchView матч с временем последнего изменения
+type matchView struct {
+	*parsed.Match
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// marketView рынок с временем последнего изменения
+type marketView struct {
+	*parsed.Straight
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewServer(l *logger.Logger, s abstruct.StateStore) *Server {
+	srv := &Server{logger: l.Named("stateapi"), store: s, mux: http.NewServeMux()}
+	srv.mux.HandleFunc("GET /state/stats", srv.stats)
+	srv.mux.HandleFunc("GET /state/matches", srv.listMatches)
+	srv.mux.HandleFunc("GET /state/matches/{id}", srv.getMatch)
+	srv.mux.HandleFunc("GET /state/matches/{id}/markets", srv.listMarkets)
+
+	return srv
+}
+
+// Handle добавляет обработчик на тот же сервер, например метрики или стриминг
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start блокирующе слушает адрес, ошибка запуска завершает процесс
+func (s *Server) Start(addr string) {
+	srv := &http.Server{Addr: addr, Handler: s.mux, ReadHeaderTimeout: 5 * time.Second}
+	s.logger.Info("State API listening on", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Fatal("State API server failed:", err)
+	}
+}
+
+func (s *Server) stats(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.store.Stats())
+}
+
+func (s *Server) listMatches(w http.ResponseWriter, _ *http.Request) {
+	matches := s.store.MatchList()
+	views := make([]matchView, 0, len(matches))
+	for _, m := range matches {
+		views = append(views, matchView{Match: m, UpdatedAt: m.UpdatedAt})
+	}
+
+	s.writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getMatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "match id must be a number"})
+		return
+	}
+
+	match, ok := s.store.Match(id)
+	if !ok {
+		s.writeJSON(w, http.StatusNotFound, errorResponse{Error: "match not found"})
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, matchView{Match: match, UpdatedAt: match.UpdatedAt})
+}
+
+func (s *Server) listMarkets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "match id must be a number"})
+		return
+	}
+
+	bets := s.store.MatchBets(id)
+	views := make([]marketView, 0, len(bets))
+	for _, b := range bets {
+		views = append(views, marketView{Straight: b, UpdatedAt: b.UpdatedAt})
+	}
+
+	s.writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := sonic.Marshal(v)
+	if err != nil {
+		s.logger.Error("Failed to marshal state response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,111 @@
+package stateapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// newTestServer сервер над MapStorage с матчами 1 и 2 и двумя рынками матча 1
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	ctx := context.Background()
+	store := storage.NewMapStorage(storage.DeleteGrace{})
+	league := &parsed.League{ID: 10, Name: "League", Sport: &parsed.Sport{ID: 1, Name: "Sport"}}
+	store.SetMatches(ctx, []*parsed.Match{{ID: 1, League: league}, {ID: 2, League: league}})
+	store.SetBets(ctx, map[int][]*parsed.Straight{1: {
+		{Key: "s;0;m", MatchupID: 1, Type: "moneyline", Status: parsed.BET_STATUS_OPEN},
+		{Key: "s;0;s;-1.5", MatchupID: 1, Type: "spread", Status: parsed.BET_STATUS_OPEN},
+	}})
+
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	return NewServer(l, store).mux
+}
+
+func get(t *testing.T, h http.Handler, target string, body any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: content type %q", target, ct)
+	}
+	if err := sonic.Unmarshal(rec.Body.Bytes(), body); err != nil {
+		t.Fatalf("%s: %v: %s", target, err, rec.Body)
+	}
+	return rec.Code
+}
+
+func TestStats(t *testing.T) {
+	h := newTestServer(t)
+	var stats map[string]any
+	if code := get(t, h, "/state/stats", &stats); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if stats["matches"] != float64(2) || stats["markets"] != float64(2) {
+		t.Fatalf("stats = %v", stats)
+	}
+}
+
+func TestListMatches(t *testing.T) {
+	h := newTestServer(t)
+	var matches []map[string]any
+	if code := get(t, h, "/state/matches", &matches); code != http.StatusOK || len(matches) != 2 {
+		t.Fatalf("status %d, matches %v", code, matches)
+	}
+	for _, m := range matches {
+		if at, _ := m["updatedAt"].(string); at == "" || at[:4] == "0001" {
+			t.Fatalf("match without updatedAt: %v", m)
+		}
+	}
+}
+
+func TestGetMatch(t *testing.T) {
+	h := newTestServer(t)
+
+	var match map[string]any
+	if code := get(t, h, "/state/matches/1", &match); code != http.StatusOK || match["id"] != float64(1) {
+		t.Fatalf("status %d, match %v", code, match)
+	}
+	if _, ok := match["updatedAt"]; !ok {
+		t.Fatalf("match without updatedAt: %v", match)
+	}
+
+	var failure errorResponse
+	if code := get(t, h, "/state/matches/42", &failure); code != http.StatusNotFound || failure.Error != "match not found" {
+		t.Fatalf("unknown match: status %d, %+v", code, failure)
+	}
+	if code := get(t, h, "/state/matches/x", &failure); code != http.StatusBadRequest {
+		t.Fatalf("bad id: status %d, %+v", code, failure)
+	}
+}
+
+func TestListMarkets(t *testing.T) {
+	h := newTestServer(t)
+
+	var markets []map[string]any
+	if code := get(t, h, "/state/matches/1/markets", &markets); code != http.StatusOK || len(markets) != 2 {
+		t.Fatalf("status %d, markets %v", code, markets)
+	}
+	if _, ok := markets[0]["updatedAt"]; !ok {
+		t.Fatalf("market without updatedAt: %v", markets[0])
+	}
+
+	// у матча без рынков пустой массив, а не null
+	markets = nil
+	if code := get(t, h, "/state/matches/2/markets", &markets); code != http.StatusOK || markets == nil || len(markets) != 0 {
+		t.Fatalf("status %d, markets %v", code, markets)
+	}
+
+	var failure errorResponse
+	if code := get(t, h, "/state/matches/x/markets", &failure); code != http.StatusBadRequest {
+		t.Fatalf("bad id: status %d", code)
+	}
+}
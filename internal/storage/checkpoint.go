@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+// checkpoint содержимое файла состояния: данные вместе с отличиями, о которых события еще
+// не отправлены
+type checkpoint struct {
+	SavedAt time.Time          `json:"savedAt"`
+	Matches []*parsed.Match    `json:"matches"`
+	Bets    []*parsed.Straight `json:"bets"`
+	Pending pendingRefs        `json:"pending"`
+}
+
+// pendingRefs ссылки на неотправленные отличия в файле состояния и в outbox redis. Отметки об
+// измененных полях не сохраняются, поэтому при повторной отправке новые, измененные и вернувшиеся
+// записи уходят целиком как MATCH_NEW и BET_NEW, консьюмер применяет их так же, как патчи
+type pendingRefs struct {
+	Matches []int        `json:"matches,omitempty"`
+	Deleted []int        `json:"deleted,omitempty"`
+	Bets    []pendingBet `json:"bets,omitempty"`
+}
+
+type pendingBet struct {
+	MatchID int    `json:"matchId"`
+	Key     string `json:"key"`
+}
+
+func (p pendingRefs) empty() bool {
+	return len(p.Matches) == 0 && len(p.Deleted) == 0 && len(p.Bets) == 0
+}
+
+// SaveCheckpoint атомарно записывает текущее состояние и незабранные отличия в файл.
+// Отличия, которые отправитель уже забрал, но kafka еще не подтвердила, в файл не попадают:
+// при остановке их дожидается Sender.Drain
+func (m *MapStorage) SaveCheckpoint(path string) error {
+	cp := checkpoint{SavedAt: time.Now()}
+
+	m.mu.RLock()
+	for _, match := range m.Matches {
+		cp.Matches = append(cp.Matches, match.Clone())
+	}
+	for _, bets := range m.Bets {
+		for _, bet := range bets {
+			cp.Bets = append(cp.Bets, bet.Clone())
+		}
+	}
+	for _, ids := range []map[int]struct{}{m.newMatches.items, m.updatedMatches.items, m.restoredMatches.items} {
+		for id := range ids {
+			cp.Pending.Matches = append(cp.Pending.Matches, id)
+		}
+	}
+	for id := range m.deletedMatches.items {
+		cp.Pending.Deleted = append(cp.Pending.Deleted, id)
+	}
+	for _, refs := range []map[betRef]struct{}{m.newBets.items, m.updatedBets.items} {
+		for ref := range refs {
+			cp.Pending.Bets = append(cp.Pending.Bets, pendingBet{MatchID: ref.matchID, Key: ref.key})
+		}
+	}
+	m.mu.RUnlock()
+
+	data, err := sonic.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCheckpoint восстанавливает состояние из файла: сохраненные незабранные отличия снова
+// ставятся в очередь, дальнейшие SetMatches и SetBets отправят только отличия от файла.
+// Отсутствие файла не является ошибкой
+func (m *MapStorage) LoadCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var cp checkpoint
+	if err := sonic.Unmarshal(data, &cp); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, match := range cp.Matches {
+		if match == nil {
+			continue
+		}
+		match.UpdatedAt = cp.SavedAt
+		m.Matches[match.ID] = match
+		m.unconfirmed[match.ID] = struct{}{}
+	}
+	for _, bet := range cp.Bets {
+		if bet == nil {
+			continue
+		}
+		if _, ok := m.Matches[bet.MatchupID]; !ok {
+			continue
+		}
+		if m.Bets[bet.MatchupID] == nil {
+			m.Bets[bet.MatchupID] = make(map[string]*parsed.Straight)
+		}
+		bet.UpdatedAt = cp.SavedAt
+		m.Bets[bet.MatchupID][bet.Key] = bet
+	}
+
+	m.requeue(cp.Pending, change{at: cp.SavedAt})
+	return len(m.unconfirmed), nil
+}
+
+// requeue снова ставит в очередь неотправленные отличия и будит отправителя, вызывается под m.mu.
+// Матчи и ставки, которых уже нет, пропускаются: их удаление стоит в своей очереди
+func (m *MapStorage) requeue(p pendingRefs, c change) {
+	for _, id := range p.Matches {
+		if _, ok := m.Matches[id]; ok {
+			m.updatedMatches.remove(id)
+			m.restoredMatches.remove(id)
+			m.newMatches.put(id, struct{}{}, c)
+		}
+	}
+	for _, id := range p.Deleted {
+		if _, ok := m.Matches[id]; !ok {
+			m.deletedMatches.put(id, struct{}{}, c)
+		}
+	}
+	for _, ref := range p.Bets {
+		if _, ok := m.Bets[ref.MatchID][ref.Key]; ok {
+			bet := betRef{matchID: ref.MatchID, key: ref.Key}
+			m.updatedBets.remove(bet)
+			m.newBets.put(bet, struct{}{}, c)
+		}
+	}
+	if len(m.newMatches.items) > 0 {
+		m.notify.matchNew.wake()
+	}
+	if len(m.deletedMatches.items) > 0 {
+		m.notify.matchDel.wake()
+	}
+	if len(m.newBets.items) > 0 {
+		m.notify.betNew.wake()
+	}
+}
+
+// RunCheckpoints сохраняет состояние каждые interval, вызывается в отдельной горутине
+func (m *MapStorage) RunCheckpoints(path string, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.SaveCheckpoint(path); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// ExpireRestored удаляет восстановленные матчи, которые так и не пришли от источника после
+// рестарта, например завершившиеся, пока парсер был остановлен, и отправляет по ним MATCH_DELETE
+func (m *MapStorage) ExpireRestored() int {
+	m.mu.Lock()
+	c := change{at: time.Now()}
+	deleted := len(m.unconfirmed)
+	for id := range m.unconfirmed {
+		m.deleteMatch(id, c)
+	}
+	m.mu.Unlock()
+
+	if deleted > 0 {
+		m.notify.matchDel.wake()
+	}
+	return deleted
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+func group(live bool, ids ...int) []*parsed.Match {
+	matches := make([]*parsed.Match, 0, len(ids))
+	for _, id := range ids {
+		matches = append(matches, &parsed.Match{ID: id, ParentId: 1, IsLive: live, StartTime: time.Unix(1_700_000_000, 0),
+			League: &parsed.League{ID: 1, Name: "League", Sport: &parsed.Sport{ID: 29, Name: "Soccer"}}})
+	}
+	return matches
+}
+
+// TestCheckpointKeepsPending изменения и удаления, о которых события еще не отправлены,
+// переживают сохранение и уходят после восстановления
+func TestCheckpointKeepsPending(t *testing.T) {
+	ctx := context.Background()
+	s := NewMapStorage(DeleteGrace{})
+	s.SetMatches(ctx, group(false, 1, 2))
+	bet := parsed.GenerateExampleStraight(1)
+	s.SetBets(ctx, map[int][]*parsed.Straight{1: {bet}})
+	s.GetNewMatches()
+	s.GetNewBets()
+	s.GetUpdatedMatches()
+
+	s.SetMatches(ctx, group(true, 1))
+	changed := bet.Clone()
+	changed.Prices[0].Price += 10
+	s.SetBets(ctx, map[int][]*parsed.Straight{1: {changed}})
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := s.SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMapStorage(DeleteGrace{})
+	if _, err := restored.LoadCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := restored.GetNewMatches()
+	if len(matches) != 1 || matches[0].ID != 1 || !matches[0].IsLive {
+		t.Fatalf("restored pending matches = %+v, want live match 1", matches)
+	}
+	if deleted, _ := restored.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 2 {
+		t.Fatalf("restored pending deletions = %v, want [2]", deleted)
+	}
+	bets, _ := restored.GetNewBets()
+	if len(bets) != 1 || bets[0].Prices[0].Price != changed.Prices[0].Price {
+		t.Fatalf("restored pending bets = %+v, want the changed bet", bets)
+	}
+
+	select {
+	case <-restored.Notifications().MatchDel:
+	default:
+		t.Fatal("sender is not woken for restored deletions")
+	}
+}
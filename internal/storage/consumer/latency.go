@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LatencyStage задержка одного участка пути изменения цены
+type LatencyStage struct {
+	Name  string
+	Count int64
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// latencyQuery считает перцентили по участкам: захват в Chrome -> отправка в kafka,
+// отправка -> запись в базу и весь путь целиком. Строки без нужных отметок не учитываются
+const latencyQuery = `
+	WITH stages AS (
+		SELECT 'capture->send' AS name, 1 AS ord, sent_at - captured_at AS d FROM price_values WHERE created_at >= $1
+		UNION ALL
+		SELECT 'send->store', 2, created_at - sent_at FROM price_values WHERE created_at >= $1
+		UNION ALL
+		SELECT 'capture->store', 3, created_at - captured_at FROM price_values WHERE created_at >= $1
+	)
+	SELECT name,
+		count(d),
+		COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY d), 0),
+		COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY d), 0),
+		COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY d), 0),
+		COALESCE(max(d), 0)
+	FROM (SELECT name, ord, extract(epoch FROM d)::float8 AS d FROM stages) s
+	GROUP BY name, ord
+	ORDER BY ord`
+
+// LatencyReport возвращает задержку записи изменений цен, сохраненных начиная с since.
+// Отметки ставят разные часы (Chrome, парсер, база), поэтому расхождение часов попадает в результат
+func (p *PostgresDBClient) LatencyReport(ctx context.Context, since time.Time) ([]LatencyStage, error) {
+	rows, err := p.db.Query(ctx, latencyQuery, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LatencyStage, error) {
+		var (
+			stage                LatencyStage
+			p50, p90, p99, maxed float64
+		)
+		err := row.Scan(&stage.Name, &stage.Count, &p50, &p90, &p99, &maxed)
+		stage.P50, stage.P90, stage.P99, stage.Max = seconds(p50), seconds(p90), seconds(p99), seconds(maxed)
+		return stage, err
+	})
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
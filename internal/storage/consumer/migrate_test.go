@@ -0,0 +1,144 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration #%d has version %d, want %d", i, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+// publicTables таблицы схемы public, кроме schema_migrations
+func publicTables(t *testing.T, db *PostgresDBClient) []string {
+	t.Helper()
+	rows, err := db.db.Query(context.Background(),
+		"SELECT tablename FROM pg_tables WHERE schemaname = 'public' AND tablename <> 'schema_migrations' ORDER BY tablename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+// TestMigrationsRoundTrip все миграции применяются к пустой базе, полностью откатываются
+// и применяются снова
+func TestMigrationsRoundTrip(t *testing.T) {
+	db := newEmptyDB(t)
+	ctx := context.Background()
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(migrator.migrations)
+
+	for round := 1; round <= 2; round++ {
+		if applied, err := migrator.Up(ctx); err != nil || applied != total {
+			t.Fatalf("round %d: Up = %d, %v, want %d", round, applied, err, total)
+		}
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil {
+				t.Fatalf("round %d: migration %04d_%s is not applied", round, s.Version, s.Name)
+			}
+		}
+		if applied, err := migrator.Up(ctx); err != nil || applied != 0 {
+			t.Fatalf("round %d: repeated Up = %d, %v, want 0", round, applied, err)
+		}
+		if len(publicTables(t, db)) == 0 {
+			t.Fatalf("round %d: no tables after Up", round)
+		}
+
+		if reverted, err := migrator.Down(ctx, total); err != nil || reverted != total {
+			t.Fatalf("round %d: Down = %d, %v, want %d", round, reverted, err, total)
+		}
+		if tables := publicTables(t, db); len(tables) != 0 {
+			t.Fatalf("round %d: tables left after Down: %v", round, tables)
+		}
+	}
+}
+
+// TestMatchStatusMigration 0005 переводит удаленные матчи в settled или removed по времени
+// начала, откат возвращает им статус deleted
+func TestMatchStatusMigration(t *testing.T) {
+	db := newEmptyDB(t)
+	ctx := context.Background()
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := &Migrator{db: db.db, logger: db.logger}
+	for _, m := range migrator.migrations {
+		if m.Version < 5 {
+			before.migrations = append(before.migrations, m)
+		}
+	}
+	if _, err := before.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.db.Exec(ctx, `
+		INSERT INTO sports (id, name) VALUES (1, 'Soccer');
+		INSERT INTO leagues (id, sport_id, name) VALUES (1, 1, 'League');
+		INSERT INTO matches (id, league_id, start_time, status) VALUES
+			(1, 1, now() - interval '1 hour', 'deleted'),
+			(2, 1, now() + interval '1 hour', 'deleted'),
+			(3, 1, now() + interval '1 hour', 'active')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "settled", 2: "removed", 3: "active"}
+	for id, status := range want {
+		var got string
+		if err := db.db.QueryRow(ctx, "SELECT status FROM matches WHERE id = $1", id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Errorf("match %d status = %q, want %q", id, got, status)
+		}
+	}
+
+	if _, err := migrator.Down(ctx, len(migrator.migrations)-len(before.migrations)); err != nil {
+		t.Fatal(err)
+	}
+	var deleted int
+	if err := db.db.QueryRow(ctx, "SELECT count(*) FROM matches WHERE status = 'deleted'").Scan(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted matches after Down = %d, want 2", deleted)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS price_values;
+DROP TABLE IF EXISTS odds;
+DROP TABLE IF EXISTS match_participants;
+DROP TABLE IF EXISTS matches;
+DROP TABLE IF EXISTS teams;
+DROP TABLE IF EXISTS leagues;
+DROP TABLE IF EXISTS sports;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_price_values_message_key;
+ALTER TABLE price_values DROP COLUMN IF EXISTS message_key;
+DROP TABLE IF EXISTS processed_messages;
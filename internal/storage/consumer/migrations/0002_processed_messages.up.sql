@@ -0,0 +1,12 @@
+-- Idempotency keys of consumed kafka messages
+CREATE TABLE IF NOT EXISTS processed_messages (
+    topic VARCHAR(255) NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (topic, kafka_partition, kafka_offset)
+);
+
+-- Redelivered messages must not duplicate price history
+ALTER TABLE price_values ADD COLUMN IF NOT EXISTS message_key VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_price_values_message_key ON price_values(odd_id, message_key);
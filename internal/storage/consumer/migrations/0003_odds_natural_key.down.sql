@@ -0,0 +1 @@
+ALTER TABLE odds DROP CONSTRAINT IF EXISTS odds_natural_key;
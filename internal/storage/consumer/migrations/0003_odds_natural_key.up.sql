@@ -0,0 +1,29 @@
+-- Requires PostgreSQL 15+: UNIQUE NULLS NOT DISTINCT treats rows without participant_id as equal
+-- Merge odds that share the natural key, keeping the most recently updated row and its price history
+CREATE TEMPORARY TABLE odds_duplicates ON COMMIT DROP AS
+SELECT id, keep_id
+FROM (
+    SELECT id, first_value(id) OVER (
+        PARTITION BY matchup_id, key, designation, participant_id
+        ORDER BY updated_at DESC, id DESC
+    ) AS keep_id
+    FROM odds
+) ranked
+WHERE id <> keep_id;
+
+UPDATE price_values pv
+SET odd_id = d.keep_id
+FROM odds_duplicates d
+WHERE pv.odd_id = d.id;
+
+DELETE FROM odds o
+USING odds_duplicates d
+WHERE o.id = d.id;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'odds_natural_key') THEN
+        ALTER TABLE odds ADD CONSTRAINT odds_natural_key
+            UNIQUE NULLS NOT DISTINCT (matchup_id, key, designation, participant_id);
+    END IF;
+END $$;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS snapshot_chunks;
+DROP TABLE IF EXISTS snapshots;
+DROP INDEX IF EXISTS idx_odds_last_seen_at;
+DROP INDEX IF EXISTS idx_matches_last_seen_at;
+ALTER TABLE odds DROP COLUMN IF EXISTS is_stale;
+ALTER TABLE odds DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE matches DROP COLUMN IF EXISTS is_stale;
+ALTER TABLE matches DROP COLUMN IF EXISTS last_seen_at;
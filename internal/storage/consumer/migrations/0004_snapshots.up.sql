@@ -0,0 +1,28 @@
+-- Time a row was last confirmed by a delta or a snapshot; rows a complete snapshot
+-- did not confirm are marked stale
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS is_stale BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE odds ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE odds ADD COLUMN IF NOT EXISTS is_stale BOOLEAN NOT NULL DEFAULT false;
+
+-- Progress of periodic full-state snapshots, one row per snapshot and event type
+CREATE TABLE IF NOT EXISTS snapshots (
+    snapshot_id VARCHAR(64) NOT NULL,
+    event_type SMALLINT NOT NULL,
+    taken_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    chunks INTEGER NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (snapshot_id, event_type)
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_chunks (
+    snapshot_id VARCHAR(64) NOT NULL,
+    event_type SMALLINT NOT NULL,
+    chunk INTEGER NOT NULL,
+    PRIMARY KEY (snapshot_id, event_type, chunk),
+    FOREIGN KEY (snapshot_id, event_type) REFERENCES snapshots(snapshot_id, event_type) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_matches_last_seen_at ON matches(last_seen_at);
+CREATE INDEX IF NOT EXISTS idx_odds_last_seen_at ON odds(last_seen_at);
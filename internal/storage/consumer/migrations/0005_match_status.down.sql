@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_matches_status;
+UPDATE matches SET status = 'deleted' WHERE status IN ('settled', 'removed');
+UPDATE matches SET status = 'active' WHERE status IN ('suspended', 'missing');
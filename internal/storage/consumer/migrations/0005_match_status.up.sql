@@ -0,0 +1,7 @@
+-- Match lifecycle: active, suspended and missing come from the parser, settled and removed
+-- are set when the parser deletes a match after its grace period
+UPDATE matches
+SET status = CASE WHEN start_time <= CURRENT_TIMESTAMP THEN 'settled' ELSE 'removed' END
+WHERE status = 'deleted';
+
+CREATE INDEX IF NOT EXISTS idx_matches_status ON matches(status);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_price_values_created_at;
+ALTER TABLE price_values DROP COLUMN IF EXISTS sent_at;
+ALTER TABLE price_values DROP COLUMN IF EXISTS captured_at;
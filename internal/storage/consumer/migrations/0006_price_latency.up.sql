@@ -0,0 +1,6 @@
+-- Latency of price changes: captured_at is when Chrome received the response (by Chrome's clock),
+-- sent_at is the kafka message timestamp set by the parser, created_at is the database write
+ALTER TABLE price_values ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+ALTER TABLE price_values ADD COLUMN IF NOT EXISTS sent_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_price_values_created_at ON price_values(created_at);
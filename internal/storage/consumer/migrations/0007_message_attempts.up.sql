@@ -0,0 +1,11 @@
+-- Failed processing attempts of kafka messages, so the retry limit survives restarts and rebalances.
+-- Rows are removed once the message is processed or moved to the dead-letter topic
+CREATE TABLE IF NOT EXISTS message_attempts (
+    topic VARCHAR(255) NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (topic, kafka_partition, kafka_offset)
+);
@@ -0,0 +1,2 @@
+-- Processed message keys older than processedRetention are pruned by the consumer
+CREATE INDEX IF NOT EXISTS idx_processed_messages_processed_at ON processed_messages(processed_at);
@@ -0,0 +1,174 @@
+// Package pgtest дает тестам отдельную пустую базу PostgreSQL. Сервер берется из DatabaseEnv,
+// а если она не задана, запускается временный кластер из локальных initdb и pg_ctl без контейнеров
+package pgtest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DatabaseEnv строка подключения к PostgreSQL 15+, в котором тесты могут создавать базы
+const DatabaseEnv = "PINNACLE_TEST_DATABASE"
+
+var (
+	startOnce sync.Once
+	serverDSN string
+	startErr  error
+	cluster   *localCluster
+)
+
+// Main запускает тесты пакета и останавливает временный кластер, если он запускался.
+// Вызывается из TestMain
+func Main(m *testing.M) {
+	code := m.Run()
+	if cluster != nil {
+		cluster.stop()
+	}
+	os.Exit(code)
+}
+
+// NewDatabase создает отдельную пустую базу на время теста и возвращает строку подключения к ней.
+// Если сервер недоступен, тест пропускается
+func NewDatabase(tb testing.TB) string {
+	tb.Helper()
+	startOnce.Do(func() { serverDSN, startErr = server() })
+	if startErr != nil {
+		tb.Skipf("no PostgreSQL for tests: %v", startErr)
+	}
+
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, serverDSN)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { admin.Close(context.Background()) })
+
+	name := "pinnacle_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if _, err := admin.Exec(context.Background(), "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			tb.Error(err)
+		}
+	})
+
+	cfg := admin.Config()
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Path:     name,
+		RawQuery: "sslmode=disable",
+	}
+	if cfg.TLSConfig != nil {
+		u.RawQuery = "sslmode=require"
+	}
+	return u.String()
+}
+
+// server строка подключения из DatabaseEnv или к запущенному временному кластеру
+func server() (string, error) {
+	if dsn := os.Getenv(DatabaseEnv); dsn != "" {
+		return dsn, nil
+	}
+	c, err := startCluster()
+	if err != nil {
+		return "", fmt.Errorf("%s is not set and a local cluster did not start: %w", DatabaseEnv, err)
+	}
+	cluster = c
+	return c.dsn, nil
+}
+
+// localCluster кластер во временном каталоге, он удаляется после остановки
+type localCluster struct {
+	pgCtl string
+	dir   string
+	dsn   string
+}
+
+func startCluster() (*localCluster, error) {
+	initdb, err := pgBinary("initdb")
+	if err != nil {
+		return nil, err
+	}
+	pgCtl, err := pgBinary("pg_ctl")
+	if err != nil {
+		return nil, err
+	}
+	if os.Geteuid() == 0 {
+		return nil, errors.New("initdb does not run as root")
+	}
+
+	dir, err := os.MkdirTemp("", "pinnacle-pg-")
+	if err != nil {
+		return nil, err
+	}
+	c := &localCluster{pgCtl: pgCtl, dir: dir}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("initdb: %w: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", opts, "-l", filepath.Join(dir, "server.log"), "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("pg_ctl start: %w: %s", err, out)
+	}
+	c.dsn = fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	return c, nil
+}
+
+func (c *localCluster) stop() {
+	_ = exec.Command(c.pgCtl, "-D", filepath.Join(c.dir, "data"), "-m", "immediate", "-w", "stop").Run()
+	os.RemoveAll(c.dir)
+}
+
+// pgBinary ищет программу в PATH, затем в каталогах пакетов Debian и Ubuntu
+func pgBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql", "*", "bin", name))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%s is not found", name)
+	}
+	// берется самая новая версия: /usr/lib/postgresql/<версия>/bin/<name>
+	return slices.MaxFunc(matches, func(a, b string) int {
+		return cmp.Compare(majorVersion(a), majorVersion(b))
+	}), nil
+}
+
+func majorVersion(path string) int {
+	v, _ := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(path))))
+	return v
+}
+
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
@@ -2,48 +2,56 @@ package consumer
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL драйвер
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
 	"github.com/pararti/pinnacle-parser/pkg/jsonpatch"
 	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // PostgresDBClient управляет соединением с PostgreSQL (Supabase)
 type PostgresDBClient struct {
-	db     *sql.DB
+	db     *pgxpool.Pool
 	logger *logger.Logger
-	ctx    context.Context
 }
 
 // NewPostgresDBClient создает новое подключение к PostgreSQL (Supabase)
 func NewPostgresDBClient(connectionString string, logger *logger.Logger) (*PostgresDBClient, error) {
 	ctx := context.Background()
 
+	config, err := pgxpool.ParseConfig(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Устанавливаем параметры пула соединений
+	config.MaxConns = 25
+	config.MinConns = 5
+	config.MaxConnLifetime = time.Hour
+	config.ConnConfig.Tracer = queryTracer{}
+
 	// Открываем соединение с базой данных
-	db, err := sql.Open("pgx", connectionString)
+	db, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 
 	// Проверяем соединение
-	if err := db.PingContext(ctx); err != nil {
+	if err := db.Ping(ctx); err != nil {
 		db.Close()
 		return nil, err
 	}
 
-	// Устанавливаем параметры пула соединений
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(time.Hour)
-
 	client := &PostgresDBClient{
 		db:     db,
-		logger: logger,
-		ctx:    ctx,
+		logger: logger.Named("postgres"),
 	}
 
 	logger.Info("Successfully connected to PostgreSQL database")
@@ -138,7 +146,7 @@ type PriceValueRecord struct {
 }
 
 // StoreSport сохраняет вид спорта в базе данных
-func (p *PostgresDBClient) StoreSport(sport *parsed.Sport) error {
+func (p *PostgresDBClient) StoreSport(ctx context.Context, sport *parsed.Sport) error {
 	if sport == nil {
 		return errors.New("sport is nil")
 	}
@@ -150,7 +158,7 @@ func (p *PostgresDBClient) StoreSport(sport *parsed.Sport) error {
 		SET name = $2
 	`
 
-	_, err := p.db.ExecContext(p.ctx, query, sport.ID, sport.Name)
+	_, err := p.conn(ctx).Exec(ctx, query, sport.ID, sport.Name)
 	if err != nil {
 		return err
 	}
@@ -159,7 +167,7 @@ func (p *PostgresDBClient) StoreSport(sport *parsed.Sport) error {
 }
 
 // StoreLeague сохраняет лигу в базе данных
-func (p *PostgresDBClient) StoreLeague(league *parsed.League) error {
+func (p *PostgresDBClient) StoreLeague(ctx context.Context, league *parsed.League) error {
 	if league == nil {
 		return errors.New("league is nil")
 	}
@@ -169,7 +177,7 @@ func (p *PostgresDBClient) StoreLeague(league *parsed.League) error {
 	}
 
 	// Убедимся, что Sport существует
-	if err := p.StoreSport(league.Sport); err != nil {
+	if err := p.StoreSport(ctx, league.Sport); err != nil {
 		p.logger.Error("Failed to store sport", league.Sport.ID, err)
 		return err
 	}
@@ -188,8 +196,8 @@ func (p *PostgresDBClient) StoreLeague(league *parsed.League) error {
 			sequence = $8
 	`
 
-	_, err := p.db.ExecContext(
-		p.ctx,
+	_, err := p.conn(ctx).Exec(
+		ctx,
 		query,
 		league.ID,
 		league.Sport.ID,
@@ -210,8 +218,46 @@ func (p *PostgresDBClient) StoreLeague(league *parsed.League) error {
 	return nil
 }
 
+// querier общий интерфейс пула и транзакции pgx
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// dbtx общие методы пула и транзакции. Begin внутри транзакции создает точку сохранения
+type dbtx interface {
+	querier
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type txKey struct{}
+
+// WithinTx выполняет fn в одной транзакции: методы клиента, вызванные с ctx из fn, пишут в нее.
+// Ошибка fn откатывает все записи
+func (p *PostgresDBClient) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// conn транзакция WithinTx, если ctx внутри нее, иначе пул
+func (p *PostgresDBClient) conn(ctx context.Context) dbtx {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return p.db
+}
+
 // FindOrCreateTeam находит или создает запись команды
-func (p *PostgresDBClient) FindOrCreateTeam(part *parsed.Participant) (int, error) {
+func (p *PostgresDBClient) FindOrCreateTeam(ctx context.Context, part *parsed.Participant) (int, error) {
+	return p.findOrCreateTeam(ctx, p.conn(ctx), part)
+}
+
+func (p *PostgresDBClient) findOrCreateTeam(ctx context.Context, q querier, part *parsed.Participant) (int, error) {
 	if part == nil {
 		return 0, errors.New("participant is empty")
 	}
@@ -220,19 +266,19 @@ func (p *PostgresDBClient) FindOrCreateTeam(part *parsed.Participant) (int, erro
 	query := `SELECT id FROM teams WHERE name = $1 LIMIT 1`
 
 	var teamId int
-	err := p.db.QueryRowContext(p.ctx, query, part.Name).Scan(&teamId)
+	err := q.QueryRow(ctx, query, part.Name).Scan(&teamId)
 	if err == nil {
 		return teamId, nil
 	}
 
-	if err != sql.ErrNoRows {
+	if err != pgx.ErrNoRows {
 		return 0, err
 	}
 
 	// Если команда не найдена, создаем новую
 	insertQuery := `INSERT INTO teams (name) VALUES ($1) RETURNING id`
 
-	err = p.db.QueryRowContext(p.ctx, insertQuery, part.Name).Scan(&teamId)
+	err = q.QueryRow(ctx, insertQuery, part.Name).Scan(&teamId)
 	if err != nil {
 		return 0, err
 	}
@@ -241,24 +287,24 @@ func (p *PostgresDBClient) FindOrCreateTeam(part *parsed.Participant) (int, erro
 }
 
 // StoreParticipants сохраняет участников матча в базе данных
-func (p *PostgresDBClient) StoreParticipants(matchID int, participants []*parsed.Participant) error {
+func (p *PostgresDBClient) StoreParticipants(ctx context.Context, matchID int, participants []*parsed.Participant) error {
 	if len(participants) == 0 {
 		return errors.New("participants list is empty")
 	}
 
-	tx, err := p.db.BeginTx(p.ctx, nil)
+	tx, err := p.conn(ctx).Begin(ctx)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
 		if err != nil {
-			tx.Rollback()
+			tx.Rollback(ctx)
 		}
 	}()
 
 	// Сначала удаляем существующие связи
-	_, err = tx.ExecContext(p.ctx, "DELETE FROM match_participants WHERE match_id = $1", matchID)
+	_, err = tx.Exec(ctx, "DELETE FROM match_participants WHERE match_id = $1", matchID)
 	if err != nil {
 		return err
 	}
@@ -269,15 +315,16 @@ func (p *PostgresDBClient) StoreParticipants(matchID int, participants []*parsed
 			continue
 		}
 
-		// Находим или создаем команду
-		teamId, err := p.FindOrCreateTeam(participant)
+		// Находим или создаем команду в той же транзакции
+		var teamId int
+		teamId, err = p.findOrCreateTeam(ctx, tx, participant)
 		if err != nil {
 			return err
 		}
 
 		// Создаем связь матч-участник
-		_, err = tx.ExecContext(
-			p.ctx,
+		_, err = tx.Exec(
+			ctx,
 			"INSERT INTO match_participants (match_id, team_id, alignment) VALUES ($1, $2, $3)",
 			matchID,
 			teamId,
@@ -288,7 +335,7 @@ func (p *PostgresDBClient) StoreParticipants(matchID int, participants []*parsed
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		return err
 	}
 
@@ -296,9 +343,9 @@ func (p *PostgresDBClient) StoreParticipants(matchID int, participants []*parsed
 }
 
 // GetMatchByID retrieves a match by ID including its League and Sport data
-func (p *PostgresDBClient) GetMatchByID(matchID int) (*parsed.Match, error) {
+func (p *PostgresDBClient) GetMatchByID(ctx context.Context, matchID int) (*parsed.Match, error) {
 	query := `
-		SELECT m.id, m.best_of_x, m.is_live, m.start_time, m.parent_id,
+		SELECT m.id, m.best_of_x, m.is_live, m.start_time, m.parent_id, m.status,
 			l.id, l.name, l.group_name, l.is_hidden, l.is_promoted, l.is_sticky, l.sequence,
 			s.id, s.name
 		FROM matches m
@@ -311,14 +358,14 @@ func (p *PostgresDBClient) GetMatchByID(matchID int) (*parsed.Match, error) {
 	var league parsed.League
 	var sport parsed.Sport
 
-	err := p.db.QueryRowContext(p.ctx, query, matchID).Scan(
-		&match.ID, &match.BestOfX, &match.IsLive, &match.StartTime, &match.ParentId,
+	err := p.conn(ctx).QueryRow(ctx, query, matchID).Scan(
+		&match.ID, &match.BestOfX, &match.IsLive, &match.StartTime, &match.ParentId, &match.Status,
 		&league.ID, &league.Name, &league.Group, &league.IsHidden, &league.IsPromoted, &league.IsSticky, &league.Sequence,
 		&sport.ID, &sport.Name,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == pgx.ErrNoRows {
 			return nil, nil // Match not found, return nil without error
 		}
 		return nil, err
@@ -336,7 +383,7 @@ func (p *PostgresDBClient) GetMatchByID(matchID int) (*parsed.Match, error) {
 		WHERE mp.match_id = $1
 	`
 
-	rows, err := p.db.QueryContext(p.ctx, participantsQuery, matchID)
+	rows, err := p.conn(ctx).Query(ctx, participantsQuery, matchID)
 	if err != nil {
 		return nil, err
 	}
@@ -365,21 +412,23 @@ func (p *PostgresDBClient) GetMatchByID(matchID int) (*parsed.Match, error) {
 }
 
 // StoreMatch сохраняет матч в базе данных
-func (p *PostgresDBClient) StoreMatch(patch *parsed.Match) error {
+func (p *PostgresDBClient) StoreMatch(ctx context.Context, patch *parsed.Match) (err error) {
 	if patch == nil {
 		return errors.New("match patch is nil")
 	}
+	ctx, span := startSpan(ctx, "StoreMatch", attribute.Int("pinnacle.match_id", patch.ID))
+	defer func() { tracing.End(span, err) }()
 
 	// Check if match exists
 	var exists bool
-	err := p.db.QueryRowContext(p.ctx, "SELECT EXISTS(SELECT 1 FROM matches WHERE id = $1)", patch.ID).Scan(&exists)
+	err = p.conn(ctx).QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM matches WHERE id = $1)", patch.ID).Scan(&exists)
 	if err != nil {
 		return err
 	}
 
 	if exists {
 		// For existing matches, apply RFC7396 merge patch
-		existing, err := p.GetMatchByID(patch.ID)
+		existing, err := p.GetMatchByID(ctx, patch.ID)
 		if err != nil {
 			p.logger.Error("Failed to get existing match for patching", patch.ID, err)
 			return err
@@ -388,7 +437,7 @@ func (p *PostgresDBClient) StoreMatch(patch *parsed.Match) error {
 		if existing == nil {
 			p.logger.Warn("Match exists in database but GetMatchByID returned nil", patch.ID)
 			// Fallback to treating it as a new match
-			return p.storeCompleteMatch(patch)
+			return p.storeCompleteMatch(ctx, patch)
 		}
 
 		// Apply merge patch
@@ -405,7 +454,7 @@ func (p *PostgresDBClient) StoreMatch(patch *parsed.Match) error {
 		}
 
 		// Continue with storage using the merged object
-		return p.storeCompleteMatch(mergedMatch)
+		return p.storeCompleteMatch(ctx, mergedMatch)
 	} else {
 		// For new matches, require complete data
 		if patch.League == nil {
@@ -417,12 +466,12 @@ func (p *PostgresDBClient) StoreMatch(patch *parsed.Match) error {
 		}
 
 		// Store as a new match
-		return p.storeCompleteMatch(patch)
+		return p.storeCompleteMatch(ctx, patch)
 	}
 }
 
 // storeCompleteMatch handles the actual storage of a complete match
-func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
+func (p *PostgresDBClient) storeCompleteMatch(ctx context.Context, match *parsed.Match) error {
 	if match == nil {
 		return errors.New("match is nil")
 	}
@@ -444,12 +493,12 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 		", Teams=", getTeamsString(match.Participants))
 
 	// Сохраняем лигу
-	if err := p.StoreLeague(match.League); err != nil {
+	if err := p.StoreLeague(ctx, match.League); err != nil {
 		p.logger.Error("Failed to store league for match", match.ID, err)
 		return err
 	}
 
-	tx, err := p.db.BeginTx(p.ctx, nil)
+	tx, err := p.conn(ctx).Begin(ctx)
 	if err != nil {
 		p.logger.Error("Failed to begin transaction for match", match.ID, err)
 		return err
@@ -457,13 +506,13 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 
 	defer func() {
 		if err != nil {
-			tx.Rollback()
+			tx.Rollback(ctx)
 		}
 	}()
 
 	// Проверяем, существует ли матч
 	var exists bool
-	err = tx.QueryRowContext(p.ctx, "SELECT EXISTS(SELECT 1 FROM matches WHERE id = $1)", match.ID).Scan(&exists)
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM matches WHERE id = $1)", match.ID).Scan(&exists)
 	if err != nil {
 		p.logger.Error("Failed to check if match exists", match.ID, err)
 		return err
@@ -478,11 +527,15 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 				is_live = $2, 
 				league_id = $3, 
 				start_time = $4, 
-				parent_id = $5
+				parent_id = $5,
+				status = COALESCE(NULLIF($7, ''), status),
+				last_seen_at = clock_timestamp(),
+				is_stale = false,
+				updated_at = CURRENT_TIMESTAMP
 			WHERE id = $6
 		`
-		_, err = tx.ExecContext(
-			p.ctx,
+		_, err = tx.Exec(
+			ctx,
 			query,
 			match.BestOfX,
 			match.IsLive,
@@ -490,6 +543,7 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 			match.StartTime,
 			match.ParentId,
 			match.ID,
+			match.Status,
 		)
 		if err != nil {
 			p.logger.Error("Failed to update match", match.ID, err)
@@ -499,11 +553,11 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 	} else {
 		// Создаем новый матч
 		query = `
-			INSERT INTO matches (id, best_of_x, is_live, league_id, start_time, parent_id)
-			VALUES ($1, $2, $3, $4, $5, $6)
+			INSERT INTO matches (id, best_of_x, is_live, league_id, start_time, parent_id, status, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'active'), clock_timestamp())
 		`
-		_, err = tx.ExecContext(
-			p.ctx,
+		_, err = tx.Exec(
+			ctx,
 			query,
 			match.ID,
 			match.BestOfX,
@@ -511,6 +565,7 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 			match.League.ID,
 			match.StartTime,
 			match.ParentId,
+			match.Status,
 		)
 		if err != nil {
 			p.logger.Error("Failed to insert new match", match.ID, err)
@@ -523,14 +578,14 @@ func (p *PostgresDBClient) storeCompleteMatch(match *parsed.Match) error {
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		p.logger.Error("Failed to commit transaction for match", match.ID, err)
 		return err
 	}
 
 	// Сохраняем участников
 	if match.Participants != nil && len(match.Participants) > 0 {
-		err = p.StoreParticipants(match.ID, match.Participants)
+		err = p.StoreParticipants(ctx, match.ID, match.Participants)
 		if err != nil {
 			p.logger.Error("Failed to store participants for match", match.ID, err)
 			return err
@@ -562,125 +617,294 @@ func getTeamsString(participants []*parsed.Participant) string {
 	return result
 }
 
-// StoreStraight сохраняет ставку в базе данных
-func (p *PostgresDBClient) StoreStraight(straight *parsed.Straight) error {
-	if straight == nil {
-		return errors.New("straight is nil")
-	}
+// MessageKey идентифицирует сообщение kafka для идемпотентной обработки
+type MessageKey struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+func (k MessageKey) String() string {
+	return fmt.Sprintf("%s/%d/%d", k.Topic, k.Partition, k.Offset)
+}
 
-	tx, err := p.db.BeginTx(p.ctx, nil)
+// IsProcessed проверяет, было ли сообщение уже успешно обработано
+func (p *PostgresDBClient) IsProcessed(ctx context.Context, key MessageKey) (bool, error) {
+	var exists bool
+	err := p.conn(ctx).QueryRow(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM processed_messages WHERE topic = $1 AND kafka_partition = $2 AND kafka_offset = $3)`,
+		key.Topic,
+		key.Partition,
+		key.Offset,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+// MarkProcessed запоминает сообщение как обработанное и удаляет счетчик его неудачных попыток,
+// повторная отметка не является ошибкой. Вызывается в WithinTx вместе с записью данных сообщения
+func (p *PostgresDBClient) MarkProcessed(ctx context.Context, key MessageKey) error {
+	_, err := p.conn(ctx).Exec(
+		ctx,
+		`WITH cleared AS (
+			DELETE FROM message_attempts WHERE topic = $1 AND kafka_partition = $2 AND kafka_offset = $3
+		)
+		INSERT INTO processed_messages (topic, kafka_partition, kafka_offset)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`,
+		key.Topic,
+		key.Partition,
+		key.Offset,
+	)
+
+	return err
+}
+
+// PruneProcessed удаляет отметки об обработке старше before. Отметка нужна, только пока kafka
+// может повторно доставить сообщение, то есть в пределах retention топика
+func (p *PostgresDBClient) PruneProcessed(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM processed_messages WHERE processed_at < $1`, before)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return tag.RowsAffected(), nil
+}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+// RecordAttempt увеличивает счетчик неудачных попыток обработки сообщения и возвращает его.
+// Счетчик хранится в базе, поэтому переживает перезапуск консьюмера и ребалансировку.
+// Пишется мимо транзакции WithinTx: откат записи сообщения не должен сбрасывать счетчик
+func (p *PostgresDBClient) RecordAttempt(ctx context.Context, key MessageKey, cause string) (int, error) {
+	var attempts int
+	err := p.db.QueryRow(
+		ctx,
+		`INSERT INTO message_attempts (topic, kafka_partition, kafka_offset, attempts, last_error)
+		VALUES ($1, $2, $3, 1, $4)
+		ON CONFLICT (topic, kafka_partition, kafka_offset) DO UPDATE SET
+			attempts = message_attempts.attempts + 1,
+			last_error = EXCLUDED.last_error,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING attempts`,
+		key.Topic,
+		key.Partition,
+		key.Offset,
+		cause,
+	).Scan(&attempts)
+
+	return attempts, err
+}
 
-	// Для каждой цены создаем отдельную запись odd
-	for _, price := range straight.Prices {
-		if price == nil {
-			continue
-		}
+// ClearAttempts удаляет счетчик попыток сообщения, например после отправки в DLQ
+func (p *PostgresDBClient) ClearAttempts(ctx context.Context, key MessageKey) error {
+	_, err := p.db.Exec(
+		ctx,
+		`DELETE FROM message_attempts WHERE topic = $1 AND kafka_partition = $2 AND kafka_offset = $3`,
+		key.Topic,
+		key.Partition,
+		key.Offset,
+	)
 
-		// Проверяем, существует ли odd
-		var oddID int
-		err = tx.QueryRowContext(
-			p.ctx,
-			`SELECT id FROM odds WHERE key = $1 AND matchup_id = $2 AND 
-			period = $3 AND side = $4 AND type = $5 AND designation = $6 AND 
-			(participant_id = $7 OR (participant_id IS NULL AND $7 IS NULL))`,
-			straight.Key,
-			straight.MatchupID,
-			straight.Period,
-			straight.Side,
-			straight.Type,
-			price.Designation,
-			price.ParticipantId,
-		).Scan(&oddID)
-
-		if err != nil && err != sql.ErrNoRows {
-			return err
-		}
+	return err
+}
 
-		if err == sql.ErrNoRows {
-			// Создаем новый odd
-			query := `
-				INSERT INTO odds (key, matchup_id, period, side, status, type, designation, points, participant_id, latest_price)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-				RETURNING id
-			`
-			err = tx.QueryRowContext(
-				p.ctx,
-				query,
-				straight.Key,
-				straight.MatchupID,
-				straight.Period,
-				straight.Side,
-				straight.Status,
-				straight.Type,
-				price.Designation,
-				price.Points,
-				price.ParticipantId,
-				price.Price,
-			).Scan(&oddID)
-		} else {
-			// Обновляем существующий odd
-			query := `
-				UPDATE odds SET 
-					period = $1, 
-					side = $2, 
-					status = $3, 
-					type = $4,
-					points = $5,
-					latest_price = $6
-				WHERE id = $7
-			`
-			_, err = tx.ExecContext(
-				p.ctx,
-				query,
-				straight.Period,
-				straight.Side,
-				straight.Status,
-				straight.Type,
-				price.Points,
-				price.Price,
-				oddID,
-			)
-		}
+// upsertOddsQuery одним запросом вставляет/обновляет odds из массивов и пишет историю цен.
+// Патчи BET_UPDATE содержат только изменившиеся поля: пустые строки и нулевая цена не затирают
+// сохраненные, а period и points передаются NULL, если их нет в патче, потому что 0 для них допустим.
+// Новые записи вставляются, уже существующие обновляются отдельным UPDATE, которому видно, какие поля
+// пришли. Запись, которую другая транзакция вставила между снимком запроса и INSERT, не попадет
+// ни в один из них, такие записи возвращаются в missed, и сообщение нужно обработать еще раз.
+// В историю попадают только отличающиеся от сохраненной цены, иначе каждый снимок дублировал бы ее
+const upsertOddsQuery = `
+	WITH input AS (
+		SELECT *
+		FROM unnest($1::text[], $2::int[], $3::int[], $4::text[], $5::text[], $6::text[], $7::text[], $8::float8[], $9::int[], $10::int[], $12::timestamptz[])
+			AS t(key, matchup_id, period, side, status, type, designation, points, participant_id, latest_price, captured_at)
+	),
+	previous AS (
+		SELECT o.id, o.latest_price
+		FROM odds o
+		JOIN input i ON i.key = o.key
+			AND i.matchup_id = o.matchup_id
+			AND i.designation = o.designation
+			AND i.participant_id IS NOT DISTINCT FROM o.participant_id
+	),
+	inserted AS (
+		INSERT INTO odds (key, matchup_id, period, side, status, type, designation, points, participant_id, latest_price, last_seen_at)
+		SELECT key, matchup_id, COALESCE(period, 0), side, status, type, designation, points, participant_id, NULLIF(latest_price, 0), clock_timestamp()
+		FROM input
+		ON CONFLICT ON CONSTRAINT odds_natural_key DO NOTHING
+		RETURNING id, key, matchup_id, designation, participant_id
+	),
+	updated AS (
+		UPDATE odds o SET
+			period = COALESCE(i.period, o.period),
+			side = COALESCE(NULLIF(i.side, ''), o.side),
+			status = COALESCE(NULLIF(i.status, ''), o.status),
+			type = COALESCE(NULLIF(i.type, ''), o.type),
+			points = COALESCE(i.points, o.points),
+			latest_price = COALESCE(NULLIF(i.latest_price, 0), o.latest_price),
+			last_seen_at = clock_timestamp(),
+			is_stale = false,
+			updated_at = CURRENT_TIMESTAMP
+		FROM input i
+		WHERE i.key = o.key
+			AND i.matchup_id = o.matchup_id
+			AND i.designation = o.designation
+			AND i.participant_id IS NOT DISTINCT FROM o.participant_id
+		RETURNING o.id, o.key, o.matchup_id, o.designation, o.participant_id
+	),
+	upserted AS (
+		SELECT * FROM inserted
+		UNION ALL
+		SELECT * FROM updated
+	),
+	priced AS (
+		INSERT INTO price_values (odd_id, value, message_key, captured_at, sent_at)
+		SELECT u.id, i.latest_price, NULLIF($11, ''), i.captured_at, $13
+		FROM upserted u
+		JOIN input i ON i.key = u.key
+			AND i.matchup_id = u.matchup_id
+			AND i.designation = u.designation
+			AND i.participant_id IS NOT DISTINCT FROM u.participant_id
+		LEFT JOIN previous pr ON pr.id = u.id
+		WHERE i.latest_price <> 0 AND pr.latest_price IS DISTINCT FROM i.latest_price
+		ON CONFLICT DO NOTHING
+	)
+	SELECT (SELECT count(*) FROM input) - (SELECT count(*) FROM upserted) AS missed
+`
 
-		if err != nil {
-			return err
+// ErrConcurrentOdds запись odds появилась в другой транзакции во время записи пачки.
+// Сообщение нужно обработать еще раз: при повторе запись уже будет видна и обновится
+var ErrConcurrentOdds = errors.New("odds were inserted concurrently, retry the batch")
+
+// nullTime превращает нулевое время в NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// oddKey естественный ключ записи odds
+type oddKey struct {
+	key           string
+	matchupID     int
+	designation   string
+	participantID int
+}
+
+// StoreStraights сохраняет все ставки сообщения одним запросом: на каждую цену приходится
+// одна запись odds и, если цена изменилась, одна запись price_values.
+// patch означает патчи BET_UPDATE, в которых есть только изменившиеся поля, а period и points
+// только с PeriodSet и PointsSet; без него ставки полные, как в BET_NEW и снимках.
+// messageKey защищает от повторной записи price_values при повторной доставке сообщения,
+// sentAt время отправки сообщения парсером, вместе с capturedAt рынка оно пишется в price_values
+// для отчета о задержке
+func (p *PostgresDBClient) StoreStraights(ctx context.Context, straights []*parsed.Straight, patch bool, messageKey string, sentAt time.Time) (err error) {
+	ctx, span := startSpan(ctx, "StoreStraights", attribute.Int("pinnacle.straights", len(straights)))
+	defer func() { tracing.End(span, err) }()
+
+	// Одна и та же запись odds не может обновиться дважды в одном запросе, поэтому
+	// несколько значений одного ключа сливаются, последнее пришедшее поле побеждает
+	index := make(map[oddKey]int, len(straights)*2)
+	var (
+		keys, sides, statuses, types, designations []string
+		matchupIDs, participantIDs, prices         []int32
+		periods                                    []*int32
+		points                                     []*float64
+		capturedAts                                []*time.Time
+	)
+
+	for _, straight := range straights {
+		if straight == nil {
+			continue
+		}
+		var period *int32
+		if !patch || straight.PeriodSet {
+			v := int32(straight.Period)
+			period = &v
 		}
 
-		// Добавляем новую запись цены
-		query := `
-			INSERT INTO price_values (odd_id, value)
-			VALUES ($1, $2)
-		`
-		_, err = tx.ExecContext(
-			p.ctx,
-			query,
-			oddID,
-			price.Price,
-		)
-		if err != nil {
-			return err
+		for _, price := range straight.Prices {
+			if price == nil {
+				continue
+			}
+			var pts *float64
+			if !patch || price.PointsSet {
+				v := price.Points
+				pts = &v
+			}
+
+			k := oddKey{straight.Key, straight.MatchupID, price.Designation, price.ParticipantId}
+			if i, ok := index[k]; ok {
+				sides[i] = latest(sides[i], straight.Side, "")
+				statuses[i] = latest(statuses[i], straight.Status, "")
+				types[i] = latest(types[i], straight.Type, "")
+				prices[i] = latest(prices[i], int32(price.Price), 0)
+				periods[i] = latest(periods[i], period, nil)
+				points[i] = latest(points[i], pts, nil)
+				capturedAts[i] = latest(capturedAts[i], nullTime(straight.CapturedAt), nil)
+				continue
+			}
+
+			index[k] = len(keys)
+			keys = append(keys, straight.Key)
+			matchupIDs = append(matchupIDs, int32(straight.MatchupID))
+			periods = append(periods, period)
+			sides = append(sides, straight.Side)
+			statuses = append(statuses, straight.Status)
+			types = append(types, straight.Type)
+			designations = append(designations, price.Designation)
+			points = append(points, pts)
+			participantIDs = append(participantIDs, int32(price.ParticipantId))
+			prices = append(prices, int32(price.Price))
+			capturedAts = append(capturedAts, nullTime(straight.CapturedAt))
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return err
-	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var missed int
+	err = p.conn(ctx).QueryRow(
+		ctx,
+		upsertOddsQuery,
+		keys,
+		matchupIDs,
+		periods,
+		sides,
+		statuses,
+		types,
+		designations,
+		points,
+		participantIDs,
+		prices,
+		messageKey,
+		capturedAts,
+		nullTime(sentAt),
+	).Scan(&missed)
+	if err == nil && missed > 0 {
+		err = fmt.Errorf("%w: %d of %d", ErrConcurrentOdds, missed, len(keys))
+	}
+
+	return err
+}
 
-	return nil
+// latest значение следующего элемента пачки, если оно задано, иначе текущее
+func latest[T comparable](current, next, absent T) T {
+	if next == absent {
+		return current
+	}
+	return next
 }
 
-// DeleteMatch marks a match as deleted by updating its status
-func (p *PostgresDBClient) DeleteMatch(id int) error {
-	tx, err := p.db.BeginTx(p.ctx, nil)
+// DeleteMatch marks a match as settled if it had already started or as removed otherwise
+func (p *PostgresDBClient) DeleteMatch(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "DeleteMatch", attribute.Int("pinnacle.match_id", id))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := p.conn(ctx).Begin(ctx)
 	if err != nil {
 		p.logger.Error("Failed to begin transaction for deleting match", id, err)
 		return err
@@ -688,17 +912,18 @@ func (p *PostgresDBClient) DeleteMatch(id int) error {
 
 	defer func() {
 		if err != nil {
-			tx.Rollback()
+			tx.Rollback(ctx)
 		}
 	}()
 
-	// Update match status to 'deleted'
+	// Update match status to 'settled' or 'removed'
 	query := `
 		UPDATE matches 
-		SET status = 'deleted', updated_at = CURRENT_TIMESTAMP 
+		SET status = CASE WHEN start_time <= CURRENT_TIMESTAMP THEN 'settled' ELSE 'removed' END,
+			updated_at = CURRENT_TIMESTAMP 
 		WHERE id = $1
 	`
-	_, err = tx.ExecContext(p.ctx, query, id)
+	_, err = tx.Exec(ctx, query, id)
 	if err != nil {
 		p.logger.Error("Failed to mark match as deleted", id, err)
 		return err
@@ -710,13 +935,13 @@ func (p *PostgresDBClient) DeleteMatch(id int) error {
 		SET status = 'deleted', updated_at = CURRENT_TIMESTAMP 
 		WHERE matchup_id = $1
 	`
-	_, err = tx.ExecContext(p.ctx, oddsQuery, id)
+	_, err = tx.Exec(ctx, oddsQuery, id)
 	if err != nil {
 		p.logger.Error("Failed to mark odds as deleted for match", id, err)
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		p.logger.Error("Failed to commit transaction for deleting match", id, err)
 		return err
 	}
@@ -725,10 +950,15 @@ func (p *PostgresDBClient) DeleteMatch(id int) error {
 	return nil
 }
 
+// Ping проверяет соединение с базой
+func (p *PostgresDBClient) Ping(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
 // Close закрывает соединение с базой данных
 func (p *PostgresDBClient) Close() error {
 	if p.db != nil {
-		return p.db.Close()
+		p.db.Close()
 	}
 	return nil
 }
@@ -0,0 +1,247 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+func TestProcessedMessages(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	key := MessageKey{Topic: "bookmaker_events", Partition: 2, Offset: 100}
+
+	processed, err := db.IsProcessed(ctx, key)
+	if err != nil || processed {
+		t.Fatalf("IsProcessed before mark = %v, %v", processed, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.MarkProcessed(ctx, key); err != nil {
+			t.Fatalf("MarkProcessed #%d: %v", i+1, err)
+		}
+	}
+	processed, err = db.IsProcessed(ctx, key)
+	if err != nil || !processed {
+		t.Fatalf("IsProcessed after mark = %v, %v", processed, err)
+	}
+
+	other := key
+	other.Offset++
+	if processed, _ := db.IsProcessed(ctx, other); processed {
+		t.Fatal("next offset is reported as processed")
+	}
+}
+
+// TestWithinTxRollback ошибка после записи откатывает и данные, и отметку об обработке,
+// а записи счетчика попыток остаются
+func TestWithinTxRollback(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	key := MessageKey{Topic: "bookmaker_events", Partition: 0, Offset: 5}
+	failure := errors.New("handler failed")
+
+	err := db.WithinTx(ctx, func(ctx context.Context) error {
+		if err := db.StoreStraights(ctx, moneyline(1, -110, 105), false, key.String(), time.Now()); err != nil {
+			return err
+		}
+		if err := db.MarkProcessed(ctx, key); err != nil {
+			return err
+		}
+		if _, err := db.RecordAttempt(ctx, key, "in tx"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithinTx = %v, want %v", err, failure)
+	}
+	if processed, _ := db.IsProcessed(ctx, key); processed {
+		t.Fatal("mark is kept after rollback")
+	}
+	if n := countPriceValues(t, db); n != 0 {
+		t.Fatalf("price_values after rollback = %d, want 0", n)
+	}
+	if got, _ := db.RecordAttempt(ctx, key, "again"); got != 2 {
+		t.Fatalf("attempts = %d, want the attempt inside the rolled back tx kept", got)
+	}
+
+	err = db.WithinTx(ctx, func(ctx context.Context) error {
+		if err := db.StoreStraights(ctx, moneyline(1, -110, 105), false, key.String(), time.Now()); err != nil {
+			return err
+		}
+		return db.MarkProcessed(ctx, key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed, _ := db.IsProcessed(ctx, key); !processed {
+		t.Fatal("message is not marked after commit")
+	}
+	if n := countPriceValues(t, db); n != 2 {
+		t.Fatalf("price_values after commit = %d, want 2", n)
+	}
+}
+
+func TestPruneProcessed(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	old := MessageKey{Topic: "bookmaker_events", Partition: 0, Offset: 1}
+	recent := MessageKey{Topic: "bookmaker_events", Partition: 0, Offset: 2}
+	for _, key := range []MessageKey{old, recent} {
+		if err := db.MarkProcessed(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.db.Exec(ctx, `UPDATE processed_messages SET processed_at = now() - interval '8 days' WHERE kafka_offset = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := db.PruneProcessed(ctx, time.Now().Add(-7*24*time.Hour))
+	if err != nil || pruned != 1 {
+		t.Fatalf("PruneProcessed = %d, %v, want 1", pruned, err)
+	}
+	if processed, _ := db.IsProcessed(ctx, old); processed {
+		t.Fatal("old mark is not pruned")
+	}
+	if processed, _ := db.IsProcessed(ctx, recent); !processed {
+		t.Fatal("recent mark is pruned")
+	}
+}
+
+func moneyline(matchID, home, away int) []*parsed.Straight {
+	return []*parsed.Straight{{
+		Key:       "s;0;m",
+		MatchupID: matchID,
+		Period:    0,
+		Status:    "open",
+		Type:      "moneyline",
+		Prices: []*parsed.Price{
+			{Designation: "home", Price: home},
+			{Designation: "away", Price: away},
+		},
+		CapturedAt: time.Now(),
+	}}
+}
+
+func countPriceValues(t *testing.T, db *PostgresDBClient) int {
+	t.Helper()
+	var n int
+	if err := db.db.QueryRow(context.Background(), "SELECT count(*) FROM price_values").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestStoreStraightsRedelivery повторная доставка сообщения, которое упало до MarkProcessed,
+// не дублирует историю цен
+func TestStoreStraightsRedelivery(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	if err := db.StoreStraights(ctx, moneyline(1, -110, 105), false, "t/0/1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StoreStraights(ctx, moneyline(1, -110, 105), false, "t/0/1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countPriceValues(t, db); n != 2 {
+		t.Fatalf("price_values after redelivery = %d, want 2", n)
+	}
+
+	// история пишется по ключу сообщения, даже если сохраненная цена успела измениться
+	if err := db.StoreStraights(ctx, moneyline(1, -120, 115), false, "t/0/2", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StoreStraights(ctx, moneyline(1, -110, 105), false, "t/0/1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countPriceValues(t, db); n != 4 {
+		t.Fatalf("price_values after replay of an older message = %d, want 4", n)
+	}
+
+	var odds int
+	if err := db.db.QueryRow(ctx, "SELECT count(*) FROM odds").Scan(&odds); err != nil {
+		t.Fatal(err)
+	}
+	if odds != 2 {
+		t.Fatalf("odds = %d, want 2", odds)
+	}
+}
+
+func TestMessageAttempts(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	key := MessageKey{Topic: "bookmaker_events", Partition: 0, Offset: 5}
+
+	for want := 1; want <= 3; want++ {
+		got, err := db.RecordAttempt(ctx, key, "deadlock detected")
+		if err != nil || got != want {
+			t.Fatalf("RecordAttempt = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	if err := db.MarkProcessed(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.RecordAttempt(ctx, key, "again"); err != nil || got != 1 {
+		t.Fatalf("RecordAttempt after MarkProcessed = %d, %v, want 1", got, err)
+	}
+	if err := db.ClearAttempts(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.RecordAttempt(ctx, key, "again"); got != 1 {
+		t.Fatalf("RecordAttempt after ClearAttempts = %d, want 1", got)
+	}
+}
+
+func storedSpread(t *testing.T, db *PostgresDBClient) (period int, points *float64, price int) {
+	t.Helper()
+	err := db.db.QueryRow(context.Background(),
+		"SELECT period, points, latest_price FROM odds WHERE matchup_id = 2 AND designation = 'home'",
+	).Scan(&period, &points, &price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return period, points, price
+}
+
+// TestStoreStraightsPatchZero патч переводит фору и период в 0, а патч без этих полей их не трогает
+func TestStoreStraightsPatchZero(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	full := []*parsed.Straight{{
+		Key: "s;1;s;-1.5", MatchupID: 2, Period: 1, Status: "open", Type: "spread",
+		Prices: []*parsed.Price{{Designation: "home", Price: -110, Points: -1.5}, {Designation: "away", Price: 105, Points: 1.5}},
+	}}
+	if err := db.StoreStraights(ctx, full, false, "t/0/1", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	toZero := []*parsed.Straight{{
+		Key: "s;1;s;-1.5", MatchupID: 2, Period: 0, PeriodSet: true,
+		Prices: []*parsed.Price{{Designation: "home", Points: 0, PointsSet: true}},
+	}}
+	if err := db.StoreStraights(ctx, toZero, true, "t/0/2", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	period, points, price := storedSpread(t, db)
+	if period != 0 || points == nil || *points != 0 || price != -110 {
+		t.Fatalf("after zero patch period = %d, points = %v, price = %d, want 0, 0, -110", period, points, price)
+	}
+
+	priceOnly := []*parsed.Straight{{
+		Key: "s;1;s;-1.5", MatchupID: 2,
+		Prices: []*parsed.Price{{Designation: "home", Price: -120}},
+	}}
+	if err := db.StoreStraights(ctx, priceOnly, true, "t/0/3", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	period, points, price = storedSpread(t, db)
+	if period != 0 || points == nil || *points != 0 || price != -120 {
+		t.Fatalf("after price patch period = %d, points = %v, price = %d, want 0, 0, -120", period, points, price)
+	}
+}
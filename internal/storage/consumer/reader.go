@@ -0,0 +1,256 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+// Page параметры постраничной выборки
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// MatchFilter условия выборки матчей, нулевые значения не ограничивают выборку
+type MatchFilter struct {
+	LeagueID int
+	IsLive   *bool
+	From     time.Time
+	To       time.Time
+	Status   string
+}
+
+// PricePoint одно значение из истории цен рынка
+type PricePoint struct {
+	Designation   string    `json:"designation,omitempty"`
+	ParticipantId int       `json:"participantId,omitempty"`
+	Points        float64   `json:"points,omitempty"`
+	Price         int       `json:"price"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// ListSports возвращает виды спорта по возрастанию id
+func (p *PostgresDBClient) ListSports(ctx context.Context, page Page) ([]*parsed.Sport, error) {
+	rows, err := p.db.Query(ctx, `SELECT id, name FROM sports ORDER BY id LIMIT $1 OFFSET $2`, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*parsed.Sport, error) {
+		var sport parsed.Sport
+		err := row.Scan(&sport.ID, &sport.Name)
+		return &sport, err
+	})
+}
+
+// ListLeagues возвращает лиги, при sportID != 0 только лиги этого вида спорта
+func (p *PostgresDBClient) ListLeagues(ctx context.Context, sportID int, page Page) ([]*parsed.League, error) {
+	query := `
+		SELECT l.id, l.name, COALESCE(l.group_name, ''), l.is_hidden, l.is_promoted, l.is_sticky, l.sequence, s.id, s.name
+		FROM leagues l
+		JOIN sports s ON l.sport_id = s.id
+		WHERE $1 = 0 OR l.sport_id = $1
+		ORDER BY l.sequence, l.id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := p.db.Query(ctx, query, sportID, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*parsed.League, error) {
+		league := parsed.League{Sport: &parsed.Sport{}}
+		err := row.Scan(&league.ID, &league.Name, &league.Group, &league.IsHidden, &league.IsPromoted,
+			&league.IsSticky, &league.Sequence, &league.Sport.ID, &league.Sport.Name)
+		return &league, err
+	})
+}
+
+// ListMatches возвращает матчи с лигой, видом спорта и участниками, отсортированные по времени начала
+func (p *PostgresDBClient) ListMatches(ctx context.Context, filter MatchFilter, page Page) ([]*parsed.Match, error) {
+	conditions := make([]string, 0, 5)
+	args := make([]any, 0, 7)
+	addCondition := func(cond string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if filter.LeagueID != 0 {
+		addCondition("m.league_id = ?", filter.LeagueID)
+	}
+	if filter.IsLive != nil {
+		addCondition("m.is_live = ?", *filter.IsLive)
+	}
+	if !filter.From.IsZero() {
+		addCondition("m.start_time >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("m.start_time < ?", filter.To)
+	}
+	if filter.Status != "" {
+		addCondition("m.status = ?", filter.Status)
+	}
+
+	query := `
+		SELECT m.id, m.best_of_x, m.is_live, m.start_time, COALESCE(m.parent_id, 0), m.status,
+			l.id, l.name, COALESCE(l.group_name, ''), l.is_hidden, l.is_promoted, l.is_sticky, l.sequence,
+			s.id, s.name
+		FROM matches m
+		JOIN leagues l ON m.league_id = l.id
+		JOIN sports s ON l.sport_id = s.id
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, page.Limit, page.Offset)
+	query += " ORDER BY m.start_time, m.id LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := pgx.CollectRows(rows, scanMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, p.attachParticipants(ctx, matches)
+}
+
+// GetMatch возвращает матч по id или nil, если его нет
+func (p *PostgresDBClient) GetMatch(ctx context.Context, matchID int) (*parsed.Match, error) {
+	query := `
+		SELECT m.id, m.best_of_x, m.is_live, m.start_time, COALESCE(m.parent_id, 0), m.status,
+			l.id, l.name, COALESCE(l.group_name, ''), l.is_hidden, l.is_promoted, l.is_sticky, l.sequence,
+			s.id, s.name
+		FROM matches m
+		JOIN leagues l ON m.league_id = l.id
+		JOIN sports s ON l.sport_id = s.id
+		WHERE m.id = $1
+	`
+	rows, err := p.db.Query(ctx, query, matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	match, err := pgx.CollectOneRow(rows, scanMatch)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return match, p.attachParticipants(ctx, []*parsed.Match{match})
+}
+
+func scanMatch(row pgx.CollectableRow) (*parsed.Match, error) {
+	match := parsed.Match{League: &parsed.League{Sport: &parsed.Sport{}}}
+	err := row.Scan(&match.ID, &match.BestOfX, &match.IsLive, &match.StartTime, &match.ParentId, &match.Status,
+		&match.League.ID, &match.League.Name, &match.League.Group, &match.League.IsHidden,
+		&match.League.IsPromoted, &match.League.IsSticky, &match.League.Sequence,
+		&match.League.Sport.ID, &match.League.Sport.Name)
+	return &match, err
+}
+
+// attachParticipants одним запросом подгружает участников для страницы матчей
+func (p *PostgresDBClient) attachParticipants(ctx context.Context, matches []*parsed.Match) error {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*parsed.Match, len(matches))
+	ids := make([]int32, 0, len(matches))
+	for _, m := range matches {
+		byID[m.ID] = m
+		ids = append(ids, int32(m.ID))
+	}
+
+	rows, err := p.db.Query(ctx, `
+		SELECT mp.match_id, mp.team_id, mp.alignment, t.name
+		FROM match_participants mp
+		JOIN teams t ON mp.team_id = t.id
+		WHERE mp.match_id = ANY($1)
+		ORDER BY mp.match_id, mp.id
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var matchID int
+		var participant parsed.Participant
+		if err := rows.Scan(&matchID, &participant.Id, &participant.Alignment, &participant.Name); err != nil {
+			return err
+		}
+		byID[matchID].Participants = append(byID[matchID].Participants, &participant)
+	}
+
+	return rows.Err()
+}
+
+// ListMarkets собирает рынки матча из odds: одна запись odds соответствует одной цене рынка
+func (p *PostgresDBClient) ListMarkets(ctx context.Context, matchID int, page Page) ([]*parsed.Straight, error) {
+	rows, err := p.db.Query(ctx, `
+		WITH markets AS (
+			SELECT DISTINCT key FROM odds WHERE matchup_id = $1 ORDER BY key LIMIT $2 OFFSET $3
+		)
+		SELECT o.key, o.matchup_id, o.period, COALESCE(o.side, ''), o.status, o.type,
+			o.designation, COALESCE(o.points, 0), COALESCE(o.participant_id, 0), COALESCE(o.latest_price, 0)
+		FROM odds o
+		JOIN markets USING (key)
+		WHERE o.matchup_id = $1
+		ORDER BY o.key, o.id
+	`, matchID, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markets := make([]*parsed.Straight, 0)
+	for rows.Next() {
+		var s parsed.Straight
+		var price parsed.Price
+		if err := rows.Scan(&s.Key, &s.MatchupID, &s.Period, &s.Side, &s.Status, &s.Type,
+			&price.Designation, &price.Points, &price.ParticipantId, &price.Price); err != nil {
+			return nil, err
+		}
+
+		if n := len(markets); n == 0 || markets[n-1].Key != s.Key {
+			markets = append(markets, &s)
+		}
+		last := markets[len(markets)-1]
+		last.Prices = append(last.Prices, &price)
+	}
+
+	return markets, rows.Err()
+}
+
+// PriceHistory возвращает историю цен рынка матча от новых к старым
+func (p *PostgresDBClient) PriceHistory(ctx context.Context, matchID int, key string, page Page) ([]*PricePoint, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT o.designation, COALESCE(o.participant_id, 0), COALESCE(o.points, 0), pv.value, pv.created_at
+		FROM price_values pv
+		JOIN odds o ON pv.odd_id = o.id
+		WHERE o.matchup_id = $1 AND o.key = $2
+		ORDER BY pv.created_at DESC, pv.id DESC
+		LIMIT $3 OFFSET $4
+	`, matchID, key, page.Limit, page.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*PricePoint, error) {
+		var point PricePoint
+		err := row.Scan(&point.Designation, &point.ParticipantId, &point.Points, &point.Price, &point.CreatedAt)
+		return &point, err
+	})
+}
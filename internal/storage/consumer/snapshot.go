@@ -0,0 +1,119 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+)
+
+// SnapshotChunk часть периодического снимка состояния
+type SnapshotChunk struct {
+	SnapshotID string
+	EventType  int
+	TakenAt    time.Time
+	Chunk      int
+	Chunks     int
+}
+
+// staleQueries помечают устаревшими строки, которые не подтвердил ни снимок, ни дельта
+// с момента начала приема снимка. Удаленные матчи (settled, removed) и ставки (deleted) не трогаются
+var staleQueries = map[int]string{
+	constants.MATCH_SNAPSHOT: `
+		UPDATE matches SET is_stale = true
+		WHERE NOT is_stale AND status NOT IN ('settled', 'removed') AND last_seen_at < $1
+	`,
+	constants.BET_SNAPSHOT: `
+		UPDATE odds SET is_stale = true
+		WHERE NOT is_stale AND status <> 'deleted' AND last_seen_at < $1
+	`,
+}
+
+// snapshotRetention сколько хранить сведения о принятых снимках
+const snapshotRetention = 24 * time.Hour
+
+// BeginSnapshotChunk регистрирует снимок до записи данных части. Время первой регистрации
+// становится границей: все, что подтверждено позже, считается актуальным. Сообщение пишется
+// одной транзакцией, поэтому граница и last_seen_at берутся по clock_timestamp, а не по началу транзакции
+func (p *PostgresDBClient) BeginSnapshotChunk(ctx context.Context, c SnapshotChunk) error {
+	_, err := p.conn(ctx).Exec(ctx, `
+		INSERT INTO snapshots (snapshot_id, event_type, taken_at, chunks, started_at)
+		VALUES ($1, $2, $3, $4, clock_timestamp())
+		ON CONFLICT DO NOTHING
+	`, c.SnapshotID, c.EventType, c.TakenAt, c.Chunks)
+
+	return err
+}
+
+// CompleteSnapshotChunk отмечает часть снимка принятой. Когда приняты все части, помечает
+// устаревшими строки, которых не было в снимке, и возвращает их количество
+func (p *PostgresDBClient) CompleteSnapshotChunk(ctx context.Context, c SnapshotChunk) (stale int64, completed bool, err error) {
+	staleQuery, ok := staleQueries[c.EventType]
+	if !ok {
+		return 0, false, fmt.Errorf("unexpected snapshot event type %d", c.EventType)
+	}
+
+	err = pgx.BeginFunc(ctx, p.conn(ctx), func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO snapshot_chunks (snapshot_id, event_type, chunk) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, c.SnapshotID, c.EventType, c.Chunk); err != nil {
+			return err
+		}
+
+		// блокировка строки снимка не дает двум частям одновременно завершить его
+		var chunks, received int
+		var startedAt time.Time
+		var completedAt *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT chunks, started_at, completed_at FROM snapshots
+			WHERE snapshot_id = $1 AND event_type = $2
+			FOR UPDATE
+		`, c.SnapshotID, c.EventType).Scan(&chunks, &startedAt, &completedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.New("snapshot " + c.SnapshotID + " is not registered")
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+			SELECT count(*) FROM snapshot_chunks WHERE snapshot_id = $1 AND event_type = $2
+		`, c.SnapshotID, c.EventType).Scan(&received); err != nil {
+			return err
+		}
+		if completedAt != nil || received < chunks {
+			return nil
+		}
+
+		tag, err := tx.Exec(ctx, staleQuery, startedAt)
+		if err != nil {
+			return err
+		}
+		stale, completed = tag.RowsAffected(), true
+
+		if _, err := tx.Exec(ctx, `
+			UPDATE snapshots SET completed_at = CURRENT_TIMESTAMP WHERE snapshot_id = $1 AND event_type = $2
+		`, c.SnapshotID, c.EventType); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM snapshots WHERE started_at < $1`, time.Now().Add(-snapshotRetention))
+		return err
+	})
+
+	return stale, completed, err
+}
+
+// ReviveMatches возвращает в активные удаленные матчи, которые снова есть в снимке. Снимки
+// со статусом матча выставляют его сами, это нужно для снимков без статуса
+func (p *PostgresDBClient) ReviveMatches(ctx context.Context, ids []int32) error {
+	_, err := p.conn(ctx).Exec(ctx, `
+		UPDATE matches SET status = 'active', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ANY($1) AND status IN ('settled', 'removed')
+	`, ids)
+
+	return err
+}
@@ -0,0 +1,46 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+)
+
+// TestSnapshotSkipsDeletedMatches снимок помечает устаревшими только живые матчи,
+// settled и removed остаются как есть
+func TestSnapshotSkipsDeletedMatches(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	_, err := db.db.Exec(ctx, `
+		INSERT INTO sports (id, name) VALUES (1, 'Soccer');
+		INSERT INTO leagues (id, sport_id, name) VALUES (1, 1, 'League');
+		INSERT INTO matches (id, league_id, start_time, status, last_seen_at) VALUES
+			(1, 1, now(), 'active', now() - interval '1 hour'),
+			(2, 1, now(), 'missing', now() - interval '1 hour'),
+			(3, 1, now(), 'settled', now() - interval '1 hour'),
+			(4, 1, now(), 'removed', now() - interval '1 hour')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := SnapshotChunk{SnapshotID: "s1", EventType: constants.MATCH_SNAPSHOT, TakenAt: time.Now(), Chunks: 1}
+	if err := db.BeginSnapshotChunk(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	stale, completed, err := db.CompleteSnapshotChunk(ctx, c)
+	if err != nil || !completed || stale != 2 {
+		t.Fatalf("stale = %d, completed = %v, %v, want 2 stale", stale, completed, err)
+	}
+
+	for id, want := range map[int]bool{1: true, 2: true, 3: false, 4: false} {
+		var got bool
+		if err := db.db.QueryRow(ctx, "SELECT is_stale FROM matches WHERE id = $1", id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("match %d is_stale = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package consumer
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pararti/pinnacle-parser/internal/storage/consumer/pgtest"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+// newEmptyDB клиент к отдельной пустой базе на время теста, см. pgtest.NewDatabase
+func newEmptyDB(t *testing.T) *PostgresDBClient {
+	t.Helper()
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	db, err := NewPostgresDBClient(pgtest.NewDatabase(t), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestDB пустая база со всеми миграциями
+func newTestDB(t *testing.T) *PostgresDBClient {
+	t.Helper()
+	db := newEmptyDB(t)
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer замеряет время запросов пула и ведет span на каждый запрос. Метка statement первое
+// слово SQL в нижнем регистре (select, insert, update, ...), чтобы число серий не зависело
+// от текста запросов
+type queryTracer struct{}
+
+type queryStartCtx struct{}
+
+type queryStart struct {
+	at        time.Time
+	statement string
+	span      trace.Span
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement := statementKind(data.SQL)
+	ctx, span := tracing.Tracer().Start(ctx, "postgres."+statement, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", statement),
+			attribute.String("db.query.text", data.SQL),
+		))
+	return context.WithValue(ctx, queryStartCtx{}, queryStart{at: time.Now(), statement: statement, span: span})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartCtx{}).(queryStart)
+	if !ok {
+		return
+	}
+	metrics.DBQuerySeconds.WithLabelValues(start.statement, metrics.Result(data.Err)).
+		Observe(time.Since(start.at).Seconds())
+
+	start.span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	tracing.Fail(start.span, data.Err)
+	start.span.End()
+}
+
+// startSpan начинает span вызова PostgresDBClient, запросы внутри него становятся дочерними
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "PostgresDBClient."+method, trace.WithAttributes(attrs...))
+}
+
+func statementKind(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	kind := strings.ToLower(fields[0])
+	switch kind {
+	case "select", "insert", "update", "delete", "with", "begin", "commit", "rollback":
+		return kind
+	default:
+		return "other"
+	}
+}
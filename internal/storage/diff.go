@@ -0,0 +1,182 @@
+package storage
+
+import "github.com/pararti/pinnacle-parser/internal/models/parsed"
+
+// mergeMatch переносит в сохраненный матч отличающиеся поля пришедшего и отмечает их измененными,
+// чтобы GetUpdate вернул патч. Возвращает true, если что-то изменилось
+func mergeMatch(stored, match *parsed.Match) bool {
+	changed := false
+	if stored.BestOfX != match.BestOfX {
+		stored.BestOfX = match.BestOfX
+		stored.MarkChanged("bestOfX")
+		changed = true
+	}
+	if stored.IsLive != match.IsLive {
+		stored.IsLive = match.IsLive
+		stored.MarkChanged("isLive")
+		changed = true
+	}
+	if stored.League.Group != match.League.Group {
+		stored.League.Group = match.League.Group
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("group")
+		changed = true
+	}
+	if stored.League.ID != match.League.ID {
+		stored.League.ID = match.League.ID
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("id")
+		changed = true
+	}
+	if stored.League.IsHidden != match.League.IsHidden {
+		stored.League.IsHidden = match.League.IsHidden
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("isHidden")
+		changed = true
+	}
+	if stored.League.IsPromoted != match.League.IsPromoted {
+		stored.League.IsPromoted = match.League.IsPromoted
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("isPromoted")
+		changed = true
+	}
+	if stored.League.IsSticky != match.League.IsSticky {
+		stored.League.IsSticky = match.League.IsSticky
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("isSticky")
+		changed = true
+	}
+	if stored.League.Name != match.League.Name {
+		stored.League.Name = match.League.Name
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("name")
+		changed = true
+	}
+	if stored.League.Sequence != match.League.Sequence {
+		stored.League.Sequence = match.League.Sequence
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("sequence")
+		changed = true
+	}
+	if stored.League.Sport.ID != match.League.Sport.ID {
+		stored.League.Sport.ID = match.League.Sport.ID
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("sport")
+		stored.League.Sport.MarkChanged("id")
+		changed = true
+	}
+	if stored.League.Sport.Name != match.League.Sport.Name {
+		stored.League.Sport.Name = match.League.Sport.Name
+		stored.MarkChanged("league")
+		stored.League.MarkChanged("sport")
+		stored.League.Sport.MarkChanged("name")
+		changed = true
+	}
+	for i, participant := range match.Participants {
+		if i >= len(stored.Participants) {
+			continue
+		}
+		if stored.Participants[i].Alignment != participant.Alignment {
+			stored.Participants[i].Alignment = participant.Alignment
+			stored.MarkChanged("participants")
+			stored.Participants[i].MarkChanged("alignment")
+			changed = true
+		}
+		if stored.Participants[i].Name != participant.Name {
+			stored.Participants[i].Name = participant.Name
+			stored.MarkChanged("participants")
+			stored.Participants[i].MarkChanged("name")
+			changed = true
+		}
+	}
+	// время из файла состояния может отличаться от пришедшего только зоной, поэтому Equal
+	if !stored.StartTime.Equal(match.StartTime) {
+		stored.StartTime = match.StartTime
+		stored.MarkChanged("startTime")
+		changed = true
+	}
+
+	return changed
+}
+
+// mergeBet то же для рынка. Цены сравниваются по позиции, лишние пришедшие цены пропускаются
+func mergeBet(stored, bet *parsed.Straight) bool {
+	changed := false
+	if stored.Period != bet.Period {
+		stored.Period = bet.Period
+		stored.MarkChanged("period")
+		changed = true
+	}
+	if stored.Side != bet.Side {
+		stored.Side = bet.Side
+		stored.MarkChanged("side")
+		changed = true
+	}
+	if stored.Status != bet.Status {
+		stored.Status = bet.Status
+		stored.MarkChanged("status")
+		changed = true
+	}
+	if stored.Type != bet.Type {
+		stored.Type = bet.Type
+		stored.MarkChanged("type")
+		changed = true
+	}
+
+	for i, price := range bet.Prices {
+		if i >= len(stored.Prices) {
+			continue
+		}
+		if stored.Prices[i].Designation != price.Designation {
+			stored.Prices[i].Designation = price.Designation
+			stored.MarkChanged("prices")
+			stored.Prices[i].MarkChanged("designation")
+			changed = true
+		}
+		if stored.Prices[i].Price != price.Price {
+			stored.Prices[i].Price = price.Price
+			stored.MarkChanged("prices")
+			stored.Prices[i].MarkChanged("price")
+			changed = true
+		}
+		if stored.Prices[i].Points != price.Points {
+			stored.Prices[i].Points = price.Points
+			stored.MarkChanged("prices")
+			stored.Prices[i].MarkChanged("points")
+			changed = true
+		}
+	}
+
+	// задержка считается от захвата, который принес последнее изменение
+	if changed && !bet.CapturedAt.IsZero() {
+		stored.CapturedAt = bet.CapturedAt
+	}
+
+	return changed
+}
+
+// resetMatchChanges снимает отметки об изменениях после того, как патч забран, чтобы следующий
+// патч содержал только новые изменения
+func resetMatchChanges(m *parsed.Match) {
+	m.Changes = nil
+	if m.League != nil {
+		m.League.Changes = nil
+		if m.League.Sport != nil {
+			m.League.Sport.Changes = nil
+		}
+	}
+	for _, p := range m.Participants {
+		if p != nil {
+			p.Changes = nil
+		}
+	}
+}
+
+func resetBetChanges(b *parsed.Straight) {
+	b.Changes = nil
+	for _, p := range b.Prices {
+		if p != nil {
+			p.Changes = nil
+		}
+	}
+}
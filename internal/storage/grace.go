@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"iter"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+// DeleteGrace сколько матч может отсутствовать в данных своей группы, прежде чем его удалят:
+// грейс истекает, когда набралось Misses пакетов группы подряд или прошло After с первого пропуска,
+// что наступит раньше. Так матч не висит в missing, если группа приходит редко или часто. Нулевое
+// значение отключает свое условие, оба нулевых удаляют матч сразу. Пока грейс не истек, матч
+// остается в состоянии со статусом missing
+type DeleteGrace struct {
+	Misses int
+	After  time.Duration
+}
+
+func (g DeleteGrace) expired(misses int, since, now time.Time) bool {
+	if g.Misses <= 0 && g.After <= 0 {
+		return true
+	}
+	return (g.Misses > 0 && misses >= g.Misses) || (g.After > 0 && now.Sub(since) >= g.After)
+}
+
+// missingMatch матч, которого нет в последних данных его группы
+type missingMatch struct {
+	since  time.Time
+	misses int
+}
+
+// marketsStatus статус присутствующего матча по его рынкам: suspended, если рынки есть,
+// но ни один не открыт
+func marketsStatus(bets iter.Seq[*parsed.Straight]) string {
+	seen := false
+	for bet := range bets {
+		if bet.Status == parsed.BET_STATUS_OPEN {
+			return parsed.MATCH_STATUS_ACTIVE
+		}
+		seen = true
+	}
+	if seen {
+		return parsed.MATCH_STATUS_SUSPENDED
+	}
+	return parsed.MATCH_STATUS_ACTIVE
+}
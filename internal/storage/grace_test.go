@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+)
+
+func TestDeleteGraceExpired(t *testing.T) {
+	since := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name    string
+		grace   DeleteGrace
+		misses  int
+		elapsed time.Duration
+		want    bool
+	}{
+		{"neither reached", DeleteGrace{Misses: 3, After: 30 * time.Second}, 2, 10 * time.Second, false},
+		{"misses reached first", DeleteGrace{Misses: 3, After: 30 * time.Second}, 3, time.Second, true},
+		{"time reached first", DeleteGrace{Misses: 3, After: 30 * time.Second}, 1, 30 * time.Second, true},
+		{"only misses", DeleteGrace{Misses: 3}, 2, time.Hour, false},
+		{"only time", DeleteGrace{After: 30 * time.Second}, 100, time.Second, false},
+		{"zero deletes at once", DeleteGrace{}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grace.expired(tt.misses, since, since.Add(tt.elapsed)); got != tt.want {
+				t.Fatalf("expired(%d, %s) = %v, want %v", tt.misses, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+// missingStorage хранилище, где матч 1 пропал из пакета своей группы один раз
+func missingStorage(t *testing.T, grace DeleteGrace) *MapStorage {
+	t.Helper()
+	ctx := context.Background()
+	s := NewMapStorage(grace)
+	s.SetMatches(ctx, group(false, 1, 2))
+	s.GetNewMatches()
+
+	s.SetMatches(ctx, group(false, 2))
+	updated, _ := s.GetUpdatedMatches()
+	if len(updated) != 1 || updated[0].ID != 1 || updated[0].Status != parsed.MATCH_STATUS_MISSING {
+		t.Fatalf("MATCH_UPDATE = %+v, want match 1 missing", updated)
+	}
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 0 {
+		t.Fatalf("MATCH_DELETE = %v before the grace expired", deleted)
+	}
+	return s
+}
+
+func TestMissingRestored(t *testing.T) {
+	s := missingStorage(t, DeleteGrace{Misses: 3, After: time.Hour})
+	s.SetMatches(context.Background(), group(false, 1, 2))
+
+	restored, _ := s.GetRestoredMatches()
+	if len(restored) != 1 || restored[0].ID != 1 || restored[0].Status == parsed.MATCH_STATUS_MISSING {
+		t.Fatalf("MATCH_RESTORED = %+v, want match 1 no longer missing", restored)
+	}
+	if n, _ := s.ExpireMissing(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Fatalf("restored match expired: %d", n)
+	}
+}
+
+func TestMissingDeletedByCount(t *testing.T) {
+	s := missingStorage(t, DeleteGrace{Misses: 3, After: time.Hour})
+	ctx := context.Background()
+	s.SetMatches(ctx, group(false, 2))
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 0 {
+		t.Fatalf("MATCH_DELETE = %v after 2 misses", deleted)
+	}
+	s.SetMatches(ctx, group(false, 2))
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatalf("MATCH_DELETE = %v after 3 misses, want [1]", deleted)
+	}
+	if _, ok := s.Match(1); ok {
+		t.Fatal("deleted match is still stored")
+	}
+}
+
+// TestMissingDeletedByTime группа больше не приходит, матч удаляет ExpireMissing
+func TestMissingDeletedByTime(t *testing.T) {
+	s := missingStorage(t, DeleteGrace{Misses: 100, After: 30 * time.Second})
+	now := time.Now()
+
+	if n, _ := s.ExpireMissing(now.Add(10 * time.Second)); n != 0 {
+		t.Fatalf("expired %d matches before deleteGrace", n)
+	}
+	if n, _ := s.ExpireMissing(now.Add(31 * time.Second)); n != 1 {
+		t.Fatalf("expired %d matches after deleteGrace, want 1", n)
+	}
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatalf("MATCH_DELETE = %v, want [1]", deleted)
+	}
+	if _, ok := s.Match(2); !ok {
+		t.Fatal("present match is deleted")
+	}
+}
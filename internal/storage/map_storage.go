@@ -1,334 +1,488 @@
 package storage
 
 import (
-	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"context"
+	"maps"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// MapStorage хранит состояние в памяти процесса и переживает рестарт только через файл состояния.
+// При падении процесса теряются отличия, которые отправитель забрал, но kafka не подтвердила
+// до последнего сохранения; изменения после сохранения после рестарта находятся заново
 type MapStorage struct {
-	MatchUpdChan chan int
-	MatchNewChan chan int
-	BetUpdChan   chan int
-	BetNewChan   chan int
-	mu           sync.RWMutex
-	MatchDelChan chan []int
-	Matches      map[int]*parsed.Match
-	Bets         map[int]map[string]*parsed.Straight
+	mu            sync.RWMutex
+	Matches       map[int]*parsed.Match
+	Bets          map[int]map[string]*parsed.Straight
+	lastMatchesAt time.Time
+	lastBetsAt    time.Time
+	// unconfirmed матчи из файла состояния, которые источник еще не подтвердил после рестарта
+	unconfirmed map[int]struct{}
+	// missing матчи, пропавшие из данных своей группы и ждущие удаления
+	missing map[int]*missingMatch
+	grace   DeleteGrace
+
+	// отличия, которые еще не забрал отправитель. Сами данные лежат в Matches и Bets,
+	// поэтому повторное изменение записи только обновляет ее
+	newMatches     pending[int, struct{}]
+	updatedMatches pending[int, struct{}]
+	deletedMatches pending[int, struct{}]
+	// restoredMatches вернувшиеся в пределах грейса матчи, уходят целиком как MATCH_RESTORED
+	restoredMatches pending[int, struct{}]
+	newBets         pending[betRef, struct{}]
+	updatedBets     pending[betRef, struct{}]
+	notify          notifiers
 }
 
-func NewMapStorage() *MapStorage {
+func NewMapStorage(grace DeleteGrace) *MapStorage {
 	m := make(map[int]*parsed.Match, 64)
 	b := make(map[int]map[string]*parsed.Straight, 64)
-	muc := make(chan int, 1)
-	mnc := make(chan int, 1)
-	mdc := make(chan []int, 8)
-	buc := make(chan int, 1)
-	bnc := make(chan int, 1)
 
-	return &MapStorage{Matches: m, Bets: b, MatchUpdChan: muc, MatchNewChan: mnc, MatchDelChan: mdc, BetUpdChan: buc, BetNewChan: bnc}
+	return &MapStorage{Matches: m, Bets: b, unconfirmed: make(map[int]struct{}),
+		missing:         make(map[int]*missingMatch),
+		grace:           grace,
+		newMatches:      newPending[int, struct{}](),
+		updatedMatches:  newPending[int, struct{}](),
+		deletedMatches:  newPending[int, struct{}](),
+		restoredMatches: newPending[int, struct{}](),
+		newBets:         newPending[betRef, struct{}](),
+		updatedBets:     newPending[betRef, struct{}](),
+		notify:          newNotifiers(),
+	}
+}
+
+func (m *MapStorage) Notifications() abstruct.Notifications {
+	return m.notify.notifications()
 }
 
-func (m *MapStorage) SetMatches(matches []*parsed.Match) {
+func (m *MapStorage) SetMatches(ctx context.Context, matches []*parsed.Match) {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.SetMatches", trace.WithAttributes(attribute.Int("pinnacle.matches", len(matches))))
+	defer span.End()
+
 	m.mu.Lock()
+	c := newChange(ctx)
+	now := c.at
+	m.lastMatchesAt = now
 	ids := make(map[int]struct{}, len(matches))
-	upd := 0
-	newy := 0
+	upd := false
+	newy := false
+	back := false
 	if matches[0].ParentId == 0 {
 		matches[0].ParentId = matches[0].ID
 	}
 	parentId := matches[0].ParentId
 	for _, match := range matches {
 		ids[match.ID] = struct{}{}
+		delete(m.unconfirmed, match.ID)
 		if match.ParentId == 0 {
 			match.ParentId = parentId
 		}
-		_, ok := m.Matches[match.ID]
+		stored, ok := m.Matches[match.ID]
 		if !ok {
-			match.StatusFlag = parsed.STATUS_CREATED
+			match.Status = marketsStatus(maps.Values(m.Bets[match.ID]))
+			match.UpdatedAt = now
 			m.Matches[match.ID] = match
-			newy++
+			// матч удален и вернулся до отправки: MATCH_DELETE и MATCH_NEW уходят из разных горутин
+			// и могут прийти в любом порядке, поэтому остается только MATCH_NEW
+			m.deletedMatches.remove(match.ID)
+			m.newMatches.put(match.ID, struct{}{}, c)
+			newy = true
 			continue
 		}
 
 		//проверяем изменения и записываем их в мапу
-		var status int8 = parsed.STATUS_NOT_CHANGE
-		if m.Matches[match.ID].BestOfX != match.BestOfX {
-			m.Matches[match.ID].BestOfX = match.BestOfX
-			m.Matches[match.ID].MarkChanged("bestOfX")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].IsLive != match.IsLive {
-			m.Matches[match.ID].IsLive = match.IsLive
-			m.Matches[match.ID].MarkChanged("isLive")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].League.Group != match.League.Group {
-			m.Matches[match.ID].League.Group = match.League.Group
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("group")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].League.ID != match.League.ID {
-			m.Matches[match.ID].League.ID = match.League.ID
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("id")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].League.IsHidden != match.League.IsHidden {
-			m.Matches[match.ID].League.IsHidden = match.League.IsHidden
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("isHidden")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].League.IsPromoted != match.League.IsPromoted {
-			m.Matches[match.ID].League.IsPromoted = match.League.IsPromoted
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("isPromoted")
-			status = parsed.STATUS_UPDATED
-		}
-		if m.Matches[match.ID].League.IsSticky != match.League.IsSticky {
-			m.Matches[match.ID].League.IsSticky = match.League.IsSticky
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("isSticky")
-			status = parsed.STATUS_UPDATED
+		changed := mergeMatch(stored, match)
+		if _, gone := m.missing[match.ID]; gone || stored.Status == parsed.MATCH_STATUS_MISSING {
+			delete(m.missing, match.ID)
+			stored.Status = marketsStatus(maps.Values(m.Bets[match.ID]))
+			stored.UpdatedAt = now
+			// вернувшийся матч уходит целиком, поэтому отдельный патч не нужен
+			if !m.newMatches.has(match.ID) {
+				m.updatedMatches.remove(match.ID)
+				m.restoredMatches.put(match.ID, struct{}{}, c)
+				back = true
+			}
+			continue
 		}
-		if m.Matches[match.ID].League.Name != match.League.Name {
-			m.Matches[match.ID].League.Name = match.League.Name
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("name")
-			status = parsed.STATUS_UPDATED
+		if changed {
+			stored.UpdatedAt = now
+			upd = m.queueUpdate(match.ID, c) || upd
 		}
-		if m.Matches[match.ID].League.Sequence != match.League.Sequence {
-			m.Matches[match.ID].League.Sequence = match.League.Sequence
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("sequence")
-			status = parsed.STATUS_UPDATED
+	}
+
+	del := false
+	for id, stored := range m.Matches {
+		if stored.ParentId != parentId {
+			continue
 		}
-		if m.Matches[match.ID].League.Sport.ID != match.League.Sport.ID {
-			m.Matches[match.ID].League.Sport.ID = match.League.Sport.ID
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("sport")
-			m.Matches[match.ID].League.Sport.MarkChanged("id")
-			status = parsed.STATUS_UPDATED
+		if _, ok := ids[id]; ok {
+			continue
 		}
-		if m.Matches[match.ID].League.Sport.Name != match.League.Sport.Name {
-			m.Matches[match.ID].League.Sport.Name = match.League.Sport.Name
-			m.Matches[match.ID].MarkChanged("league")
-			m.Matches[match.ID].League.MarkChanged("sport")
-			m.Matches[match.ID].League.Sport.MarkChanged("name")
-			status = parsed.STATUS_UPDATED
+
+		gone, ok := m.missing[id]
+		if !ok {
+			gone = &missingMatch{since: now}
+			m.missing[id] = gone
 		}
-		for i, participant := range match.Participants {
-			if m.Matches[match.ID].Participants[i].Alignment != participant.Alignment {
-				m.Matches[match.ID].Participants[i].Alignment = participant.Alignment
-				m.Matches[match.ID].MarkChanged("participants")
-				m.Matches[match.ID].Participants[i].MarkChanged("alignment")
-				status = parsed.STATUS_UPDATED
-			}
-			if m.Matches[match.ID].Participants[i].Name != participant.Name {
-				m.Matches[match.ID].Participants[i].Name = participant.Name
-				m.Matches[match.ID].MarkChanged("participants")
-				m.Matches[match.ID].Participants[i].MarkChanged("name")
-				status = parsed.STATUS_UPDATED
-			}
+		gone.misses++
+		if m.grace.expired(gone.misses, gone.since, now) {
+			m.deleteMatch(id, c)
+			del = true
+			continue
 		}
-		if m.Matches[match.ID].StartTime != match.StartTime {
-			m.Matches[match.ID].StartTime = match.StartTime
-			m.Matches[match.ID].MarkChanged("startTime")
-			status = parsed.STATUS_UPDATED
+		if stored.Status != parsed.MATCH_STATUS_MISSING {
+			stored.Status = parsed.MATCH_STATUS_MISSING
+			stored.MarkChanged("status")
+			stored.UpdatedAt = now
+			upd = m.queueUpdate(id, c) || upd
 		}
+	}
 
-		m.Matches[match.ID].StatusFlag = status
+	m.mu.Unlock()
 
-		if status == parsed.STATUS_UPDATED {
-			upd++
-		}
+	if newy {
+		m.notify.matchNew.wake()
+	}
+	if upd {
+		m.notify.matchUpd.wake()
 	}
+	if back {
+		m.notify.matchRestored.wake()
+	}
+	if del {
+		m.notify.matchDel.wake()
+	}
+}
 
-	m.mu.Unlock()
+// queueUpdate ставит матч в очередь MATCH_UPDATE и возвращает true, если отправителя нужно
+// разбудить. Еще не забранный новый или вернувшийся матч уйдет целиком с последними значениями
+func (m *MapStorage) queueUpdate(id int, c change) bool {
+	if m.newMatches.has(id) || m.restoredMatches.has(id) {
+		return false
+	}
+	m.updatedMatches.put(id, struct{}{}, c)
+	return true
+}
 
-	if newy > 0 {
-		m.MatchNewChan <- newy
+// refreshStatus пересчитывает статус присутствующего матча по его рынкам, вызывается под m.mu
+func (m *MapStorage) refreshStatus(matchID int, c change) bool {
+	match, ok := m.Matches[matchID]
+	if !ok || match.Status == parsed.MATCH_STATUS_MISSING {
+		return false
+	}
+	status := marketsStatus(maps.Values(m.Bets[matchID]))
+	if match.Status == status {
+		return false
 	}
+	match.Status = status
+	match.MarkChanged("status")
+	match.UpdatedAt = c.at
+	return m.queueUpdate(matchID, c)
+}
+
+// deleteMatch удаляет матч с рынками и ставит его в очередь MATCH_DELETE, вызывается под m.mu
+func (m *MapStorage) deleteMatch(id int, c change) {
+	delete(m.Matches, id)
+	delete(m.Bets, id)
+	delete(m.unconfirmed, id)
+	delete(m.missing, id)
+	m.newMatches.remove(id)
+	m.updatedMatches.remove(id)
+	m.restoredMatches.remove(id)
+	m.deletedMatches.put(id, struct{}{}, c)
+}
 
-	if upd > 0 {
-		m.MatchUpdChan <- upd
+// ExpireMissing удаляет пропавшие матчи, грейс которых истек по времени. В SetMatches грейс
+// проверяется только с пакетом той же группы, а группа может перестать приходить совсем
+func (m *MapStorage) ExpireMissing(now time.Time) (int, error) {
+	m.mu.Lock()
+	c := change{at: now}
+	deleted := 0
+	for id, gone := range m.missing {
+		if m.grace.expired(gone.misses, gone.since, now) {
+			m.deleteMatch(id, c)
+			deleted++
+		}
 	}
+	m.mu.Unlock()
 
+	if deleted > 0 {
+		m.notify.matchDel.wake()
+	}
+	return deleted, nil
+}
+
+func (m *MapStorage) GetUpdatedMatches() ([]*parsed.Match, tracing.Origin) {
 	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	deletedMatchs := make([]int, 0, 51)
-	for i := range m.Matches {
-		if m.Matches[i].ParentId != parentId {
-			continue
-		}
-		if _, ok := ids[i]; ok {
+	ids, origin := m.updatedMatches.take()
+	updatedMatches := make([]*parsed.Match, 0, len(ids))
+	for id := range ids {
+		match, ok := m.Matches[id]
+		if !ok {
 			continue
 		}
-		deletedMatchs = append(deletedMatchs, i)
-		delete(m.Matches, i)
+		updatedMatches = append(updatedMatches, match.GetUpdate())
+		resetMatchChanges(match)
 	}
 
-	if len(deletedMatchs) > 0 {
-		for i := range deletedMatchs {
-			delete(m.Bets, i)
-		}
-	}
+	metrics.ObserveDiff(constants.MATCH_UPDATE, len(updatedMatches))
+	return updatedMatches, origin
+}
 
-	m.mu.Unlock()
-	if len(deletedMatchs) > 0 {
-		m.MatchDelChan <- deletedMatchs
+func (m *MapStorage) GetNewMatches() ([]*parsed.Match, tracing.Origin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids, origin := m.newMatches.take()
+	newMatches := make([]*parsed.Match, 0, len(ids))
+	for id := range ids {
+		match, ok := m.Matches[id]
+		if !ok {
+			continue
+		}
+		resetMatchChanges(match)
+		newMatches = append(newMatches, match.Clone())
 	}
 
+	metrics.ObserveDiff(constants.MATCH_NEW, len(newMatches))
+	return newMatches, origin
 }
 
-func (m *MapStorage) GetUpdatedMatches(n int) []*parsed.Match {
+func (m *MapStorage) GetRestoredMatches() ([]*parsed.Match, tracing.Origin) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	updatedMatches := make([]*parsed.Match, 0, n)
-	for id := range m.Matches {
-		if m.Matches[id].StatusFlag == parsed.STATUS_UPDATED {
-			m.Matches[id].StatusFlag = parsed.STATUS_NOT_CHANGE
-			updatedMatches = append(updatedMatches, m.Matches[id].GetUpdate())
+
+	ids, origin := m.restoredMatches.take()
+	restoredMatches := make([]*parsed.Match, 0, len(ids))
+	for id := range ids {
+		match, ok := m.Matches[id]
+		if !ok {
+			continue
 		}
+		resetMatchChanges(match)
+		restoredMatches = append(restoredMatches, match.Clone())
 	}
 
-	return updatedMatches
+	metrics.ObserveDiff(constants.MATCH_RESTORED, len(restoredMatches))
+	return restoredMatches, origin
 }
 
-func (m *MapStorage) GetNewMatches(n int) []*parsed.Match {
+func (m *MapStorage) GetDeletedMatches() ([]int, tracing.Origin) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	newMatches := make([]*parsed.Match, 0, n)
-
-	for id := range m.Matches {
-		if m.Matches[id].StatusFlag == parsed.STATUS_CREATED {
-			m.Matches[id].StatusFlag = parsed.STATUS_NOT_CHANGE
-			newMatches = append(newMatches, m.Matches[id])
-		}
+	ids, origin := m.deletedMatches.take()
+	deleted := make([]int, 0, len(ids))
+	for id := range ids {
+		deleted = append(deleted, id)
 	}
+	sort.Ints(deleted)
 
-	return newMatches
+	metrics.ObserveDiff(constants.MATCH_DELETE, len(deleted))
+	return deleted, origin
 }
 
-func (m *MapStorage) GetUpdatedBets(n int) []*parsed.Straight {
+func (m *MapStorage) GetUpdatedBets() ([]*parsed.Straight, tracing.Origin) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	updatedBets := make([]*parsed.Straight, 0, n)
-	for matchId := range m.Bets {
-		for betKey := range m.Bets[matchId] {
-			if m.Bets[matchId][betKey].StatusFlag == parsed.STATUS_UPDATED {
-				data := m.Bets[matchId][betKey].GetUpdate()
-				m.Bets[matchId][betKey].StatusFlag = parsed.STATUS_NOT_CHANGE
-				updatedBets = append(updatedBets, data)
-			}
+
+	refs, origin := m.updatedBets.take()
+	updatedBets := make([]*parsed.Straight, 0, len(refs))
+	for ref := range refs {
+		bet, ok := m.Bets[ref.matchID][ref.key]
+		if !ok {
+			continue
 		}
+		if data := bet.GetUpdate(); data != nil {
+			updatedBets = append(updatedBets, data)
+		}
+		resetBetChanges(bet)
 	}
 
-	return updatedBets
+	metrics.ObserveDiff(constants.BET_UPDATE, len(updatedBets))
+	return updatedBets, origin
 }
 
-func (m *MapStorage) GetNewBets(n int) []*parsed.Straight {
+func (m *MapStorage) GetNewBets() ([]*parsed.Straight, tracing.Origin) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	newBets := make([]*parsed.Straight, 0, n)
 
-	for matchId := range m.Bets {
-		for betKey := range m.Bets[matchId] {
-			if m.Bets[matchId][betKey].StatusFlag == parsed.STATUS_CREATED {
-				m.Bets[matchId][betKey].StatusFlag = parsed.STATUS_NOT_CHANGE
-				newBets = append(newBets, m.Bets[matchId][betKey])
-			}
+	refs, origin := m.newBets.take()
+	newBets := make([]*parsed.Straight, 0, len(refs))
+	for ref := range refs {
+		bet, ok := m.Bets[ref.matchID][ref.key]
+		if !ok {
+			continue
 		}
+		resetBetChanges(bet)
+		newBets = append(newBets, bet.Clone())
 	}
 
-	return newBets
+	metrics.ObserveDiff(constants.BET_NEW, len(newBets))
+	return newBets, origin
 }
 
-func (m *MapStorage) SetBets(bets map[int][]*parsed.Straight) {
+func (m *MapStorage) SetBets(ctx context.Context, bets map[int][]*parsed.Straight) {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.SetBets", trace.WithAttributes(attribute.Int("pinnacle.matches", len(bets))))
+	defer span.End()
+
 	m.mu.Lock()
-	upd := 0
-	newy := 0
+	c := newChange(ctx)
+	now := c.at
+	m.lastBetsAt = now
+	upd := false
+	newy := false
 	for matchId := range bets {
 		for _, bet := range bets[matchId] {
-			_, ok := m.Bets[bet.MatchupID]
-			if !ok {
+			ref := betRef{matchID: bet.MatchupID, key: bet.Key}
+			if _, ok := m.Bets[bet.MatchupID]; !ok {
 				m.Bets[bet.MatchupID] = make(map[string]*parsed.Straight)
-				bet.StatusFlag = parsed.STATUS_CREATED
-				m.Bets[bet.MatchupID][bet.Key] = bet
-				newy++
-				continue
 			}
 
-			if _, ok2 := m.Bets[bet.MatchupID][bet.Key]; !ok2 {
-				bet.StatusFlag = parsed.STATUS_CREATED
+			stored, ok := m.Bets[bet.MatchupID][bet.Key]
+			if !ok {
+				bet.UpdatedAt = now
 				m.Bets[bet.MatchupID][bet.Key] = bet
-				newy++
+				m.newBets.put(ref, struct{}{}, c)
+				newy = true
 				continue
 			}
 
 			//проверяем изменения и записываем их в мапу
-			var status int8 = parsed.STATUS_NOT_CHANGE
-			if m.Bets[bet.MatchupID][bet.Key].Period != bet.Period {
-				m.Bets[bet.MatchupID][bet.Key].Period = bet.Period
-				m.Bets[bet.MatchupID][bet.Key].MarkChanged("period")
-				status = parsed.STATUS_UPDATED
-			}
-			if m.Bets[bet.MatchupID][bet.Key].Side != bet.Side {
-				m.Bets[bet.MatchupID][bet.Key].Side = bet.Side
-				m.Bets[bet.MatchupID][bet.Key].MarkChanged("side")
-				status = parsed.STATUS_UPDATED
-			}
-			if m.Bets[bet.MatchupID][bet.Key].Status != bet.Status {
-				m.Bets[bet.MatchupID][bet.Key].Status = bet.Status
-				m.Bets[bet.MatchupID][bet.Key].MarkChanged("status")
-				status = parsed.STATUS_UPDATED
-			}
-			if m.Bets[bet.MatchupID][bet.Key].Type != bet.Type {
-				m.Bets[bet.MatchupID][bet.Key].Type = bet.Type
-				m.Bets[bet.MatchupID][bet.Key].MarkChanged("type")
-				status = parsed.STATUS_UPDATED
-			}
-			for i, price := range bet.Prices {
-				if i >= len(m.Bets[bet.MatchupID][bet.Key].Prices) {
-					continue
-				}
-				if m.Bets[bet.MatchupID][bet.Key].Prices[i].Designation != price.Designation {
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].Designation = price.Designation
-					m.Bets[bet.MatchupID][bet.Key].MarkChanged("prices")
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].MarkChanged("designation")
-					status = parsed.STATUS_UPDATED
-				}
-				if m.Bets[bet.MatchupID][bet.Key].Prices[i].Price != price.Price {
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].Price = price.Price
-					m.Bets[bet.MatchupID][bet.Key].MarkChanged("prices")
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].MarkChanged("price")
-					status = parsed.STATUS_UPDATED
-				}
-				if m.Bets[bet.MatchupID][bet.Key].Prices[i].Points != price.Points {
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].Points = price.Points
-					m.Bets[bet.MatchupID][bet.Key].MarkChanged("prices")
-					m.Bets[bet.MatchupID][bet.Key].Prices[i].MarkChanged("points")
-					status = parsed.STATUS_UPDATED
-				}
-
-				m.Bets[bet.MatchupID][bet.Key].StatusFlag = status
-
-				if status == parsed.STATUS_UPDATED {
-					upd++
+			if mergeBet(stored, bet) {
+				stored.UpdatedAt = now
+				if !m.newBets.has(ref) {
+					m.updatedBets.put(ref, struct{}{}, c)
+					upd = true
 				}
 			}
 		}
 	}
 
+	status := false
+	for matchId := range bets {
+		status = m.refreshStatus(matchId, c) || status
+	}
+
 	m.mu.Unlock()
 
-	if newy > 0 {
-		m.BetNewChan <- newy
+	if newy {
+		m.notify.betNew.wake()
 	}
+	if upd {
+		m.notify.betUpd.wake()
+	}
+	if status {
+		m.notify.matchUpd.wake()
+	}
+}
 
-	if upd > 0 {
-		m.BetUpdChan <- upd
+// Requeue возвращает в очередь отличия события, которое kafka не приняла, см. abstruct.Requeuer
+func (m *MapStorage) Requeue(eventType int, data any) {
+	var p pendingRefs
+	switch items := data.(type) {
+	case []*parsed.Match:
+		for _, match := range items {
+			p.Matches = append(p.Matches, match.ID)
+		}
+	case []*parsed.Straight:
+		for _, bet := range items {
+			p.Bets = append(p.Bets, pendingBet{MatchID: bet.MatchupID, Key: bet.Key})
+		}
+	case []int:
+		if eventType == constants.MATCH_DELETE {
+			p.Deleted = items
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requeue(p, newChange(context.Background()))
+}
+
+// MatchList возвращает копии всех матчей, отсортированные по времени начала
+func (m *MapStorage) MatchList() []*parsed.Match {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches := make([]*parsed.Match, 0, len(m.Matches))
+	for _, match := range m.Matches {
+		matches = append(matches, match.Clone())
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].StartTime.Equal(matches[j].StartTime) {
+			return matches[i].ID < matches[j].ID
+		}
+		return matches[i].StartTime.Before(matches[j].StartTime)
+	})
+
+	return matches
+}
+
+// Match возвращает копию матча
+func (m *MapStorage) Match(id int) (*parsed.Match, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	match, ok := m.Matches[id]
+	return match.Clone(), ok
+}
+
+// Bet возвращает копию рынка матча
+func (m *MapStorage) Bet(matchID int, key string) (*parsed.Straight, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bet, ok := m.Bets[matchID][key]
+	return bet.Clone(), ok
+}
+
+// MatchBets возвращает копии рынков матча, отсортированные по ключу
+func (m *MapStorage) MatchBets(matchID int) []*parsed.Straight {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bets := make([]*parsed.Straight, 0, len(m.Bets[matchID]))
+	for _, bet := range m.Bets[matchID] {
+		bets = append(bets, bet.Clone())
 	}
+	sort.Slice(bets, func(i, j int) bool { return bets[i].Key < bets[j].Key })
+
+	return bets
+}
+
+func (m *MapStorage) Stats() abstruct.StateStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	markets := 0
+	for _, bets := range m.Bets {
+		markets += len(bets)
+	}
+
+	backlog := abstruct.Backlog{
+		NewMatches:      len(m.newMatches.items),
+		UpdatedMatches:  len(m.updatedMatches.items),
+		DeletedMatches:  len(m.deletedMatches.items),
+		RestoredMatches: len(m.restoredMatches.items),
+		NewBets:         len(m.newBets.items),
+		UpdatedBets:     len(m.updatedBets.items),
+		Coalesced:       m.notify.coalesced(),
+		OldestAt: oldest(m.newMatches.since, m.updatedMatches.since, m.deletedMatches.since,
+			m.restoredMatches.since, m.newBets.since, m.updatedBets.since),
+	}
+
+	return abstruct.StateStats{Matches: len(m.Matches), Markets: markets, Missing: len(m.missing),
+		LastMatchesAt: m.lastMatchesAt, LastBetsAt: m.lastBetsAt, Backlog: backlog}
 }
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+)
+
+// TestDeleteThenNewCoalesces матч, удаленный и вернувшийся до отправки, уходит только как MATCH_NEW
+func TestDeleteThenNewCoalesces(t *testing.T) {
+	ctx := context.Background()
+	s := NewMapStorage(DeleteGrace{})
+	s.SetMatches(ctx, group(false, 1, 2))
+	s.GetNewMatches()
+
+	s.SetMatches(ctx, group(false, 2))
+	s.SetMatches(ctx, group(false, 1, 2))
+
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 0 {
+		t.Fatalf("MATCH_DELETE = %v, want none", deleted)
+	}
+	if matches, _ := s.GetNewMatches(); len(matches) != 1 || matches[0].ID != 1 {
+		t.Fatalf("MATCH_NEW = %+v, want match 1", matches)
+	}
+}
+
+// TestRequeue отличия, не принятые kafka, уходят заново целиком, удаления — если матча все еще нет
+func TestRequeue(t *testing.T) {
+	ctx := context.Background()
+	s := NewMapStorage(DeleteGrace{})
+	s.SetMatches(ctx, group(false, 1, 2))
+	bet := parsed.GenerateExampleStraight(1)
+	s.SetBets(ctx, map[int][]*parsed.Straight{1: {bet}})
+	s.GetNewMatches()
+	s.GetNewBets()
+
+	s.SetMatches(ctx, group(true, 1, 2))
+	updated, _ := s.GetUpdatedMatches()
+	s.Requeue(constants.MATCH_UPDATE, updated)
+	if matches, _ := s.GetNewMatches(); len(matches) != 2 || !matches[0].IsLive {
+		t.Fatalf("requeued matches = %+v, want both live matches in full", matches)
+	}
+	if matches, _ := s.GetUpdatedMatches(); len(matches) != 0 {
+		t.Fatalf("requeued matches are also sent as MATCH_UPDATE: %+v", matches)
+	}
+
+	s.Requeue(constants.BET_UPDATE, []*parsed.Straight{{MatchupID: 1, Key: bet.Key}})
+	if bets, _ := s.GetNewBets(); len(bets) != 1 || len(bets[0].Prices) == 0 {
+		t.Fatalf("requeued bets = %+v, want the whole market", bets)
+	}
+
+	s.Requeue(constants.MATCH_DELETE, []int{1, 3})
+	if deleted, _ := s.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 3 {
+		t.Fatalf("requeued deletes = %v, want only absent match 3", deleted)
+	}
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// notifier неблокирующее пробуждение отправителя. Сигнал с емкостью 1: если отправитель еще
+// не забрал предыдущий, новый сливается с ним, а изменения ждут в pending
+type notifier struct {
+	ch        chan struct{}
+	coalesced atomic.Uint64
+}
+
+func newNotifier() *notifier {
+	return &notifier{ch: make(chan struct{}, 1)}
+}
+
+func (n *notifier) wake() {
+	select {
+	case n.ch <- struct{}{}:
+	default:
+		n.coalesced.Add(1)
+	}
+}
+
+// pending изменения одного вида, которые еще не забрал отправитель. Повторное изменение
+// той же записи не создает новую, поэтому очередь ограничена размером состояния
+type pending[K comparable, V any] struct {
+	items map[K]V
+	// since время самого старого незабранного изменения
+	since time.Time
+	// origin захваты, в которых замечены незабранные изменения
+	origin tracing.Origin
+}
+
+// change когда и в каком span захвата замечено изменение
+type change struct {
+	at   time.Time
+	span trace.SpanContext
+}
+
+func newChange(ctx context.Context) change {
+	return change{at: time.Now(), span: trace.SpanContextFromContext(ctx)}
+}
+
+func newPending[K comparable, V any]() pending[K, V] {
+	return pending[K, V]{items: make(map[K]V)}
+}
+
+func (p *pending[K, V]) put(k K, v V, c change) {
+	if len(p.items) == 0 {
+		p.since = c.at
+	}
+	p.items[k] = v
+	p.origin.Add(c.span)
+}
+
+func (p *pending[K, V]) get(k K) (V, bool) {
+	v, ok := p.items[k]
+	return v, ok
+}
+
+func (p *pending[K, V]) has(k K) bool {
+	_, ok := p.items[k]
+	return ok
+}
+
+func (p *pending[K, V]) remove(k K) {
+	delete(p.items, k)
+}
+
+// take забирает все изменения вместе с захватами, из которых они пришли
+func (p *pending[K, V]) take() (map[K]V, tracing.Origin) {
+	items, origin := p.items, p.origin
+	p.items = make(map[K]V)
+	p.since = time.Time{}
+	p.origin = tracing.Origin{}
+	return items, origin
+}
+
+// betRef ключ рынка в pending
+type betRef struct {
+	matchID int
+	key     string
+}
+
+// notifiers сигналы по видам изменений, общие для реализаций хранилища
+type notifiers struct {
+	matchNew      *notifier
+	matchUpd      *notifier
+	matchDel      *notifier
+	matchRestored *notifier
+	betNew        *notifier
+	betUpd        *notifier
+}
+
+func newNotifiers() notifiers {
+	return notifiers{
+		matchNew:      newNotifier(),
+		matchUpd:      newNotifier(),
+		matchDel:      newNotifier(),
+		matchRestored: newNotifier(),
+		betNew:        newNotifier(),
+		betUpd:        newNotifier(),
+	}
+}
+
+func (n notifiers) notifications() abstruct.Notifications {
+	return abstruct.Notifications{
+		MatchNew:      n.matchNew.ch,
+		MatchUpd:      n.matchUpd.ch,
+		MatchDel:      n.matchDel.ch,
+		MatchRestored: n.matchRestored.ch,
+		BetNew:        n.betNew.ch,
+		BetUpd:        n.betUpd.ch,
+	}
+}
+
+func (n notifiers) coalesced() uint64 {
+	return n.matchNew.coalesced.Load() + n.matchUpd.coalesced.Load() + n.matchDel.coalesced.Load() +
+		n.matchRestored.coalesced.Load() + n.betNew.coalesced.Load() + n.betUpd.coalesced.Load()
+}
+
+// oldest возвращает самое раннее из ненулевых времен
+func oldest(times ...time.Time) time.Time {
+	var min time.Time
+	for _, t := range times {
+		if t.IsZero() {
+			continue
+		}
+		if min.IsZero() || t.Before(min) {
+			min = t
+		}
+	}
+	return min
+}
@@ -0,0 +1,1234 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/metrics"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/tracing"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redisTxRetries сколько раз повторять транзакцию, если ключи изменил другой экземпляр парсера
+const redisTxRetries = 20
+
+// outboxTimeout через сколько неподтвержденная запись outbox отправляется повторно
+const outboxTimeout = time.Minute
+
+// outboxBatch сколько записей outbox разбирать за один проход
+const outboxBatch = 500
+
+// claimOutbox забирает запись outbox для повторной отправки: удаляет ее и добавляет заново,
+// чтобы отсчет outboxTimeout начался сначала. Возвращает nil, если запись уже забрал другой экземпляр
+var claimOutbox = redis.NewScript(`
+if redis.call('XDEL', KEYS[1], ARGV[1]) == 0 then
+	return false
+end
+return redis.call('XADD', KEYS[1], '*', 'refs', ARGV[2])
+`)
+
+// RedisStorage хранит состояние в Redis, поэтому несколько экземпляров парсера видят одно состояние.
+// Отличия считаются в оптимистичной транзакции (WATCH/MULTI), так что каждое изменение источника
+// замечает ровно один экземпляр, а после падения экземпляра остальные продолжают с того же
+// состояния. Повторно, уже целиком, уходят только отличия из неподтвержденных записей outbox.
+//
+// В той же транзакции в поток <prefix>:outbox пишутся ссылки на найденные отличия. Запись
+// удаляется после доставки событий в kafka (abstruct.Outbox), а записи старше outboxTimeout,
+// например после падения экземпляра между транзакцией и отправкой, RunOutbox любого экземпляра
+// отправляет повторно с текущими значениями. Пакет, транзакция которого не прошла, повторяется
+// при следующем вызове SetMatches или SetBets, пока его не заменят более новые данные.
+//
+// Ключи: <prefix>:matches (hash id -> матч), <prefix>:parent:<id> (set матчей группы),
+// <prefix>:bets:<matchId> (hash key -> рынок), <prefix>:meta (время последних данных),
+// <prefix>:outbox (stream неподтвержденных отличий)
+type RedisStorage struct {
+	logger *logger.Logger
+	client *redis.Client
+	prefix string
+	ctx    context.Context
+
+	// отличия, найденные этим экземпляром и еще не забранные через Get*. Для новых записей
+	// хранится последняя копия, для измененных запись с накопленными отметками об изменениях
+	mu             sync.Mutex
+	newMatches     pending[int, *parsed.Match]
+	updatedMatches pending[int, *parsed.Match]
+	deletedMatches pending[int, struct{}]
+	// restoredMatches вернувшиеся в пределах грейса матчи, уходят целиком как MATCH_RESTORED
+	restoredMatches pending[int, *parsed.Match]
+	newBets         pending[betRef, *parsed.Straight]
+	updatedBets     pending[betRef, *parsed.Straight]
+	notify          notifiers
+	grace           DeleteGrace
+
+	// outbox сколько очередей держат отличия из записи outbox; entries записи, отличия из которых
+	// лежат в очереди события; taken записи, забранные Get*, но еще не переданные отправителю
+	outbox  map[string]int
+	entries map[int]map[string]struct{}
+	taken   map[int][]string
+
+	// retryMatches и retryBets пакеты, транзакция которых не прошла, по группе и по матчу
+	retryMatches map[int][]*parsed.Match
+	retryBets    map[int]map[string]*parsed.Straight
+}
+
+// redisRecord запись в Redis: сами данные и время их последнего изменения. MissingSince и Misses
+// заполнены у матчей, пропавших из данных своей группы
+type redisRecord[T any] struct {
+	Data         T         `json:"data"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	MissingSince time.Time `json:"missingSince,omitempty"`
+	Misses       int       `json:"misses,omitempty"`
+}
+
+func NewRedisStorage(l *logger.Logger, grace DeleteGrace, addr, password string, db int, prefix string) (*RedisStorage, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisStorage{
+		logger:          l.Named("storage"),
+		client:          client,
+		prefix:          prefix,
+		ctx:             ctx,
+		newMatches:      newPending[int, *parsed.Match](),
+		updatedMatches:  newPending[int, *parsed.Match](),
+		deletedMatches:  newPending[int, struct{}](),
+		restoredMatches: newPending[int, *parsed.Match](),
+		newBets:         newPending[betRef, *parsed.Straight](),
+		updatedBets:     newPending[betRef, *parsed.Straight](),
+		notify:          newNotifiers(),
+		grace:           grace,
+		outbox:          make(map[string]int),
+		entries:         make(map[int]map[string]struct{}),
+		taken:           make(map[int][]string),
+		retryMatches:    make(map[int][]*parsed.Match),
+		retryBets:       make(map[int]map[string]*parsed.Straight),
+	}, nil
+}
+
+func (r *RedisStorage) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisStorage) matchesKey() string {
+	return r.prefix + ":matches"
+}
+
+func (r *RedisStorage) parentKey(parentID int) string {
+	return r.prefix + ":parent:" + strconv.Itoa(parentID)
+}
+
+func (r *RedisStorage) betsKey(matchID int) string {
+	return r.prefix + ":bets:" + strconv.Itoa(matchID)
+}
+
+func (r *RedisStorage) metaKey() string {
+	return r.prefix + ":meta"
+}
+
+func (r *RedisStorage) outboxKey() string {
+	return r.prefix + ":outbox"
+}
+
+func (r *RedisStorage) Notifications() abstruct.Notifications {
+	return r.notify.notifications()
+}
+
+// matchDiff результат сравнения группы матчей с сохраненной: новые и измененные матчи целиком,
+// у измененных отмечены изменения
+type matchDiff struct {
+	created  []*parsed.Match
+	updated  []*parsed.Match
+	restored []*parsed.Match
+	deleted  []int
+	// entry запись outbox со ссылками на эти отличия
+	entry string
+}
+
+func (r *RedisStorage) SetMatches(ctx context.Context, matches []*parsed.Match) {
+	if len(matches) == 0 {
+		return
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "storage.SetMatches", trace.WithAttributes(attribute.Int("pinnacle.matches", len(matches))))
+	defer span.End()
+
+	if matches[0].ParentId == 0 {
+		matches[0].ParentId = matches[0].ID
+	}
+	parentID := matches[0].ParentId
+	for _, match := range matches {
+		if match.ParentId == 0 {
+			match.ParentId = parentID
+		}
+	}
+
+	for id, group := range r.takeRetryMatches(parentID) {
+		r.storeMatches(ctx, span, id, group)
+	}
+	r.storeMatches(ctx, span, parentID, matches)
+}
+
+// takeRetryMatches забирает отложенные группы, кроме parentID: ее заменяют новые данные
+func (r *RedisStorage) takeRetryMatches(parentID int) map[int][]*parsed.Match {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.retryMatches, parentID)
+	if len(r.retryMatches) == 0 {
+		return nil
+	}
+	groups := r.retryMatches
+	r.retryMatches = make(map[int][]*parsed.Match)
+	return groups
+}
+
+// storeMatches записывает группу матчей и ставит найденные отличия в очереди. Если транзакция
+// не прошла, группа откладывается до следующего SetMatches
+func (r *RedisStorage) storeMatches(ctx context.Context, span trace.Span, parentID int, matches []*parsed.Match) {
+	var diff matchDiff
+	err := r.transaction(func(tx *redis.Tx) error {
+		var err error
+		diff, err = r.diffMatches(tx, parentID, matches)
+		return err
+	}, r.matchesKey(), r.parentKey(parentID))
+	if err != nil {
+		tracing.Fail(span, err)
+		r.logger.Error("Failed to store matches in redis, will retry with next data:", err)
+		r.mu.Lock()
+		r.retryMatches[parentID] = matches
+		r.mu.Unlock()
+		return
+	}
+
+	c := newChange(ctx)
+	upd := false
+	r.mu.Lock()
+	for _, match := range diff.created {
+		// удаленный и вернувшийся до отправки матч уходит только как MATCH_NEW, см. MapStorage.SetMatches
+		r.deletedMatches.remove(match.ID)
+		r.newMatches.put(match.ID, match, c)
+		r.attach(constants.MATCH_NEW, diff.entry)
+	}
+	back := false
+	for _, match := range diff.restored {
+		if r.newMatches.has(match.ID) {
+			r.newMatches.put(match.ID, match.Clone(), c)
+			r.attach(constants.MATCH_NEW, diff.entry)
+			continue
+		}
+		// вернувшийся матч уходит целиком, поэтому отдельный патч не нужен
+		r.updatedMatches.remove(match.ID)
+		r.restoredMatches.put(match.ID, match.Clone(), c)
+		r.attach(constants.MATCH_RESTORED, diff.entry)
+		back = true
+	}
+	upd = r.queueUpdates(diff.updated, diff.entry, c)
+	r.queueDeleted(diff.deleted, diff.entry, c)
+	r.mu.Unlock()
+
+	if len(diff.created) > 0 {
+		r.notify.matchNew.wake()
+	}
+	if upd {
+		r.notify.matchUpd.wake()
+	}
+	if back {
+		r.notify.matchRestored.wake()
+	}
+	if len(diff.deleted) > 0 {
+		r.notify.matchDel.wake()
+	}
+}
+
+// queueDeleted ставит удаленные матчи в очередь MATCH_DELETE вместо их неотправленных
+// отличий, вызывается под r.mu
+func (r *RedisStorage) queueDeleted(ids []int, entry string, c change) {
+	for _, id := range ids {
+		r.newMatches.remove(id)
+		r.updatedMatches.remove(id)
+		r.restoredMatches.remove(id)
+		r.deletedMatches.put(id, struct{}{}, c)
+		r.attach(constants.MATCH_DELETE, entry)
+		for ref := range r.newBets.items {
+			if ref.matchID == id {
+				r.newBets.remove(ref)
+			}
+		}
+		for ref := range r.updatedBets.items {
+			if ref.matchID == id {
+				r.updatedBets.remove(ref)
+			}
+		}
+	}
+}
+
+// ExpireMissing удаляет пропавшие матчи, грейс которых истек по времени, если их группа
+// перестала приходить. Удаление и запись outbox идут одной транзакцией, поэтому матч удаляет
+// и отправляет MATCH_DELETE только один экземпляр
+func (r *RedisStorage) ExpireMissing(now time.Time) (int, error) {
+	var deleted []int
+	var entry *redis.StringCmd
+	err := r.transaction(func(tx *redis.Tx) error {
+		deleted, entry = nil, nil
+		values, err := tx.HGetAll(r.ctx, r.matchesKey()).Result()
+		if err != nil {
+			return err
+		}
+		var expired []*parsed.Match
+		for _, raw := range values {
+			stored, err := decodeRecord[*parsed.Match](raw)
+			if err != nil || stored == nil || stored.MissingSince.IsZero() {
+				continue
+			}
+			if r.grace.expired(stored.Misses, stored.MissingSince, now) {
+				expired = append(expired, stored.Data)
+				deleted = append(deleted, stored.Data.ID)
+			}
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+		record, err := outboxRecord(pendingRefs{Deleted: deleted})
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for _, match := range expired {
+				member := strconv.Itoa(match.ID)
+				pipe.HDel(r.ctx, r.matchesKey(), member)
+				pipe.SRem(r.ctx, r.parentKey(match.ParentId), member)
+				pipe.Del(r.ctx, r.betsKey(match.ID))
+			}
+			entry = r.addOutbox(pipe, record)
+			return nil
+		})
+		return err
+	}, r.matchesKey())
+	if err != nil || len(deleted) == 0 {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.queueDeleted(deleted, entryID(entry), change{at: now})
+	r.mu.Unlock()
+	r.notify.matchDel.wake()
+	return len(deleted), nil
+}
+
+// queueUpdates ставит измененные матчи в очередь MATCH_UPDATE, вызывается под r.mu. Еще не
+// забранный новый или вернувшийся матч уйдет целиком с последними значениями
+func (r *RedisStorage) queueUpdates(matches []*parsed.Match, entry string, c change) bool {
+	upd := false
+	for _, match := range matches {
+		if r.newMatches.has(match.ID) {
+			r.newMatches.put(match.ID, match.Clone(), c)
+			r.attach(constants.MATCH_NEW, entry)
+		} else if r.restoredMatches.has(match.ID) {
+			r.restoredMatches.put(match.ID, match.Clone(), c)
+			r.attach(constants.MATCH_RESTORED, entry)
+		} else if queued, ok := r.updatedMatches.get(match.ID); ok {
+			mergeMatch(queued, match)
+			if match.Status != queued.Status {
+				queued.Status = match.Status
+				queued.MarkChanged("status")
+			}
+			r.updatedMatches.origin.Add(c.span)
+			r.attach(constants.MATCH_UPDATE, entry)
+		} else {
+			r.updatedMatches.put(match.ID, match, c)
+			r.attach(constants.MATCH_UPDATE, entry)
+			upd = true
+		}
+	}
+	return upd
+}
+
+// attach отмечает, что отличия из записи outbox лежат в очереди eventType, вызывается под r.mu
+func (r *RedisStorage) attach(eventType int, entry string) {
+	if entry == "" {
+		return
+	}
+	set := r.entries[eventType]
+	if set == nil {
+		set = make(map[string]struct{})
+		r.entries[eventType] = set
+	}
+	if _, ok := set[entry]; ok {
+		return
+	}
+	set[entry] = struct{}{}
+	r.outbox[entry]++
+}
+
+// takeEntries передает записи outbox очереди eventType отправителю, вызывается под r.mu в Get*
+func (r *RedisStorage) takeEntries(eventType int) {
+	for entry := range r.entries[eventType] {
+		r.taken[eventType] = append(r.taken[eventType], entry)
+	}
+	delete(r.entries, eventType)
+}
+
+// Taken возвращает записи outbox, отличия из которых забрал последний Get* для eventType
+func (r *RedisStorage) Taken(eventType int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.taken[eventType]
+	delete(r.taken, eventType)
+	return ids
+}
+
+// Ack подтверждает доставку и удаляет записи outbox, отличия из которых больше не ждут отправки
+func (r *RedisStorage) Ack(ids []string) {
+	r.mu.Lock()
+	var done []string
+	for _, id := range ids {
+		n, ok := r.outbox[id]
+		if !ok {
+			continue
+		}
+		if n > 1 {
+			r.outbox[id] = n - 1
+			continue
+		}
+		delete(r.outbox, id)
+		done = append(done, id)
+	}
+	r.mu.Unlock()
+
+	if len(done) == 0 {
+		return
+	}
+	if err := r.client.XDel(r.ctx, r.outboxKey(), done...).Err(); err != nil {
+		r.logger.Error("Failed to ack redis outbox:", err)
+	}
+}
+
+// outboxRecord ссылки на отличия для записи outbox, nil если отличий нет
+func outboxRecord(refs pendingRefs) ([]byte, error) {
+	if refs.empty() {
+		return nil, nil
+	}
+	return sonic.Marshal(refs)
+}
+
+// addOutbox добавляет запись outbox в транзакцию, nil если ссылок нет
+func (r *RedisStorage) addOutbox(pipe redis.Pipeliner, refs []byte) *redis.StringCmd {
+	if refs == nil {
+		return nil
+	}
+	return pipe.XAdd(r.ctx, &redis.XAddArgs{Stream: r.outboxKey(), Values: []any{"refs", refs}})
+}
+
+func entryID(cmd *redis.StringCmd) string {
+	if cmd == nil {
+		return ""
+	}
+	return cmd.Val()
+}
+
+// diffMatches сравнивает группу матчей с сохраненной и в той же транзакции записывает результат
+func (r *RedisStorage) diffMatches(tx *redis.Tx, parentID int, matches []*parsed.Match) (matchDiff, error) {
+	var diff matchDiff
+	now := time.Now()
+
+	fields := make([]string, len(matches))
+	ids := make(map[string]struct{}, len(matches))
+	for i, match := range matches {
+		fields[i] = strconv.Itoa(match.ID)
+		ids[fields[i]] = struct{}{}
+	}
+
+	values, err := tx.HMGet(r.ctx, r.matchesKey(), fields...).Result()
+	if err != nil {
+		return diff, err
+	}
+	members, err := tx.SMembers(r.ctx, r.parentKey(parentID)).Result()
+	if err != nil {
+		return diff, err
+	}
+	var absent []string
+	for _, member := range members {
+		if _, ok := ids[member]; !ok {
+			absent = append(absent, member)
+		}
+	}
+	var absentValues []any
+	if len(absent) > 0 {
+		if absentValues, err = tx.HMGet(r.ctx, r.matchesKey(), absent...).Result(); err != nil {
+			return diff, err
+		}
+	}
+
+	records := make(map[string]*redisRecord[*parsed.Match], len(matches)+len(absent))
+	// статус новых и вернувшихся матчей считается по уже сохраненным рынкам
+	var recount []*parsed.Match
+	for i, match := range matches {
+		stored, err := decodeRecord[*parsed.Match](values[i])
+		if err != nil {
+			return diff, err
+		}
+
+		switch {
+		case stored == nil:
+			match.UpdatedAt = now
+			stored = &redisRecord[*parsed.Match]{Data: match, UpdatedAt: now}
+			diff.created = append(diff.created, match)
+			recount = append(recount, match)
+		case stored.Data.Status == parsed.MATCH_STATUS_MISSING:
+			mergeMatch(stored.Data, match)
+			stored.UpdatedAt = now
+			stored.MissingSince = time.Time{}
+			stored.Misses = 0
+			diff.restored = append(diff.restored, stored.Data)
+			recount = append(recount, stored.Data)
+		case mergeMatch(stored.Data, match):
+			stored.UpdatedAt = now
+			diff.updated = append(diff.updated, stored.Data)
+		}
+		records[fields[i]] = stored
+	}
+
+	var gone []string
+	for i, member := range absent {
+		stored, err := decodeRecord[*parsed.Match](absentValues[i])
+		if err != nil {
+			return diff, err
+		}
+		if stored == nil {
+			gone = append(gone, member)
+			continue
+		}
+		if stored.MissingSince.IsZero() {
+			stored.MissingSince = now
+		}
+		stored.Misses++
+		if r.grace.expired(stored.Misses, stored.MissingSince, now) {
+			gone = append(gone, member)
+			diff.deleted = append(diff.deleted, stored.Data.ID)
+			continue
+		}
+		if stored.Data.Status != parsed.MATCH_STATUS_MISSING {
+			stored.Data.Status = parsed.MATCH_STATUS_MISSING
+			stored.Data.MarkChanged("status")
+			stored.UpdatedAt = now
+			diff.updated = append(diff.updated, stored.Data)
+		}
+		records[member] = stored
+	}
+
+	if err := r.recountStatuses(tx, recount); err != nil {
+		return diff, err
+	}
+
+	args := make([]any, 0, len(records)*2)
+	for field, rec := range records {
+		rec.Data.UpdatedAt = rec.UpdatedAt
+		data, err := sonic.Marshal(rec)
+		if err != nil {
+			return diff, err
+		}
+		args = append(args, field, data)
+	}
+
+	var refs pendingRefs
+	for _, group := range [][]*parsed.Match{diff.created, diff.restored, diff.updated} {
+		for _, match := range group {
+			refs.Matches = append(refs.Matches, match.ID)
+		}
+	}
+	refs.Deleted = diff.deleted
+	record, err := outboxRecord(refs)
+	if err != nil {
+		return diff, err
+	}
+
+	var entry *redis.StringCmd
+	_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.ctx, r.matchesKey(), args...)
+		pipe.SAdd(r.ctx, r.parentKey(parentID), toAny(fields)...)
+		for _, member := range gone {
+			pipe.HDel(r.ctx, r.matchesKey(), member)
+			pipe.SRem(r.ctx, r.parentKey(parentID), member)
+			pipe.Del(r.ctx, r.prefix+":bets:"+member)
+		}
+		pipe.HSet(r.ctx, r.metaKey(), "lastMatchesAt", now.UnixNano())
+		entry = r.addOutbox(pipe, record)
+		return nil
+	})
+	if err != nil {
+		return diff, err
+	}
+	diff.entry = entryID(entry)
+
+	// в очереди уходят копии, записи выше принадлежат транзакции
+	for i, match := range diff.created {
+		diff.created[i] = match.Clone()
+	}
+
+	return diff, nil
+}
+
+// recountStatuses выставляет матчам статус по их сохраненным рынкам
+func (r *RedisStorage) recountStatuses(tx *redis.Tx, matches []*parsed.Match) error {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	cmds := make([]*redis.StringSliceCmd, len(matches))
+	_, err := tx.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for i, match := range matches {
+			cmds[i] = pipe.HVals(r.ctx, r.betsKey(match.ID))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, match := range matches {
+		match.Status = marketsStatus(slices.Values(decodeBets(cmds[i].Val())))
+	}
+	return nil
+}
+
+// refreshStatus пересчитывает статус присутствующего матча после изменения его рынков.
+// Возвращает матч с отмеченным изменением статуса и запись outbox или nil, если статус прежний
+func (r *RedisStorage) refreshStatus(matchID int) (*parsed.Match, string, error) {
+	values, err := r.client.HVals(r.ctx, r.betsKey(matchID)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	status := marketsStatus(slices.Values(decodeBets(values)))
+	record, err := outboxRecord(pendingRefs{Matches: []int{matchID}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var updated *parsed.Match
+	var entry *redis.StringCmd
+	err = r.transaction(func(tx *redis.Tx) error {
+		updated = nil
+		v, err := tx.HGet(r.ctx, r.matchesKey(), strconv.Itoa(matchID)).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stored, err := decodeRecord[*parsed.Match](v)
+		if err != nil || stored == nil {
+			return err
+		}
+		if stored.Data.Status == parsed.MATCH_STATUS_MISSING || stored.Data.Status == status {
+			return nil
+		}
+
+		stored.Data.Status = status
+		stored.Data.MarkChanged("status")
+		stored.UpdatedAt = time.Now()
+		data, err := sonic.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(r.ctx, r.matchesKey(), strconv.Itoa(matchID), data)
+			entry = r.addOutbox(pipe, record)
+			return nil
+		})
+		if err == nil {
+			stored.Data.UpdatedAt = stored.UpdatedAt
+			updated = stored.Data
+		}
+		return err
+	}, r.matchesKey())
+
+	return updated, entryID(entry), err
+}
+
+func (r *RedisStorage) SetBets(ctx context.Context, bets map[int][]*parsed.Straight) {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.SetBets", trace.WithAttributes(attribute.Int("pinnacle.matches", len(bets))))
+	defer span.End()
+
+	groups := r.takeRetryBets()
+	for matchID, group := range bets {
+		if len(group) == 0 {
+			continue
+		}
+		// отложенные рынки матча применяются вместе с новыми, новые значения важнее
+		byKey := groups[matchID]
+		if byKey == nil {
+			byKey = make(map[string]*parsed.Straight, len(group))
+			groups[matchID] = byKey
+		}
+		for _, bet := range group {
+			byKey[bet.Key] = bet
+		}
+	}
+
+	var diffs []betDiff
+	var statuses []betDiff
+	for matchID, byKey := range groups {
+		group := slices.Collect(maps.Values(byKey))
+		var diff betDiff
+		err := r.transaction(func(tx *redis.Tx) error {
+			var err error
+			diff, err = r.diffBets(tx, matchID, group)
+			return err
+		}, r.betsKey(matchID))
+		if err != nil {
+			tracing.Fail(span, err)
+			r.logger.Error("Failed to store bets in redis for match, will retry with next data:", matchID, err)
+			r.mu.Lock()
+			r.retryBets[matchID] = byKey
+			r.mu.Unlock()
+			continue
+		}
+		diffs = append(diffs, diff)
+
+		match, entry, err := r.refreshStatus(matchID)
+		if err != nil {
+			r.logger.Error("Failed to refresh match status in redis", matchID, err)
+		} else if match != nil {
+			statuses = append(statuses, betDiff{status: match, entry: entry})
+		}
+	}
+
+	c := newChange(ctx)
+	newy := false
+	upd := false
+	status := false
+	r.mu.Lock()
+	for _, diff := range diffs {
+		for _, bet := range diff.created {
+			r.newBets.put(betRef{matchID: bet.MatchupID, key: bet.Key}, bet, c)
+			r.attach(constants.BET_NEW, diff.entry)
+			newy = true
+		}
+		for _, bet := range diff.updated {
+			ref := betRef{matchID: bet.MatchupID, key: bet.Key}
+			if r.newBets.has(ref) {
+				r.newBets.put(ref, bet.Clone(), c)
+				r.attach(constants.BET_NEW, diff.entry)
+			} else if queued, ok := r.updatedBets.get(ref); ok {
+				mergeBet(queued, bet)
+				r.updatedBets.origin.Add(c.span)
+				r.attach(constants.BET_UPDATE, diff.entry)
+			} else {
+				r.updatedBets.put(ref, bet, c)
+				r.attach(constants.BET_UPDATE, diff.entry)
+				upd = true
+			}
+		}
+	}
+	for _, s := range statuses {
+		status = r.queueUpdates([]*parsed.Match{s.status}, s.entry, c) || status
+	}
+	r.mu.Unlock()
+
+	if newy {
+		r.notify.betNew.wake()
+	}
+	if upd {
+		r.notify.betUpd.wake()
+	}
+	if status {
+		r.notify.matchUpd.wake()
+	}
+}
+
+// takeRetryBets забирает рынки, отложенные после неудачных транзакций
+func (r *RedisStorage) takeRetryBets() map[int]map[string]*parsed.Straight {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups := r.retryBets
+	r.retryBets = make(map[int]map[string]*parsed.Straight)
+	return groups
+}
+
+// betDiff отличия рынков одного матча или изменение статуса матча и их запись outbox
+type betDiff struct {
+	created []*parsed.Straight
+	updated []*parsed.Straight
+	status  *parsed.Match
+	entry   string
+}
+
+func (r *RedisStorage) diffBets(tx *redis.Tx, matchID int, bets []*parsed.Straight) (betDiff, error) {
+	var diff betDiff
+	now := time.Now()
+	key := r.betsKey(matchID)
+
+	fields := make([]string, len(bets))
+	for i, bet := range bets {
+		fields[i] = bet.Key
+	}
+	values, err := tx.HMGet(r.ctx, key, fields...).Result()
+	if err != nil {
+		return diff, err
+	}
+
+	var refs pendingRefs
+	records := make([]any, 0, len(bets)*2)
+	for i, bet := range bets {
+		stored, err := decodeRecord[*parsed.Straight](values[i])
+		if err != nil {
+			return diff, err
+		}
+
+		if stored == nil {
+			bet.UpdatedAt = now
+			diff.created = append(diff.created, bet.Clone())
+			refs.Bets = append(refs.Bets, pendingBet{MatchID: matchID, Key: bet.Key})
+		} else {
+			if mergeBet(stored.Data, bet) {
+				stored.UpdatedAt = now
+				diff.updated = append(diff.updated, stored.Data)
+				refs.Bets = append(refs.Bets, pendingBet{MatchID: matchID, Key: bet.Key})
+			}
+			bet = stored.Data
+			bet.UpdatedAt = stored.UpdatedAt
+		}
+
+		data, err := sonic.Marshal(redisRecord[*parsed.Straight]{Data: bet, UpdatedAt: bet.UpdatedAt})
+		if err != nil {
+			return diff, err
+		}
+		records = append(records, fields[i], data)
+	}
+	record, err := outboxRecord(refs)
+	if err != nil {
+		return diff, err
+	}
+
+	var entry *redis.StringCmd
+	_, err = tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.ctx, key, records...)
+		pipe.HSet(r.ctx, r.metaKey(), "lastBetsAt", now.UnixNano())
+		entry = r.addOutbox(pipe, record)
+		return nil
+	})
+	diff.entry = entryID(entry)
+
+	return diff, err
+}
+
+// RunOutbox раз в outboxTimeout повторно отправляет отличия из записей outbox, которые никто не
+// подтвердил за это время, вызывается в отдельной горутине
+func (r *RedisStorage) RunOutbox(onError func(error)) {
+	ticker := time.NewTicker(outboxTimeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := r.reclaimOutbox(time.Now().Add(-outboxTimeout)); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// reclaimOutbox забирает записи outbox старше before и ставит их отличия в очереди этого
+// экземпляра с текущими значениями из redis. Возвращает число забранных записей
+func (r *RedisStorage) reclaimOutbox(before time.Time) (int, error) {
+	entries, err := r.client.XRangeN(r.ctx, r.outboxKey(), "-", strconv.FormatInt(before.UnixMilli(), 10), outboxBatch).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	claimed := 0
+	for _, e := range entries {
+		raw, _ := e.Values["refs"].(string)
+		id, err := claimOutbox.Run(r.ctx, r.client, []string{r.outboxKey()}, e.ID, raw).Text()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed++
+
+		r.mu.Lock()
+		delete(r.outbox, e.ID)
+		r.mu.Unlock()
+
+		var refs pendingRefs
+		if err := sonic.UnmarshalString(raw, &refs); err != nil {
+			r.logger.Error("Dropping broken redis outbox entry", e.ID, err)
+			r.client.XDel(r.ctx, r.outboxKey(), id)
+			continue
+		}
+		if err := r.requeue(id, refs); err != nil {
+			return claimed, err
+		}
+	}
+	return claimed, nil
+}
+
+// requeue ставит в очереди текущие значения записей из refs: матчи и рынки уходят целиком,
+// удаления только если матч так и не вернулся. Запись без отличий удаляется сразу
+func (r *RedisStorage) requeue(entry string, refs pendingRefs) error {
+	ids := make([]string, 0, len(refs.Matches)+len(refs.Deleted))
+	for _, id := range refs.Matches {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	for _, id := range refs.Deleted {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	var matches []any
+	if len(ids) > 0 {
+		var err error
+		if matches, err = r.client.HMGet(r.ctx, r.matchesKey(), ids...).Result(); err != nil {
+			return err
+		}
+	}
+	bets := make([]*redis.SliceCmd, len(refs.Bets))
+	if len(refs.Bets) > 0 {
+		_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for i, ref := range refs.Bets {
+				bets[i] = pipe.HMGet(r.ctx, r.betsKey(ref.MatchID), ref.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	c := newChange(r.ctx)
+	var matchNew, matchDel, betNew bool
+	r.mu.Lock()
+	for i := range refs.Matches {
+		if rec, err := decodeRecord[*parsed.Match](matches[i]); err == nil && rec != nil {
+			rec.Data.UpdatedAt = rec.UpdatedAt
+			r.newMatches.put(rec.Data.ID, rec.Data, c)
+			r.attach(constants.MATCH_NEW, entry)
+			matchNew = true
+		}
+	}
+	for i, id := range refs.Deleted {
+		if matches[len(refs.Matches)+i] == nil {
+			r.deletedMatches.put(id, struct{}{}, c)
+			r.attach(constants.MATCH_DELETE, entry)
+			matchDel = true
+		}
+	}
+	for i, ref := range refs.Bets {
+		if v := bets[i].Val(); len(v) == 1 {
+			if rec, err := decodeRecord[*parsed.Straight](v[0]); err == nil && rec != nil {
+				rec.Data.UpdatedAt = rec.UpdatedAt
+				r.newBets.put(betRef{matchID: ref.MatchID, key: ref.Key}, rec.Data, c)
+				r.attach(constants.BET_NEW, entry)
+				betNew = true
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if !matchNew && !matchDel && !betNew {
+		return r.client.XDel(r.ctx, r.outboxKey(), entry).Err()
+	}
+	if matchNew {
+		r.notify.matchNew.wake()
+	}
+	if matchDel {
+		r.notify.matchDel.wake()
+	}
+	if betNew {
+		r.notify.betNew.wake()
+	}
+	return nil
+}
+
+// transaction выполняет fn под WATCH keys и повторяет ее, если ключи изменились до EXEC
+func (r *RedisStorage) transaction(fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(r.ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errors.New("redis transaction retries exceeded")
+}
+
+func (r *RedisStorage) GetNewMatches() ([]*parsed.Match, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.newMatches.take()
+	r.takeEntries(constants.MATCH_NEW)
+	matches := make([]*parsed.Match, 0, len(items))
+	for _, match := range items {
+		matches = append(matches, match)
+	}
+	metrics.ObserveDiff(constants.MATCH_NEW, len(matches))
+	return matches, origin
+}
+
+func (r *RedisStorage) GetUpdatedMatches() ([]*parsed.Match, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.updatedMatches.take()
+	r.takeEntries(constants.MATCH_UPDATE)
+	matches := make([]*parsed.Match, 0, len(items))
+	for _, match := range items {
+		matches = append(matches, match.GetUpdate())
+	}
+	metrics.ObserveDiff(constants.MATCH_UPDATE, len(matches))
+	return matches, origin
+}
+
+func (r *RedisStorage) GetRestoredMatches() ([]*parsed.Match, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.restoredMatches.take()
+	r.takeEntries(constants.MATCH_RESTORED)
+	matches := make([]*parsed.Match, 0, len(items))
+	for _, match := range items {
+		matches = append(matches, match)
+	}
+	metrics.ObserveDiff(constants.MATCH_RESTORED, len(matches))
+	return matches, origin
+}
+
+func (r *RedisStorage) GetDeletedMatches() ([]int, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.deletedMatches.take()
+	r.takeEntries(constants.MATCH_DELETE)
+	deleted := make([]int, 0, len(items))
+	for id := range items {
+		deleted = append(deleted, id)
+	}
+	sort.Ints(deleted)
+	metrics.ObserveDiff(constants.MATCH_DELETE, len(deleted))
+	return deleted, origin
+}
+
+func (r *RedisStorage) GetNewBets() ([]*parsed.Straight, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.newBets.take()
+	r.takeEntries(constants.BET_NEW)
+	bets := make([]*parsed.Straight, 0, len(items))
+	for _, bet := range items {
+		bets = append(bets, bet)
+	}
+	metrics.ObserveDiff(constants.BET_NEW, len(bets))
+	return bets, origin
+}
+
+func (r *RedisStorage) GetUpdatedBets() ([]*parsed.Straight, tracing.Origin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items, origin := r.updatedBets.take()
+	r.takeEntries(constants.BET_UPDATE)
+	bets := make([]*parsed.Straight, 0, len(items))
+	for _, bet := range items {
+		if data := bet.GetUpdate(); data != nil {
+			bets = append(bets, data)
+		}
+	}
+	metrics.ObserveDiff(constants.BET_UPDATE, len(bets))
+	return bets, origin
+}
+
+func (r *RedisStorage) MatchList() []*parsed.Match {
+	values, err := r.client.HVals(r.ctx, r.matchesKey()).Result()
+	if err != nil {
+		r.logger.Error("Failed to read matches from redis:", err)
+		return nil
+	}
+
+	matches := make([]*parsed.Match, 0, len(values))
+	for _, v := range values {
+		if rec, err := decodeRecord[*parsed.Match](v); err == nil && rec != nil {
+			rec.Data.UpdatedAt = rec.UpdatedAt
+			matches = append(matches, rec.Data)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].StartTime.Equal(matches[j].StartTime) {
+			return matches[i].ID < matches[j].ID
+		}
+		return matches[i].StartTime.Before(matches[j].StartTime)
+	})
+
+	return matches
+}
+
+func (r *RedisStorage) Match(id int) (*parsed.Match, bool) {
+	v, err := r.client.HGet(r.ctx, r.matchesKey(), strconv.Itoa(id)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			r.logger.Error("Failed to read match from redis:", err)
+		}
+		return nil, false
+	}
+
+	rec, err := decodeRecord[*parsed.Match](v)
+	if err != nil || rec == nil {
+		return nil, false
+	}
+	rec.Data.UpdatedAt = rec.UpdatedAt
+	return rec.Data, true
+}
+
+func (r *RedisStorage) MatchBets(matchID int) []*parsed.Straight {
+	values, err := r.client.HVals(r.ctx, r.betsKey(matchID)).Result()
+	if err != nil {
+		r.logger.Error("Failed to read bets from redis:", err)
+		return nil
+	}
+
+	bets := decodeBets(values)
+	sort.Slice(bets, func(i, j int) bool { return bets[i].Key < bets[j].Key })
+
+	return bets
+}
+
+func (r *RedisStorage) Bet(matchID int, key string) (*parsed.Straight, bool) {
+	v, err := r.client.HGet(r.ctx, r.betsKey(matchID), key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			r.logger.Error("Failed to read bet from redis:", err)
+		}
+		return nil, false
+	}
+
+	rec, err := decodeRecord[*parsed.Straight](v)
+	if err != nil || rec == nil {
+		return nil, false
+	}
+	rec.Data.UpdatedAt = rec.UpdatedAt
+	return rec.Data, true
+}
+
+func (r *RedisStorage) Stats() abstruct.StateStats {
+	var stats abstruct.StateStats
+
+	values, err := r.client.HVals(r.ctx, r.matchesKey()).Result()
+	if err != nil {
+		r.logger.Error("Failed to read stats from redis:", err)
+		return stats
+	}
+	stats.Matches = len(values)
+
+	pipe := r.client.Pipeline()
+	lens := make([]*redis.IntCmd, 0, len(values))
+	for _, v := range values {
+		rec, err := decodeRecord[*parsed.Match](v)
+		if err != nil || rec == nil {
+			continue
+		}
+		if rec.Data.Status == parsed.MATCH_STATUS_MISSING {
+			stats.Missing++
+		}
+		lens = append(lens, pipe.HLen(r.ctx, r.betsKey(rec.Data.ID)))
+	}
+	meta := pipe.HMGet(r.ctx, r.metaKey(), "lastMatchesAt", "lastBetsAt")
+	if _, err := pipe.Exec(r.ctx); err != nil && !errors.Is(err, redis.Nil) {
+		r.logger.Error("Failed to read stats from redis:", err)
+		return stats
+	}
+
+	for _, l := range lens {
+		stats.Markets += int(l.Val())
+	}
+	if v := meta.Val(); len(v) == 2 {
+		stats.LastMatchesAt = unixNano(v[0])
+		stats.LastBetsAt = unixNano(v[1])
+	}
+	stats.Backlog = r.backlog()
+
+	return stats
+}
+
+// backlog отличия, которые этот экземпляр еще не отправил
+func (r *RedisStorage) backlog() abstruct.Backlog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return abstruct.Backlog{
+		NewMatches:      len(r.newMatches.items),
+		UpdatedMatches:  len(r.updatedMatches.items),
+		DeletedMatches:  len(r.deletedMatches.items),
+		RestoredMatches: len(r.restoredMatches.items),
+		NewBets:         len(r.newBets.items),
+		UpdatedBets:     len(r.updatedBets.items),
+		Coalesced:       r.notify.coalesced(),
+		OldestAt: oldest(r.newMatches.since, r.updatedMatches.since, r.deletedMatches.since,
+			r.restoredMatches.since, r.newBets.since, r.updatedBets.since),
+	}
+}
+
+// decodeRecord разбирает значение HGET/HMGET, nil означает отсутствие записи
+func decodeRecord[T any](v any) (*redisRecord[T], error) {
+	var raw string
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		raw = value
+	default:
+		return nil, errors.New("unexpected redis value")
+	}
+
+	var rec redisRecord[T]
+	if err := sonic.UnmarshalString(raw, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// decodeBets разбирает значения HVALS хэша рынков, битые записи пропускаются
+func decodeBets(values []string) []*parsed.Straight {
+	bets := make([]*parsed.Straight, 0, len(values))
+	for _, v := range values {
+		if rec, err := decodeRecord[*parsed.Straight](v); err == nil && rec != nil {
+			rec.Data.UpdatedAt = rec.UpdatedAt
+			bets = append(bets, rec.Data)
+		}
+	}
+	return bets
+}
+
+func unixNano(v any) time.Time {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func toAny(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func newTestRedis(t *testing.T, mr *miniredis.Miniredis) *RedisStorage {
+	t.Helper()
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	r, err := NewRedisStorage(l, DeleteGrace{}, mr.Addr(), "", 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func outboxLen(t *testing.T, r *RedisStorage) int64 {
+	t.Helper()
+	n, err := r.client.XLen(context.Background(), r.outboxKey()).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestRedisOutboxAck запись outbox пишется вместе с отличиями и удаляется после подтверждения
+func TestRedisOutboxAck(t *testing.T) {
+	r := newTestRedis(t, miniredis.RunT(t))
+	r.SetMatches(context.Background(), group(false, 1, 2))
+	if n := outboxLen(t, r); n != 1 {
+		t.Fatalf("outbox after SetMatches = %d, want 1", n)
+	}
+
+	if matches, _ := r.GetNewMatches(); len(matches) != 2 {
+		t.Fatalf("new matches = %d, want 2", len(matches))
+	}
+	ids := r.Taken(constants.MATCH_NEW)
+	if len(ids) != 1 {
+		t.Fatalf("taken = %v, want one entry", ids)
+	}
+	r.Ack(ids)
+	if n := outboxLen(t, r); n != 0 {
+		t.Fatalf("outbox after Ack = %d, want 0", n)
+	}
+}
+
+// TestRedisOutboxReclaim отличия экземпляра, упавшего до отправки, отправляет другой экземпляр
+func TestRedisOutboxReclaim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	crashed := newTestRedis(t, mr)
+	ctx := context.Background()
+	crashed.SetMatches(ctx, group(false, 1))
+	crashed.SetBets(ctx, map[int][]*parsed.Straight{1: {parsed.GenerateExampleStraight(1)}})
+
+	written := outboxLen(t, crashed)
+	// забранные записи добавляются заново, отсчет для них начинается позже before
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	other := newTestRedis(t, mr)
+	claimed, err := other.reclaimOutbox(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written < 2 || int64(claimed) != written {
+		t.Fatalf("claimed = %d of %d entries", claimed, written)
+	}
+	if again, _ := crashed.reclaimOutbox(before); again != 0 {
+		t.Fatalf("entries claimed twice: %d", again)
+	}
+
+	if matches, _ := other.GetNewMatches(); len(matches) != 1 || matches[0].ID != 1 {
+		t.Fatalf("reclaimed matches = %+v, want match 1", matches)
+	}
+	if bets, _ := other.GetNewBets(); len(bets) != 1 {
+		t.Fatalf("reclaimed bets = %d, want 1", len(bets))
+	}
+	other.Ack(other.Taken(constants.MATCH_NEW))
+	other.Ack(other.Taken(constants.BET_NEW))
+	if n := outboxLen(t, other); n != 0 {
+		t.Fatalf("outbox after Ack = %d, want 0", n)
+	}
+}
+
+// TestRedisRetriesFailedBatch пакет, транзакция которого не прошла, записывается со следующим
+func TestRedisRetriesFailedBatch(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newTestRedis(t, mr)
+	ctx := context.Background()
+
+	mr.SetError("LOADING")
+	r.SetMatches(ctx, group(false, 1))
+	r.SetBets(ctx, map[int][]*parsed.Straight{1: {parsed.GenerateExampleStraight(1)}})
+	mr.SetError("")
+
+	second := group(false, 2)
+	second[0].ParentId = 2
+	r.SetMatches(ctx, second)
+	if _, ok := r.Match(1); !ok {
+		t.Fatal("failed group is not retried")
+	}
+	r.SetBets(ctx, nil)
+	if bets := r.MatchBets(1); len(bets) != 1 {
+		t.Fatalf("bets after retry = %d, want 1", len(bets))
+	}
+}
+
+// TestRedisDeleteThenNewCoalesces см. TestDeleteThenNewCoalesces
+func TestRedisDeleteThenNewCoalesces(t *testing.T) {
+	r := newTestRedis(t, miniredis.RunT(t))
+	ctx := context.Background()
+	r.SetMatches(ctx, group(false, 1, 2))
+	r.GetNewMatches()
+
+	r.SetMatches(ctx, group(false, 2))
+	r.SetMatches(ctx, group(false, 1, 2))
+
+	if deleted, _ := r.GetDeletedMatches(); len(deleted) != 0 {
+		t.Fatalf("MATCH_DELETE = %v, want none", deleted)
+	}
+	if matches, _ := r.GetNewMatches(); len(matches) != 1 || matches[0].ID != 1 {
+		t.Fatalf("MATCH_NEW = %+v, want match 1", matches)
+	}
+}
+
+// TestRedisExpireMissing см. TestMissingDeletedByTime
+func TestRedisExpireMissing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	r, err := NewRedisStorage(l, DeleteGrace{Misses: 100, After: 30 * time.Second}, mr.Addr(), "", 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	ctx := context.Background()
+	r.SetMatches(ctx, group(false, 1, 2))
+	r.SetBets(ctx, map[int][]*parsed.Straight{1: {parsed.GenerateExampleStraight(1)}})
+	r.SetMatches(ctx, group(false, 2))
+	now := time.Now()
+
+	if n, err := r.ExpireMissing(now.Add(10 * time.Second)); err != nil || n != 0 {
+		t.Fatalf("expired %d, %v before deleteGrace", n, err)
+	}
+	written := outboxLen(t, r)
+	if n, err := r.ExpireMissing(now.Add(31 * time.Second)); err != nil || n != 1 {
+		t.Fatalf("expired %d, %v after deleteGrace, want 1", n, err)
+	}
+	if outboxLen(t, r) != written+1 {
+		t.Fatal("MATCH_DELETE is not recorded in the outbox")
+	}
+	if _, ok := r.Match(1); ok {
+		t.Fatal("expired match is still stored")
+	}
+	if bets := r.MatchBets(1); len(bets) != 0 {
+		t.Fatalf("bets of the expired match are left: %d", len(bets))
+	}
+	if deleted, _ := r.GetDeletedMatches(); len(deleted) != 1 || deleted[0] != 1 {
+		t.Fatalf("MATCH_DELETE = %v, want [1]", deleted)
+	}
+	if n, _ := r.ExpireMissing(now.Add(time.Hour)); n != 0 {
+		t.Fatalf("match expired twice: %d", n)
+	}
+}
@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Filter условия подписки клиента. Пустое множество не ограничивает выборку
+type Filter struct {
+	SportIDs    map[int]struct{}
+	LeagueIDs   map[int]struct{}
+	MatchIDs    map[int]struct{}
+	MarketTypes map[string]struct{}
+}
+
+// matchMeta то, что нужно знать о матче для фильтрации его событий и ставок
+type matchMeta struct {
+	sportID  int
+	leagueID int
+}
+
+// ParseFilter читает sport_id, league_id, match_id и market_type, значения перечисляются через запятую
+func ParseFilter(q url.Values) (Filter, error) {
+	var f Filter
+	var err error
+	if f.SportIDs, err = intSet(q.Get("sport_id")); err != nil {
+		return f, err
+	}
+	if f.LeagueIDs, err = intSet(q.Get("league_id")); err != nil {
+		return f, err
+	}
+	if f.MatchIDs, err = intSet(q.Get("match_id")); err != nil {
+		return f, err
+	}
+	if v := q.Get("market_type"); v != "" {
+		f.MarketTypes = make(map[string]struct{})
+		for _, t := range strings.Split(v, ",") {
+			f.MarketTypes[strings.TrimSpace(t)] = struct{}{}
+		}
+	}
+
+	return f, nil
+}
+
+func intSet(v string) (map[int]struct{}, error) {
+	if v == "" {
+		return nil, nil
+	}
+	set := make(map[int]struct{})
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		set[n] = struct{}{}
+	}
+	return set, nil
+}
+
+// allowMatch проверяет матч по id, лиге и виду спорта. Если лига неизвестна, а фильтр по ней
+// задан, событие не пропускается
+func (f Filter) allowMatch(matchID int, meta matchMeta, known bool) bool {
+	if !contains(f.MatchIDs, matchID) {
+		return false
+	}
+	if len(f.SportIDs) == 0 && len(f.LeagueIDs) == 0 {
+		return true
+	}
+	return known && contains(f.SportIDs, meta.sportID) && contains(f.LeagueIDs, meta.leagueID)
+}
+
+func (f Filter) allowMarket(marketType string) bool {
+	if len(f.MarketTypes) == 0 {
+		return true
+	}
+	_, ok := f.MarketTypes[marketType]
+	return ok
+}
+
+func contains(set map[int]struct{}, v int) bool {
+	if len(set) == 0 {
+		return true
+	}
+	_, ok := set[v]
+	return ok
+}
@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{
+		"sport_id":    {"1, 2"},
+		"match_id":    {"7"},
+		"market_type": {"moneyline,total"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.SportIDs) != 2 || len(f.LeagueIDs) != 0 || len(f.MatchIDs) != 1 || len(f.MarketTypes) != 2 {
+		t.Fatalf("filter = %+v", f)
+	}
+	if _, ok := f.SportIDs[2]; !ok {
+		t.Fatalf("sport 2 is missing: %v", f.SportIDs)
+	}
+
+	if _, err := ParseFilter(url.Values{"league_id": {"1,x"}}); err == nil {
+		t.Fatal("bad league_id is accepted")
+	}
+}
+
+func TestAllowMatch(t *testing.T) {
+	meta := matchMeta{sportID: 1, leagueID: 10}
+	cases := []struct {
+		name   string
+		filter Filter
+		id     int
+		known  bool
+		want   bool
+	}{
+		{"empty filter", Filter{}, 5, false, true},
+		{"match id", Filter{MatchIDs: map[int]struct{}{5: {}}}, 5, false, true},
+		{"other match id", Filter{MatchIDs: map[int]struct{}{6: {}}}, 5, true, false},
+		{"sport", Filter{SportIDs: map[int]struct{}{1: {}}}, 5, true, true},
+		{"other sport", Filter{SportIDs: map[int]struct{}{2: {}}}, 5, true, false},
+		{"league", Filter{LeagueIDs: map[int]struct{}{10: {}}}, 5, true, true},
+		{"unknown league", Filter{LeagueIDs: map[int]struct{}{10: {}}}, 5, false, false},
+	}
+	for _, c := range cases {
+		if got := c.filter.allowMatch(c.id, meta, c.known); got != c.want {
+			t.Errorf("%s: allowMatch = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	f := Filter{MarketTypes: map[string]struct{}{"total": {}}}
+	if !f.allowMarket("total") || f.allowMarket("spread") || !(Filter{}).allowMarket("spread") {
+		t.Fatal("allowMarket filters market types wrong")
+	}
+}
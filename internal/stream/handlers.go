@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	pongTimeout  = 2 * pingInterval
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// API только для чтения, поэтому подключения с любых origin разрешены
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// ServeWS отдает события по WebSocket. Фильтры передаются в query, см. ParseFilter
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "filter ids must be numbers", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Warn("WebSocket upgrade failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	client, snapshot := h.Subscribe(filter)
+	defer h.Unsubscribe(client)
+
+	// читаем только для обработки pong и закрытия соединения клиентом
+	_ = conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	go func() {
+		defer client.close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(msgType int, msg []byte) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return conn.WriteMessage(msgType, msg) == nil
+	}
+	writeEvent := func(msg Message) bool {
+		body, err := msg.JSON()
+		if err != nil {
+			h.logger.Error("Failed to marshal stream event:", err)
+			return true
+		}
+		return write(websocket.TextMessage, body)
+	}
+
+	for _, msg := range snapshot {
+		if !writeEvent(msg) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-client.Messages():
+			if !writeEvent(msg) {
+				return
+			}
+		case <-ping.C:
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		case <-client.Done():
+			return
+		}
+	}
+}
+
+// ServeSSE отдает те же события как text/event-stream
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "filter ids must be numbers", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, snapshot := h.Subscribe(filter)
+	defer h.Unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(msg Message) bool {
+		body, err := msg.JSON()
+		if err != nil {
+			h.logger.Error("Failed to marshal stream event:", err)
+			return true
+		}
+		if _, err := w.Write([]byte("data: ")); err != nil {
+			return false
+		}
+		if _, err := w.Write(body); err != nil {
+			return false
+		}
+		if _, err := w.Write([]byte("\n\n")); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	for _, msg := range snapshot {
+		if !write(msg) {
+			return
+		}
+	}
+
+	// комментарий раз в pingInterval не дает прокси закрыть простаивающее соединение
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-client.Messages():
+			if !write(msg) {
+				return
+			}
+		case <-ping.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package stream
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bytedance/sonic"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/models/kafkadata"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+// clientBuffer сколько сообщений может накопиться у клиента, после этого он отключается
+const clientBuffer = 256
+
+// Hub рассылает события sender'а подписанным клиентам с учетом их фильтров
+type Hub struct {
+	logger *logger.Logger
+	store  abstruct.StateStore
+
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+	// meta лига и вид спорта матчей, о которых приходили события
+	meta map[int]matchMeta
+}
+
+// Message отфильтрованное для клиента событие. Data имеет тот же тип, что и в OnEvent:
+// []*parsed.Match, []*parsed.Straight или []int
+type Message struct {
+	EventType int
+	Data      any
+}
+
+// Client подписка одного соединения
+type Client struct {
+	filter Filter
+	send   chan Message
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewHub(l *logger.Logger, s abstruct.StateStore) *Hub {
+	return &Hub{
+		logger:  l.Named("stream"),
+		store:   s,
+		clients: make(map[*Client]struct{}),
+		meta:    make(map[int]matchMeta),
+	}
+}
+
+// Subscribe регистрирует клиента и возвращает его вместе со снимком текущего состояния.
+// Клиент регистрируется до снятия снимка, поэтому изменения между ними не теряются
+func (h *Hub) Subscribe(f Filter) (*Client, []Message) {
+	c := &Client{filter: f, send: make(chan Message, clientBuffer), done: make(chan struct{})}
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	return c, h.snapshot(f)
+}
+
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.close()
+}
+
+func (c *Client) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// Messages канал сообщений клиента
+func (c *Client) Messages() <-chan Message {
+	return c.send
+}
+
+// Done закрывается, когда клиент отключен, в том числе из-за переполнения буфера
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// OnEvent реализует abstruct.EventListener
+func (h *Hub) OnEvent(eventType int, data any) {
+	if matches, ok := data.([]*parsed.Match); ok {
+		h.rememberMatches(matches)
+	}
+	// лига и вид спорта ищутся один раз на событие и до блокировки: хранилище может
+	// отвечать долго, а под блокировкой ждали бы Subscribe и Unsubscribe
+	metas := h.resolve(data)
+
+	h.mu.RLock()
+	if len(h.clients) > 0 {
+		// новые матчи и ставки приходят указателями на данные хранилища, а клиенты
+		// кодируют события асинхронно, поэтому рассылаем копию
+		data = cloneData(data)
+	}
+	for c := range h.clients {
+		msg, ok := filterEvent(eventType, data, c.filter, metas)
+		if !ok {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			h.logger.Warn("Stream client is too slow, disconnecting")
+			c.close()
+		}
+	}
+	h.mu.RUnlock()
+
+	if ids, ok := data.([]int); ok && eventType == constants.MATCH_DELETE {
+		h.forgetMatches(ids)
+	}
+}
+
+func (h *Hub) rememberMatches(matches []*parsed.Match) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, m := range matches {
+		if m.League == nil {
+			continue
+		}
+		meta := h.meta[m.ID]
+		if m.League.ID != 0 {
+			meta.leagueID = m.League.ID
+		}
+		if m.League.Sport != nil && m.League.Sport.ID != 0 {
+			meta.sportID = m.League.Sport.ID
+		}
+		h.meta[m.ID] = meta
+	}
+}
+
+func (h *Hub) forgetMatches(ids []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, id := range ids {
+		delete(h.meta, id)
+	}
+}
+
+// resolve лиги и виды спорта матчей события: сначала среди событий, затем в хранилище.
+// Матчей, которых нет ни там, ни там, в результате нет
+func (h *Hub) resolve(data any) map[int]matchMeta {
+	var ids []int
+	switch items := data.(type) {
+	case []*parsed.Match:
+		for _, m := range items {
+			ids = append(ids, m.ID)
+		}
+	case []*parsed.Straight:
+		for _, s := range items {
+			ids = append(ids, s.MatchupID)
+		}
+	case []int:
+		ids = items
+	}
+
+	metas := make(map[int]matchMeta, len(ids))
+	missing := make([]int, 0)
+	h.mu.RLock()
+	for _, id := range ids {
+		if meta, ok := h.meta[id]; ok {
+			metas[id] = meta
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, id := range missing {
+		if _, ok := metas[id]; ok {
+			continue
+		}
+		match, ok := h.store.Match(id)
+		if !ok || match.League == nil || match.League.Sport == nil {
+			continue
+		}
+		metas[id] = matchMeta{sportID: match.League.Sport.ID, leagueID: match.League.ID}
+	}
+	return metas
+}
+
+// filterEvent оставляет в событии только разрешенные фильтром элементы; false если ничего не осталось.
+// MATCH_DELETE неизвестного матча проходит фильтр по лиге и виду спорта: клиент мог получить
+// матч раньше, чем хаб узнал о нем, и без удаления он остался бы у клиента навсегда
+func filterEvent(eventType int, data any, f Filter, metas map[int]matchMeta) (Message, bool) {
+	allow := func(id int) bool {
+		meta, known := metas[id]
+		return f.allowMatch(id, meta, known)
+	}
+
+	msg := Message{EventType: eventType}
+	switch items := data.(type) {
+	case []*parsed.Match:
+		msg.Data = filterSlice(items, func(m *parsed.Match) bool { return allow(m.ID) })
+	case []*parsed.Straight:
+		msg.Data = filterSlice(items, func(s *parsed.Straight) bool {
+			return f.allowMarket(s.Type) && allow(s.MatchupID)
+		})
+	case []int:
+		msg.Data = filterSlice(items, func(id int) bool {
+			if _, known := metas[id]; !known && eventType == constants.MATCH_DELETE {
+				return contains(f.MatchIDs, id)
+			}
+			return allow(id)
+		})
+	default:
+		return msg, false
+	}
+	return msg, msg.Len() > 0
+}
+
+// snapshot текущее состояние хранилища в виде событий MATCH_NEW и BET_NEW
+func (h *Hub) snapshot(f Filter) []Message {
+	matches, bets := h.Snapshot(f)
+
+	msgs := make([]Message, 0, 2)
+	if len(matches) > 0 {
+		msgs = append(msgs, Message{EventType: constants.MATCH_NEW, Data: matches})
+	}
+	if len(bets) > 0 {
+		msgs = append(msgs, Message{EventType: constants.BET_NEW, Data: bets})
+	}
+	return msgs
+}
+
+// Snapshot возвращает копии матчей и рынков хранилища, прошедших фильтр
+func (h *Hub) Snapshot(f Filter) ([]*parsed.Match, []*parsed.Straight) {
+	matches := filterSlice(h.store.MatchList(), func(m *parsed.Match) bool {
+		meta := matchMeta{}
+		if m.League != nil && m.League.Sport != nil {
+			meta = matchMeta{sportID: m.League.Sport.ID, leagueID: m.League.ID}
+		}
+		return f.allowMatch(m.ID, meta, true)
+	})
+
+	bets := make([]*parsed.Straight, 0)
+	for _, m := range matches {
+		bets = append(bets, filterSlice(h.store.MatchBets(m.ID), func(s *parsed.Straight) bool {
+			return f.allowMarket(s.Type)
+		})...)
+	}
+
+	return matches, bets
+}
+
+func cloneData(data any) any {
+	switch items := data.(type) {
+	case []*parsed.Match:
+		out := make([]*parsed.Match, len(items))
+		for i, m := range items {
+			out[i] = m.Clone()
+		}
+		return out
+	case []*parsed.Straight:
+		out := make([]*parsed.Straight, len(items))
+		for i, s := range items {
+			out[i] = s.Clone()
+		}
+		return out
+	}
+	return data
+}
+
+func filterSlice[T any](items []T, keep func(T) bool) []T {
+	out := make([]T, 0, len(items))
+	for _, item := range items {
+		if keep(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Len количество элементов в событии
+func (m Message) Len() int {
+	switch data := m.Data.(type) {
+	case []*parsed.Match:
+		return len(data)
+	case []*parsed.Straight:
+		return len(data)
+	case []int:
+		return len(data)
+	}
+	return 0
+}
+
+// JSON кодирует событие в том же формате, что и сообщения kafka
+func (m Message) JSON() ([]byte, error) {
+	switch data := m.Data.(type) {
+	case []*parsed.Match:
+		return sonic.Marshal(kafkadata.Event[*parsed.Match]{EventType: m.EventType, Source: constants.SOURCE, Data: data})
+	case []*parsed.Straight:
+		return sonic.Marshal(kafkadata.Event[*parsed.Straight]{EventType: m.EventType, Source: constants.SOURCE, Data: data})
+	case []int:
+		return sonic.Marshal(kafkadata.Event[int]{EventType: m.EventType, Source: constants.SOURCE, Data: data})
+	}
+	return nil, fmt.Errorf("unexpected stream data %T", m.Data)
+}
@@ -0,0 +1,221 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pararti/pinnacle-parser/internal/abstruct"
+	"github.com/pararti/pinnacle-parser/internal/models/parsed"
+	"github.com/pararti/pinnacle-parser/internal/storage"
+	"github.com/pararti/pinnacle-parser/pkg/constants"
+	"github.com/pararti/pinnacle-parser/pkg/logger"
+)
+
+func testMatch(id, sportID int) *parsed.Match {
+	return &parsed.Match{ID: id, League: &parsed.League{ID: sportID * 10, Sport: &parsed.Sport{ID: sportID}}}
+}
+
+// newTestHub хаб над хранилищем с матчами 1 (вид спорта 1) и 2 (вид спорта 2)
+func newTestHub(t *testing.T) (*Hub, abstruct.StateStore) {
+	t.Helper()
+	store := storage.NewMapStorage(storage.DeleteGrace{})
+	store.SetMatches(context.Background(), []*parsed.Match{testMatch(1, 1), testMatch(2, 2)})
+	store.SetBets(context.Background(), map[int][]*parsed.Straight{1: {{Key: "s;0;m", MatchupID: 1, Type: "moneyline"}}})
+
+	l := logger.NewLogger()
+	l.Log.SetOutput(io.Discard)
+	return NewHub(l, store), store
+}
+
+func receive(t *testing.T, c *Client) Message {
+	t.Helper()
+	select {
+	case msg := <-c.Messages():
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return Message{}
+	}
+}
+
+func expectNothing(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case msg := <-c.Messages():
+		t.Fatalf("unexpected message %+v", msg)
+	default:
+	}
+}
+
+func TestHubFiltersBySport(t *testing.T) {
+	hub, _ := newTestHub(t)
+	client, snapshot := hub.Subscribe(Filter{SportIDs: map[int]struct{}{1: {}}})
+	defer hub.Unsubscribe(client)
+	if len(snapshot) != 2 || snapshot[0].Len() != 1 || snapshot[1].Len() != 1 {
+		t.Fatalf("snapshot = %+v, want match 1 and its market", snapshot)
+	}
+
+	// лига матчей 1 и 2 берется из хранилища, матч 3 известен по событию
+	hub.OnEvent(constants.MATCH_UPDATE, []*parsed.Match{{ID: 1}, {ID: 2}})
+	if msg := receive(t, client); msg.Len() != 1 || msg.Data.([]*parsed.Match)[0].ID != 1 {
+		t.Fatalf("update = %+v, want match 1 only", msg)
+	}
+	hub.OnEvent(constants.MATCH_NEW, []*parsed.Match{testMatch(3, 1)})
+	receive(t, client)
+	hub.OnEvent(constants.BET_UPDATE, []*parsed.Straight{{MatchupID: 3, Type: "total"}, {MatchupID: 2, Type: "total"}})
+	if msg := receive(t, client); msg.Len() != 1 || msg.Data.([]*parsed.Straight)[0].MatchupID != 3 {
+		t.Fatalf("bets = %+v, want market of match 3 only", msg)
+	}
+
+	// матч неизвестной лиги фильтр по виду спорта не проходит
+	hub.OnEvent(constants.MATCH_UPDATE, []*parsed.Match{{ID: 42}})
+	expectNothing(t, client)
+}
+
+func TestHubFiltersMarketType(t *testing.T) {
+	hub, _ := newTestHub(t)
+	client, _ := hub.Subscribe(Filter{MarketTypes: map[string]struct{}{"total": {}}})
+	defer hub.Unsubscribe(client)
+
+	hub.OnEvent(constants.BET_UPDATE, []*parsed.Straight{{MatchupID: 1, Type: "moneyline"}})
+	expectNothing(t, client)
+	hub.OnEvent(constants.BET_UPDATE, []*parsed.Straight{{MatchupID: 1, Type: "total"}})
+	receive(t, client)
+}
+
+// TestHubDeleteUnknownMatch удаление матча, о котором хаб не знает, доходит до клиентов
+// с фильтром по лиге или виду спорта, а фильтр по id матча по-прежнему действует
+func TestHubDeleteUnknownMatch(t *testing.T) {
+	hub, _ := newTestHub(t)
+	bySport, _ := hub.Subscribe(Filter{SportIDs: map[int]struct{}{1: {}}})
+	defer hub.Unsubscribe(bySport)
+	byMatch, _ := hub.Subscribe(Filter{MatchIDs: map[int]struct{}{7: {}}})
+	defer hub.Unsubscribe(byMatch)
+
+	hub.OnEvent(constants.MATCH_DELETE, []int{42, 2})
+	if msg := receive(t, bySport); msg.Len() != 1 || msg.Data.([]int)[0] != 42 {
+		t.Fatalf("delete = %+v, want unknown match 42 only", msg)
+	}
+	expectNothing(t, byMatch)
+}
+
+// lockingStore хранилище, которое при поиске матча подписывает клиента, как сделал бы
+// параллельный Subscribe. Если поиск идет под блокировкой хаба, OnEvent зависнет
+type lockingStore struct {
+	abstruct.StateStore
+	hub *Hub
+}
+
+func (s *lockingStore) Match(id int) (*parsed.Match, bool) {
+	c, _ := s.hub.Subscribe(Filter{})
+	s.hub.Unsubscribe(c)
+	return s.StateStore.Match(id)
+}
+
+func TestHubResolvesOutsideLock(t *testing.T) {
+	hub, store := newTestHub(t)
+	hub.store = &lockingStore{StateStore: store, hub: hub}
+	client, _ := hub.Subscribe(Filter{SportIDs: map[int]struct{}{1: {}}})
+	defer hub.Unsubscribe(client)
+
+	done := make(chan struct{})
+	go func() {
+		hub.OnEvent(constants.MATCH_UPDATE, []*parsed.Match{{ID: 1}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnEvent looks the match up under the hub lock")
+	}
+	receive(t, client)
+}
+
+func TestServeWS(t *testing.T) {
+	hub, _ := newTestHub(t)
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeWS))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?sport_id=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?match_id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, want := range []string{`"eventType":1,`, `"eventType":4,`} {
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("snapshot message = %s, want %s", body, want)
+		}
+	}
+
+	waitClients(t, hub, 1)
+	hub.OnEvent(constants.MATCH_DELETE, []int{2, 1})
+	_, body, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"data":[1]`) {
+		t.Fatalf("delete = %s, want match 1 only", body)
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	hub, _ := newTestHub(t)
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeSSE))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?match_id=2&market_type=total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	// у матча 2 нет рынков, поэтому снимок это только MATCH_NEW
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"id":2`) {
+		t.Fatalf("snapshot line = %q", line)
+	}
+}
+
+func waitClients(t *testing.T, hub *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.RLock()
+		got := len(hub.clients)
+		hub.mu.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("clients != %d", n)
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+)
+
+// headerCarrier propagation.TextMapCarrier поверх заголовков сообщения kafka
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// Inject добавляет в заголовки traceparent span из ctx
+func Inject(ctx context.Context, headers *[]kafka.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: headers})
+}
+
+// Extract возвращает ctx с удаленным span из заголовков сообщения, если он там есть
+func Extract(ctx context.Context, headers []kafka.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{headers: &headers})
+}
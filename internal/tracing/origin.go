@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxLinks сколько слившихся изменений помнить как ссылки в span отправки
+const maxLinks = 32
+
+// Origin откуда пришли отличия, которые хранилище копит до отправки. Несколько захватов
+// сливаются в одно событие, поэтому отправка продолжает трассу самого старого изменения,
+// а на остальные ставит ссылки
+type Origin struct {
+	parent trace.SpanContext
+	links  []trace.Link
+}
+
+// Add запоминает span, в котором замечено изменение
+func (o *Origin) Add(sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	if !o.parent.IsValid() {
+		o.parent = sc
+		return
+	}
+	// изменения одного захвата идут подряд, поэтому достаточно сравнить с последним
+	if sc.Equal(o.parent) || len(o.links) > 0 && sc.Equal(o.links[len(o.links)-1].SpanContext) {
+		return
+	}
+	if len(o.links) < maxLinks {
+		o.links = append(o.links, trace.Link{SpanContext: sc})
+	}
+}
+
+// Start начинает span, дочерний к самому старому изменению, со ссылками на остальные
+func (o Origin) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if o.parent.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, o.parent)
+	}
+	if len(o.links) > 0 {
+		opts = append(opts, trace.WithLinks(o.links...))
+	}
+	return Tracer().Start(ctx, name, opts...)
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/pararti/pinnacle-parser"
+
+// Tracer трассировщик сервиса. До Init и при выключенной трассировке spans ничего не стоят
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Init настраивает экспорт трасс по OTLP/gRPC на endpoint (host:port коллектора) и W3C
+// propagation. Пустой endpoint оставляет трассировку выключенной. ratio доля трасс, которые
+// записываются, решение принимается в начале трассы и дальше наследуется
+func Init(ctx context.Context, service, endpoint string, ratio float64) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	SetSampleRatio(ratio)
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	res, err := sdkresource.Merge(sdkresource.Default(),
+		sdkresource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil && !errors.Is(err, sdkresource.ErrPartialResource) {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(time.Second)),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(rootSampler)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// ratioSampler доля записываемых трасс, которую можно менять на лету
+type ratioSampler struct {
+	current atomic.Pointer[sdktrace.Sampler]
+}
+
+var rootSampler = &ratioSampler{}
+
+// SetSampleRatio меняет долю записываемых трасс, действует на трассы, начатые после вызова
+func SetSampleRatio(ratio float64) {
+	s := sdktrace.TraceIDRatioBased(ratio)
+	rootSampler.current.Store(&s)
+}
+
+func (r *ratioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if s := r.current.Load(); s != nil {
+		return (*s).ShouldSample(p)
+	}
+	return sdktrace.AlwaysSample().ShouldSample(p)
+}
+
+func (r *ratioSampler) Description() string {
+	if s := r.current.Load(); s != nil {
+		return (*s).Description()
+	}
+	return "unset"
+}
+
+// Fail отмечает span как завершившийся ошибкой
+func Fail(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// End отмечает ошибку, если она есть, и закрывает span
+func End(span trace.Span, err error) {
+	Fail(span, err)
+	span.End()
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestExporter ставит глобальный провайдер с синхронным экспортом в память, как Init ставит OTLP
+func newTestExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(rootSampler)),
+	)
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	SetSampleRatio(1)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+		SetSampleRatio(1)
+	})
+	return exporter
+}
+
+func exported(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q is not exported", name)
+	return tracetest.SpanStub{}
+}
+
+// TestKafkaTrace захват, отправка и обработка консьюмером попадают в одну трассу: отправка
+// продолжает самый старый захват и ссылается на остальные, консьюмер продолжает отправку
+// через заголовки сообщения
+func TestKafkaTrace(t *testing.T) {
+	exporter := newTestExporter(t)
+	ctx := context.Background()
+
+	_, first := Tracer().Start(ctx, "capture.first")
+	first.End()
+	_, second := Tracer().Start(ctx, "capture.second")
+	second.End()
+
+	var origin Origin
+	origin.Add(first.SpanContext())
+	origin.Add(first.SpanContext())
+	origin.Add(second.SpanContext())
+
+	sendCtx, send := origin.Start(ctx, "sender.BET_UPDATE", trace.WithSpanKind(trace.SpanKindProducer))
+	var headers []kafka.Header
+	Inject(sendCtx, &headers)
+	send.End()
+	if len(headers) != 1 || headers[0].Key != "traceparent" {
+		t.Fatalf("headers = %v, want traceparent", headers)
+	}
+
+	_, consume := Tracer().Start(Extract(ctx, headers), "consumer.BET_UPDATE", trace.WithSpanKind(trace.SpanKindConsumer))
+	consume.End()
+
+	sent := exported(t, exporter, "sender.BET_UPDATE")
+	if sent.SpanContext.TraceID() != first.SpanContext().TraceID() || sent.Parent.SpanID() != first.SpanContext().SpanID() {
+		t.Fatalf("send span parent = %s, want first capture %s", sent.Parent.SpanID(), first.SpanContext().SpanID())
+	}
+	if len(sent.Links) != 1 || !sent.Links[0].SpanContext.Equal(second.SpanContext()) {
+		t.Fatalf("send span links = %v, want the second capture", sent.Links)
+	}
+
+	consumed := exported(t, exporter, "consumer.BET_UPDATE")
+	if consumed.SpanContext.TraceID() != sent.SpanContext.TraceID() || consumed.Parent.SpanID() != sent.SpanContext.SpanID() {
+		t.Fatal("consumer span does not continue the send span")
+	}
+	if !consumed.Parent.IsRemote() {
+		t.Fatal("consumer span parent is not remote")
+	}
+}
+
+// TestSampleRatio доля применяется к новым трассам, а продолжение трассы из kafka наследует
+// решение отправителя
+func TestSampleRatio(t *testing.T) {
+	exporter := newTestExporter(t)
+	ctx := context.Background()
+
+	_, sampled := Tracer().Start(ctx, "capture")
+	sampled.End()
+	var headers []kafka.Header
+	Inject(trace.ContextWithSpan(ctx, sampled), &headers)
+
+	SetSampleRatio(0)
+	_, dropped := Tracer().Start(ctx, "capture.dropped")
+	dropped.End()
+	_, continued := Tracer().Start(Extract(ctx, headers), "consumer")
+	continued.End()
+
+	names := make(map[string]bool)
+	for _, span := range exporter.GetSpans() {
+		names[span.Name] = true
+	}
+	if names["capture.dropped"] {
+		t.Fatal("new trace is exported with ratio 0")
+	}
+	if !names["consumer"] {
+		t.Fatal("continued trace is not exported with ratio 0")
+	}
+}
@@ -7,6 +7,13 @@ const (
 	MATCH_DELETE
 	BET_NEW
 	BET_UPDATE
+	MATCH_SNAPSHOT
+	BET_SNAPSHOT
+	// MATCH_RESTORED матч снова пришел от источника в пределах deleteGrace, данные полные
+	MATCH_RESTORED
 )
 const SOURCE = "p" //pinnacle
 const TOPIC = "bookmaker_events"
+
+// HEADER_EVENT_TYPE заголовок kafka с типом события, позволяет не разбирать тело сообщения
+const HEADER_EVENT_TYPE = "eventType"
@@ -1,52 +1,217 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-//TODO прикрутить вывод ошибок в тг
+// Fields поля структурированной записи
+type Fields map[string]any
+
+// Config настройки вывода логов
+type Config struct {
+	// Level уровень по умолчанию: debug, info, warn, error
+	Level string
+	// Levels уровни для отдельных пакетов, ключ — имя из Named
+	Levels map[string]string
+	// Format text или json
+	Format string
+	// File файл, в который логи пишутся вместе с stdout. MaxSizeMB, MaxBackups и MaxAgeDays
+	// задают ротацию, нулевые значения — значения lumberjack по умолчанию
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// levels уровни, общие для логгера и всех его потомков
+type levels struct {
+	byPkg    map[string]logrus.Level
+	fallback logrus.Level
+}
 
 type Logger struct {
 	Log *logrus.Logger
+
+	entry  *logrus.Entry
+	pkg    string
+	levels *atomic.Pointer[levels]
+	file   *atomic.Pointer[lumberjack.Logger]
 }
 
 func NewLogger() *Logger {
 	l := logrus.New()
-	l.SetFormatter(&logrus.TextFormatter{
+	l.SetFormatter(textFormatter())
+	l.Out = os.Stdout
+	// уровни проверяет сам Logger, logrus пропускает все записи
+	l.SetLevel(logrus.TraceLevel)
+
+	lv := &atomic.Pointer[levels]{}
+	lv.Store(&levels{fallback: logrus.InfoLevel})
+	return &Logger{Log: l, entry: logrus.NewEntry(l), levels: lv, file: &atomic.Pointer[lumberjack.Logger]{}}
+}
+
+// Configure применяет формат, уровни и файл логов. Действует на логгер и всех, полученных
+// из него через With и Named
+func (l *Logger) Configure(c Config) error {
+	lv, err := parseLevels(c)
+	if err != nil {
+		return err
+	}
+
+	switch c.Format {
+	case "json":
+		l.Log.SetFormatter(&logrus.JSONFormatter{TimestampFormat: "2006-01-02T15:04:05.000Z07:00"})
+	case "text", "":
+		l.Log.SetFormatter(textFormatter())
+	default:
+		return fmt.Errorf("неизвестный формат логов %q", c.Format)
+	}
+
+	// при перезагрузке настроек файл обычно не меняется: открытый lumberjack сохраняется,
+	// иначе каждая перезагрузка закрывала бы файл и сбивала счетчик размера до ротации
+	if !sameFile(l.file.Load(), c) {
+		var out io.Writer = os.Stdout
+		var file *lumberjack.Logger
+		if c.File != "" {
+			file = &lumberjack.Logger{
+				Filename:   c.File,
+				MaxSize:    c.MaxSizeMB,
+				MaxBackups: c.MaxBackups,
+				MaxAge:     c.MaxAgeDays,
+			}
+			out = io.MultiWriter(os.Stdout, file)
+		}
+		l.Log.SetOutput(out)
+		if prev := l.file.Swap(file); prev != nil {
+			_ = prev.Close()
+		}
+	}
+
+	l.levels.Store(lv)
+	return nil
+}
+
+// sameFile пишет ли file туда и с той же ротацией, что задана в c
+func sameFile(file *lumberjack.Logger, c Config) bool {
+	if file == nil {
+		return c.File == ""
+	}
+	return file.Filename == c.File && file.MaxSize == c.MaxSizeMB &&
+		file.MaxBackups == c.MaxBackups && file.MaxAge == c.MaxAgeDays
+}
+
+func parseLevels(c Config) (*levels, error) {
+	lv := &levels{fallback: logrus.InfoLevel, byPkg: make(map[string]logrus.Level, len(c.Levels))}
+	if c.Level != "" {
+		level, err := logrus.ParseLevel(c.Level)
+		if err != nil {
+			return nil, err
+		}
+		lv.fallback = level
+	}
+	for pkg, name := range c.Levels {
+		level, err := logrus.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("уровень логов пакета %s: %w", pkg, err)
+		}
+		lv.byPkg[strings.ToLower(pkg)] = level
+	}
+	return lv, nil
+}
+
+func textFormatter() logrus.Formatter {
+	return &logrus.TextFormatter{
 		TimestampFormat: "2006-01-02 15:04:05",
 		FullTimestamp:   true,
-	})
-	l.Out = os.Stdout
+	}
+}
+
+// With возвращает логгер, добавляющий поля к каждой записи
+func (l *Logger) With(fields Fields) *Logger {
+	child := *l
+	child.entry = l.entry.WithFields(logrus.Fields(fields))
+	return &child
+}
 
-	return &Logger{Log: l}
+// Named возвращает логгер пакета pkg: записи получают поле pkg, а уровень берется
+// из Config.Levels[pkg], если он там задан
+func (l *Logger) Named(pkg string) *Logger {
+	child := l.With(Fields{"pkg": pkg})
+	child.pkg = strings.ToLower(pkg)
+	return child
+}
+
+func (l *Logger) enabled(level logrus.Level) bool {
+	if level <= logrus.FatalLevel {
+		return true
+	}
+	lv := l.levels.Load()
+	threshold, ok := lv.byPkg[l.pkg]
+	if !ok {
+		threshold = lv.fallback
+	}
+	return level <= threshold
+}
+
+// log пишет запись, аргументы разделяются пробелами как в fmt.Sprintln
+func (l *Logger) log(level logrus.Level, data []any) {
+	if l.enabled(level) {
+		l.entry.Log(level, strings.TrimSuffix(fmt.Sprintln(data...), "\n"))
+	}
+}
+
+func (l *Logger) logf(level logrus.Level, format string, args []any) {
+	if l.enabled(level) {
+		l.entry.Log(level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Debug(data ...any) {
+	l.log(logrus.DebugLevel, data)
 }
 
 func (l *Logger) Info(data ...any) {
-	l.Log.Info(data)
+	l.log(logrus.InfoLevel, data)
 }
 
 func (l *Logger) Warn(data ...any) {
-	l.Log.Warn(data)
+	l.log(logrus.WarnLevel, data)
 }
 
 func (l *Logger) Error(data ...any) {
-	l.Log.Error(data)
+	l.log(logrus.ErrorLevel, data)
 }
 
+// Fatal пишет запись независимо от уровня и завершает процесс
 func (l *Logger) Fatal(data ...any) {
-	l.Log.Fatal(data)
+	l.log(logrus.FatalLevel, data)
+	l.Log.Exit(1)
 }
 
-func (l *Logger) SetPath(path string) {
-	logFile, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		l.Error("Не удалось открыть/создать файл " + path + err.Error())
-	} else {
-		mw := io.MultiWriter(os.Stdout, logFile)
-		l.Log.SetOutput(mw)
-	}
+func (l *Logger) Debugf(format string, args ...any) {
+	l.logf(logrus.DebugLevel, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...any) {
+	l.logf(logrus.InfoLevel, format, args)
+}
+
+func (l *Logger) Warnf(format string, args ...any) {
+	l.logf(logrus.WarnLevel, format, args)
+}
+
+func (l *Logger) Errorf(format string, args ...any) {
+	l.logf(logrus.ErrorLevel, format, args)
+}
 
+func (l *Logger) Fatalf(format string, args ...any) {
+	l.logf(logrus.FatalLevel, format, args)
+	l.Log.Exit(1)
 }
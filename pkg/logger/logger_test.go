@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+)
+
+func newTestLogger(t *testing.T, c Config) (*Logger, *bytes.Buffer) {
+	t.Helper()
+	l := NewLogger()
+	if err := l.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	l.Log.SetOutput(&out)
+	return l, &out
+}
+
+func TestNamedLevels(t *testing.T) {
+	l, out := newTestLogger(t, Config{Level: "warn", Levels: map[string]string{"Storage": "debug"}})
+	storage, kafka := l.Named("storage"), l.Named("kafka")
+
+	storage.Debug("storage debug")
+	kafka.Info("kafka info")
+	kafka.Warn("kafka warn")
+	l.Info("root info")
+
+	got := out.String()
+	for _, want := range []string{"storage debug", "pkg=storage", "kafka warn"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %q in:\n%s", want, got)
+		}
+	}
+	for _, skip := range []string{"kafka info", "root info"} {
+		if strings.Contains(got, skip) {
+			t.Errorf("%q is below the level:\n%s", skip, got)
+		}
+	}
+
+	// новые уровни действуют на уже созданные логгеры
+	if err := l.Configure(Config{Level: "info"}); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	storage.Debug("storage debug")
+	kafka.Info("kafka info")
+	if got := out.String(); strings.Contains(got, "storage debug") || !strings.Contains(got, "kafka info") {
+		t.Fatalf("after reconfigure:\n%s", got)
+	}
+}
+
+func TestConfigureRejectsBadLevels(t *testing.T) {
+	l := NewLogger()
+	for _, c := range []Config{{Level: "loud"}, {Levels: map[string]string{"kafka": "loud"}}, {Format: "xml"}} {
+		if err := l.Configure(c); err == nil {
+			t.Errorf("Configure(%+v) accepted", c)
+		}
+	}
+}
+
+func TestJSONWithFields(t *testing.T) {
+	l, out := newTestLogger(t, Config{Format: "json"})
+	l.Named("sender").With(Fields{"match": 7}).Warnf("odds %d", 3)
+
+	var entry map[string]any
+	if err := sonic.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if entry["msg"] != "odds 3" || entry["level"] != "warning" || entry["pkg"] != "sender" || entry["match"] != float64(7) {
+		t.Fatalf("entry = %v", entry)
+	}
+
+	// With не добавляет поля родителю
+	out.Reset()
+	l.Info("plain")
+	if strings.Contains(out.String(), "match") {
+		t.Fatalf("parent got child fields: %s", out)
+	}
+}
+
+func TestConfigureFile(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{File: filepath.Join(dir, "parser.log"), MaxSizeMB: 10}
+	l := NewLogger()
+	if err := l.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	file := l.file.Load()
+	if file == nil || file.Filename != c.File {
+		t.Fatalf("file = %v", file)
+	}
+
+	// тот же файл при смене уровня не переоткрывается
+	c.Level = "debug"
+	if err := l.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if l.file.Load() != file {
+		t.Fatal("unchanged file settings replaced the writer")
+	}
+
+	c.File = filepath.Join(dir, "other.log")
+	if err := l.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if next := l.file.Load(); next == file || next.Filename != c.File {
+		t.Fatalf("file = %v, want %s", next, c.File)
+	}
+	l.Info("to file")
+	body, err := os.ReadFile(c.File)
+	if err != nil || !strings.Contains(string(body), "to file") {
+		t.Fatalf("file content %q, %v", body, err)
+	}
+
+	if err := l.Configure(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if l.file.Load() != nil {
+		t.Fatal("file is still open after it was removed from the config")
+	}
+}